@@ -0,0 +1,19 @@
+package main
+
+import (
+	"errors"
+	"io"
+
+	"csce-3550_jwks-srv/internal/httpserver"
+)
+
+const configUsage = "usage: jwks-srv [-config file] [flags] config print"
+
+// runConfig handles `jwks-srv config print` - validates the layered config and
+// prints every setting with where it came from, secrets redacted
+func runConfig(sources httpserver.ConfigSources, args []string, out io.Writer) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New(configUsage)
+	}
+	return httpserver.PrintConfig(sources, out)
+}
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"csce-3550_jwks-srv/internal/httpserver"
+	"csce-3550_jwks-srv/internal/jwksclient"
+	"csce-3550_jwks-srv/internal/jwt"
 	"csce-3550_jwks-srv/internal/keys"
 )
 
@@ -163,3 +165,62 @@ func TestRegisterEndpointDuplicateIntegration(t *testing.T) {
 			http.StatusConflict, resp2.StatusCode)
 	}
 }
+
+func TestJWKSClientVerifiesIssuedTokenIntegration(t *testing.T) {
+	// isolate the database so keys from other runs don't collide
+	t.Chdir(t.TempDir())
+
+	testKey := "test-encryption-key-32-bytes-lng"
+	manager, err := keys.NewManager(time.Hour, time.Hour*24, testKey)
+	if err != nil {
+		t.Fatalf("Failed to create key manager: %v", err)
+	}
+	if err := manager.Start(); err != nil {
+		t.Fatalf("Failed to start key manager: %v", err)
+	}
+	defer manager.Stop()
+
+	config := &httpserver.Config{
+		KeyLifetime:     time.Hour,
+		KeyRetainPeriod: time.Hour * 24,
+		JWTLifetime:     time.Minute * 30,
+		Issuer:          "test-issuer",
+		EncryptionKey:   testKey,
+	}
+
+	ts := httptest.NewServer(httpserver.NewSrv(manager, config).Handler())
+	defer ts.Close()
+
+	// register and log in to get a token
+	password, err := manager.CreateUser("clientuser", "client@example.com")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/auth", nil)
+	req.SetBasicAuth("clientuser", password)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make POST request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var authResp map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		t.Fatalf("Failed to decode auth response: %v", err)
+	}
+
+	// verify through the client against the live JWKS endpoint
+	client := jwksclient.NewClient(ts.URL+"/.well-known/jwks.json", jwksclient.Options{})
+	claims, err := jwt.VerifyJWT(authResp["token"], client.KeyFunc, jwt.VerifyOptions{
+		Issuer:   "test-issuer",
+		Audience: []string{jwt.DefaultAudience},
+	})
+	if err != nil {
+		t.Fatalf("VerifyJWT() error = %v", err)
+	}
+
+	if claims.Custom["preferred_username"] != "clientuser" {
+		t.Errorf("Expected preferred_username clientuser, got %v", claims.Custom["preferred_username"])
+	}
+}
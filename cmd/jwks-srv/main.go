@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
-	"time"
 
+	"csce-3550_jwks-srv/internal/crypto"
+	"csce-3550_jwks-srv/internal/db"
 	"csce-3550_jwks-srv/internal/httpserver"
 	"csce-3550_jwks-srv/internal/keys"
 )
@@ -16,51 +20,241 @@ func main() {
 	// intitialize logger
 	logger := log.New(os.Stdout, "jwsk-srv: ", log.LstdFlags)
 
-	// load config from env vars
-	config, err := httpserver.NewConfig()
+	// settings layer as flags > env > config file > defaults
+	configFlags := httpserver.RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+	sources := configFlags.Sources()
+
+	// unseal tooling talks to a running server and never needs the server config
+	if flag.NArg() > 0 && flag.Arg(0) == "unseal" {
+		if err := runUnseal(flag.Args()[1:], os.Getenv("UNSEAL_TOKEN"), os.Stdin, os.Stdout, nil); err != nil {
+			logger.Fatalf("Unseal error: %v", err)
+		}
+		return
+	}
+	if flag.NArg() > 0 && flag.Arg(0) == "split-secret" {
+		if err := runSplitSecret(flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+			logger.Fatalf("Split error: %v", err)
+		}
+		return
+	}
+
+	// effective config, secrets redacted
+	if flag.NArg() > 0 && flag.Arg(0) == "config" {
+		if err := runConfig(sources, flag.Args()[1:], os.Stdout); err != nil {
+			logger.Fatalf("Config error: %v", err)
+		}
+		return
+	}
+
+	// schema maintenance runs instead of the server - needs only the database path
+	if flag.NArg() > 0 && flag.Arg(0) == "migrate" {
+		dbPath, err := sources.DatabasePath()
+		if err != nil {
+			logger.Fatalf("Config error: %v", err)
+		}
+		if err := runMigrate(dbPath, flag.Args()[1:], os.Stdout); err != nil {
+			logger.Fatalf("Migrate error: %v", err)
+		}
+		return
+	}
+
+	// load config from flags, env vars and the config file
+	config, err := httpserver.LoadConfig(sources)
 	if err != nil {
 		logger.Fatalf("Config error: %v", err)
 	}
 
-	// key manager initialization
-	manager, err := keys.NewManager(config.KeyLifetime, config.KeyRetainPeriod, config.EncryptionKey)
-	if err != nil {
-		logger.Fatalf("Key manager initialization error: %v", err)
+	// master key rotation runs instead of the server
+	if flag.NArg() > 0 && flag.Arg(0) == "rekey" {
+		if err := runRekey(config, flag.Args()[1:], os.Stdout); err != nil {
+			logger.Fatalf("Rekey error: %v", err)
+		}
+		return
 	}
 
-	// start manager
-	if err := manager.Start(); err != nil {
-		logger.Fatalf("Key manager start error: %v", err)
+	// storage for keys, users and auth logs - opened by unseal when started sealed
+	var storeMu sync.Mutex
+	var store db.Store
+	closeStore := func() {
+		storeMu.Lock()
+		defer storeMu.Unlock()
+		if store != nil {
+			store.Close()
+		}
 	}
+	defer closeStore()
 
 	// http server creation
-	server := httpserver.NewSrv(manager, config)
+	var server *httpserver.Server
+	if config.Sealed {
+		// public keys from the last run keep verifiers working until unsealed
+		cachedJWKS, err := db.ReadJWKSCache(config.DatabasePath)
+		if err != nil {
+			logger.Printf("No cached JWKS to serve while sealed: %v", err)
+		}
+
+		server = httpserver.NewSealedSrv(config, cachedJWKS, unsealFunc(config, func(opened db.Store) {
+			storeMu.Lock()
+			store = opened
+			storeMu.Unlock()
+		}))
+		logger.Println("Starting sealed - waiting for POST /admin/unseal")
+	} else {
+		opened, err := openStore(config)
+		if err != nil {
+			logger.Fatalf("Storage initialization error: %v", err)
+		}
+		store = opened
+
+		manager, err := startManager(store, config)
+		if err != nil {
+			logger.Fatalf("Key manager error: %v", err)
+		}
+		server = httpserver.NewSrv(manager, config)
+	}
 
 	// channel for OS sig
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGHUP reloads the config - ReloadConfig logs the diff and any rejection
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			logger.Println("SIGHUP received, reloading config")
+			server.ReloadConfig()
+		}
+	}()
+
 	// spin up http srv in a goroutine
+	serveErr := make(chan error, 1)
 	go func() {
-		logger.Println("Server starting on :8080")
-		if err := server.Waiter(":8080"); err != nil {
-			logger.Printf("HTTP server error: %v", err)
+		scheme := "http"
+		if server.TLS() {
+			scheme = "https"
 		}
+		logger.Printf("Server starting on %s (%s)", config.ListenAddr, scheme)
+		serveErr <- server.Waiter(config.ListenAddr)
 	}()
 
-	// hold off until signal is recieved
-	<-sigCh
-	logger.Println("Termination signal recieved")
+	// hold off until signal is recieved - or the listener fails, e.g. a bad certificate
+	exitCode := 0
+	select {
+	case <-sigCh:
+		logger.Println("Termination signal recieved")
+	case err := <-serveErr:
+		logger.Printf("HTTP server error: %v", err)
+		exitCode = 1
+	}
 
 	// graceful death
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
 	defer cancel()
 
-	// stop manager first
-	manager.Stop()
+	// stop manager first - none yet if still sealed
+	if manager := server.Manager(); manager != nil {
+		manager.Stop()
+	}
 
 	if err := server.Death(ctx); err != nil {
 		logger.Printf("Issue during death: %v", err)
 	}
 	logger.Println("SRV halted safely")
+
+	if exitCode != 0 {
+		closeStore() // os.Exit skips the deferred close
+		os.Exit(exitCode)
+	}
+}
+
+// key manager on store with the configured algorithm, rotation and cleanup policy, started
+func startManager(store db.Store, config *httpserver.Config) (*keys.Manager, error) {
+	manager := keys.NewManagerWithStore(store, config.KeyLifetime, config.KeyRetainPeriod)
+
+	// algorithm, rotation and cleanup policy - reloads apply it again
+	if err := httpserver.ConfigureManager(manager, config); err != nil {
+		return nil, fmt.Errorf("configuration: %w", err)
+	}
+
+	// start manager
+	if err := manager.Start(); err != nil {
+		return nil, fmt.Errorf("start: %w", err)
+	}
+	return manager, nil
+}
+
+// unseal for a server started sealed - opens the SQLite store with the submitted
+// NOT_MY_KEY, rejects it unless it decrypts the stored keys, then starts the
+// key manager. opened receives the store so it can be closed on shutdown.
+func unsealFunc(config *httpserver.Config, opened func(db.Store)) httpserver.UnsealFunc {
+	return func(secret string) (*keys.Manager, error) {
+		unsealed := *config
+		unsealed.EncryptionKey = secret
+
+		store, err := openSQLiteStore(&unsealed)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.VerifyMasterKey(); err != nil {
+			store.Close()
+			return nil, err
+		}
+
+		manager, err := startManager(store, &unsealed)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		opened(store)
+		return manager, nil
+	}
+}
+
+// open the configured storage backend - the memory store needs neither CGO nor disk
+func openStore(config *httpserver.Config) (db.Store, error) {
+	if config.StorageBackend == db.BackendMemory {
+		return db.NewMemoryStore(), nil
+	}
+	return openSQLiteStore(config)
+}
+
+// open the encrypted SQLite store with the configured key-encryption key
+func openSQLiteStore(config *httpserver.Config) (*db.Manager, error) {
+	if config.KEKProvider == "" || config.KEKProvider == httpserver.KEKProviderEnv {
+		return db.NewManager(config.DatabasePath, config.EncryptionKey, config.PreviousEncryptionKeys...)
+	}
+
+	var kek crypto.KEKProvider
+	var err error
+	switch config.KEKProvider {
+	case httpserver.KEKProviderFile:
+		kek, err = crypto.NewKeyFileKEK(config.KEKFile)
+	case httpserver.KEKProviderKMS:
+		kek, err = crypto.NewHTTPKEK(config.KEKURL, config.KEKKeyID, config.KEKToken, nil)
+	default:
+		err = fmt.Errorf("unsupported KEK provider %q", config.KEKProvider)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []crypto.KEKProvider
+	for _, path := range config.KEKPreviousFiles {
+		old, err := crypto.NewKeyFileKEK(path)
+		if err != nil {
+			return nil, fmt.Errorf("previous KEK: %w", err)
+		}
+		previous = append(previous, old)
+	}
+
+	// NOT_MY_KEY values still open keys written before the move off the passphrase
+	var passphrases []string
+	if config.EncryptionKey != "" {
+		passphrases = append(passphrases, config.EncryptionKey)
+	}
+	passphrases = append(passphrases, config.PreviousEncryptionKeys...)
+
+	return db.NewManagerWithKEK(config.DatabasePath, kek, previous, passphrases...)
 }
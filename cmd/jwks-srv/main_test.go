@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"csce-3550_jwks-srv/internal/crypto"
+	"csce-3550_jwks-srv/internal/db"
 	"csce-3550_jwks-srv/internal/httpserver"
 	"csce-3550_jwks-srv/internal/keys"
 )
@@ -129,3 +136,272 @@ Create comprehensive unit tests for a Go JWKS server project with the following
 
 Generate complete, runnable test files that follow Go testing best practices and achieve the coverage targets while maintaining the established coding style.
 */
+
+func TestRunMigrate(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// the default database must exist before the CLI touches it
+	if _, err := runMigrateOutput(t, "status"); err == nil {
+		t.Error("Expected migrate status to fail without a database")
+	}
+
+	database, err := db.NewDatabase()
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	database.Close()
+
+	out, err := runMigrateOutput(t, "status")
+	if err != nil {
+		t.Fatalf("migrate status error = %v", err)
+	}
+	if !strings.Contains(out, "0001_initial\tapplied") {
+		t.Errorf("Expected initial migration applied, got:\n%s", out)
+	}
+
+	out, err = runMigrateOutput(t, "down", "0")
+	if err != nil {
+		t.Fatalf("migrate down error = %v", err)
+	}
+	if !strings.Contains(out, "schema version: 0") || !strings.Contains(out, "0001_initial\tpending") {
+		t.Errorf("Expected initial migration pending after down, got:\n%s", out)
+	}
+
+	if _, err := runMigrateOutput(t, "up"); err != nil {
+		t.Fatalf("migrate up error = %v", err)
+	}
+
+	if _, err := runMigrateOutput(t, "sideways"); err == nil {
+		t.Error("Expected usage error for unknown subcommand")
+	}
+}
+
+func runMigrateOutput(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := runMigrate("", args, &out)
+	return out.String(), err
+}
+
+func TestRunRekey(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "rekey.db")
+
+	oldStore, err := db.NewManager(dbPath, "old-master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	privateKey, err := keys.GenerateRSAKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateRSAKeyPair() error = %v", err)
+	}
+	if _, err := oldStore.StoreKey(privateKey.PrivateKey, "RS256", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
+	}
+	oldStore.Close()
+
+	var out bytes.Buffer
+	config := &httpserver.Config{
+		DatabasePath:           dbPath,
+		EncryptionKey:          "new-master-key",
+		PreviousEncryptionKeys: []string{"old-master-key"},
+	}
+	if err := runRekey(config, nil, &out); err != nil {
+		t.Fatalf("rekey error = %v", err)
+	}
+	if !strings.Contains(out.String(), "re-encrypted 1 keys") {
+		t.Errorf("Expected one key re-encrypted, got: %s", out.String())
+	}
+
+	// previous key no longer needed
+	newStore, err := db.NewManager(dbPath, "new-master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer newStore.Close()
+	if validKeys, err := newStore.GetValidKeys(); err != nil || len(validKeys) != 1 {
+		t.Errorf("GetValidKeys() after rekey = %d keys, %v", len(validKeys), err)
+	}
+
+	if err := runRekey(config, []string{"extra"}, &out); err == nil {
+		t.Error("Expected usage error for unexpected arguments")
+	}
+}
+
+func TestOpenSQLiteStoreWithKEKFile(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "kek.db")
+	keyFile := filepath.Join(dir, "kek")
+	if err := crypto.GenerateKeyFile(keyFile); err != nil {
+		t.Fatalf("GenerateKeyFile() error = %v", err)
+	}
+
+	// a key from the passphrase era
+	passphraseStore, err := db.NewManager(dbPath, "master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	privateKey, _ := keys.GenerateRSAKeyPair()
+	if _, err := passphraseStore.StoreKey(privateKey.PrivateKey, "RS256", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
+	}
+	passphraseStore.Close()
+
+	config := &httpserver.Config{
+		DatabasePath:  dbPath,
+		EncryptionKey: "master-key",
+		KEKProvider:   httpserver.KEKProviderFile,
+		KEKFile:       keyFile,
+	}
+
+	var out bytes.Buffer
+	if err := runRekey(config, nil, &out); err != nil {
+		t.Fatalf("rekey error = %v", err)
+	}
+	if !strings.Contains(out.String(), "re-encrypted 1 keys") {
+		t.Errorf("Expected one key re-encrypted, got: %s", out.String())
+	}
+
+	// the passphrase can leave the environment
+	config.EncryptionKey = ""
+	store, err := openSQLiteStore(config)
+	if err != nil {
+		t.Fatalf("openSQLiteStore() error = %v", err)
+	}
+	defer store.Close()
+	if validKeys, err := store.GetValidKeys(); err != nil || len(validKeys) != 1 {
+		t.Errorf("GetValidKeys() with only the key file = %d keys, %v", len(validKeys), err)
+	}
+
+	config.KEKFile = filepath.Join(dir, "missing")
+	if _, err := openSQLiteStore(config); err == nil {
+		t.Error("Expected error for a missing key file")
+	}
+}
+
+func TestSealedStartup(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "sealed.db")
+	config := &httpserver.Config{
+		KeyLifetime:      time.Hour,
+		KeyRetainPeriod:  time.Hour,
+		KeyLeadTime:      time.Minute,
+		KeyCleanup:       time.Hour,
+		JWTLifetime:      5 * time.Minute,
+		SigningAlgorithm: keys.AlgorithmRS256,
+		RSAKeySize:       keys.DefaultRSAKeySize,
+		StorageBackend:   db.BackendSQLite,
+		DatabasePath:     dbPath,
+		EncryptionKey:    "master-secret",
+	}
+
+	// a previous unsealed run publishes keys and leaves the JWKS behind
+	store, err := openSQLiteStore(config)
+	if err != nil {
+		t.Fatalf("openSQLiteStore() error = %v", err)
+	}
+	manager, err := startManager(store, config)
+	if err != nil {
+		t.Fatalf("startManager() error = %v", err)
+	}
+	published, err := manager.JWKSJSON()
+	if err != nil {
+		t.Fatalf("JWKSJSON() error = %v", err)
+	}
+	manager.Stop()
+	store.Close()
+
+	cached, err := db.ReadJWKSCache(dbPath)
+	if err != nil {
+		t.Fatalf("ReadJWKSCache() error = %v", err)
+	}
+	if !bytes.Equal(cached, published) {
+		t.Errorf("Expected cached JWKS to match the published one")
+	}
+
+	sealedConfig := *config
+	sealedConfig.EncryptionKey = ""
+	sealedConfig.Sealed = true
+	sealedConfig.UnsealToken = "unseal-token"
+	sealedConfig.UnsealThreshold = 2
+
+	var opened db.Store
+	server := httpserver.NewSealedSrv(&sealedConfig, cached, unsealFunc(&sealedConfig, func(s db.Store) { opened = s }))
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	var shares bytes.Buffer
+	if err := runSplitSecret([]string{"-shares", "3", "-threshold", "2"}, strings.NewReader("master-secret\n"), &shares); err != nil {
+		t.Fatalf("split-secret error = %v", err)
+	}
+	lines := strings.Fields(shares.String())
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 shares, got %d", len(lines))
+	}
+
+	// shares of another secret are rejected without opening anything
+	var wrong bytes.Buffer
+	if err := runSplitSecret([]string{"-shares", "2", "-threshold", "2"}, strings.NewReader("wrong-secret\n"), &wrong); err != nil {
+		t.Fatalf("split-secret error = %v", err)
+	}
+	var out bytes.Buffer
+	for i, share := range strings.Fields(wrong.String()) {
+		err := runUnseal([]string{"-addr", ts.URL, "-share"}, "unseal-token", strings.NewReader(share), &out, ts.Client())
+		if i == 1 && (err == nil || !strings.Contains(err.Error(), "403")) {
+			t.Errorf("Expected wrong secret to be rejected with 403, got %v", err)
+		}
+	}
+	if !server.Sealed() || opened != nil {
+		t.Fatal("Expected server to stay sealed after a wrong secret")
+	}
+
+	if err := runUnseal([]string{"-addr", ts.URL, "-share"}, "bad-token", strings.NewReader(lines[0]), &out, ts.Client()); err == nil {
+		t.Error("Expected a bad token to be rejected")
+	}
+
+	out.Reset()
+	if err := runUnseal([]string{"-addr", ts.URL, "-share"}, "unseal-token", strings.NewReader(lines[2]), &out, ts.Client()); err != nil {
+		t.Fatalf("unseal error = %v", err)
+	}
+	if !strings.Contains(out.String(), "1 of 2") {
+		t.Errorf("Expected progress report, got: %s", out.String())
+	}
+	out.Reset()
+	if err := runUnseal([]string{"-addr", ts.URL, "-share"}, "unseal-token", strings.NewReader(lines[0]), &out, ts.Client()); err != nil {
+		t.Fatalf("unseal error = %v", err)
+	}
+	if !strings.Contains(out.String(), "unsealed") {
+		t.Errorf("Expected unsealed, got: %s", out.String())
+	}
+
+	if server.Sealed() || opened == nil {
+		t.Fatal("Expected server to be unsealed with its store open")
+	}
+	defer opened.Close()
+	defer server.Manager().Stop()
+
+	// the unsealed manager reads the keys from the previous run
+	if validKeys, err := opened.GetValidKeys(); err != nil || len(validKeys) == 0 {
+		t.Errorf("GetValidKeys() after unseal = %d keys, %v", len(validKeys), err)
+	}
+
+	if err := runUnseal(nil, "", strings.NewReader("x"), &out, nil); err == nil {
+		t.Error("Expected an error without UNSEAL_TOKEN")
+	}
+}
+
+func TestRunConfigPrint(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("NOT_MY_KEY", "do-not-print-me")
+
+	var out bytes.Buffer
+	sources := httpserver.ConfigSources{Flags: map[string]string{"listen_addr": ":9999"}}
+	if err := runConfig(sources, []string{"print"}, &out); err != nil {
+		t.Fatalf("config print error = %v", err)
+	}
+	if strings.Contains(out.String(), "do-not-print-me") || !strings.Contains(out.String(), `listen_addr: ":9999" # flag -listen-addr`) {
+		t.Errorf("Unexpected config print output:\n%s", out.String())
+	}
+
+	if err := runConfig(sources, nil, &out); err == nil {
+		t.Error("Expected usage error without print")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"csce-3550_jwks-srv/internal/db"
+)
+
+const migrateUsage = "usage: jwks-srv [-db-path file | -data-dir dir] migrate status | up | down <version>"
+
+// runMigrate handles `jwks-srv migrate ...` against the database at dbPath
+func runMigrate(dbPath string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	database, err := db.OpenDatabase(dbPath)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "status":
+		return printMigrationStatus(database, out)
+	case "up":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		if err := database.Migrate(); err != nil {
+			return err
+		}
+	case "down":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		target, err := strconv.Atoi(args[1])
+		if err != nil || target < 0 {
+			return fmt.Errorf("invalid target version %q", args[1])
+		}
+		if err := database.MigrateTo(target); err != nil {
+			return err
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return printMigrationStatus(database, out)
+}
+
+// one line per migration, newest schema version first line
+func printMigrationStatus(database *db.Database, out io.Writer) error {
+	version, err := database.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	statuses, err := database.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "schema version: %d\n", version)
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + status.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%04d_%s\t%s\n", status.Version, status.Name, state)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"csce-3550_jwks-srv/internal/httpserver"
+)
+
+const rekeyUsage = "usage: NOT_MY_KEY=<new> NOT_MY_KEY_PREVIOUS=<old> jwks-srv [-db-path file | -data-dir dir] rekey"
+
+// runRekey handles `jwks-srv rekey` - re-encrypts every stored key under the
+// current master key or KEK so the previous ones can be dropped from the config
+func runRekey(config *httpserver.Config, args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return errors.New(rekeyUsage)
+	}
+
+	manager, err := openSQLiteStore(config)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	count, err := manager.ReencryptKeys()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "re-encrypted %d keys under master key %08x\n", count, manager.MasterKeyID())
+	return nil
+}
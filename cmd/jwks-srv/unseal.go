@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"csce-3550_jwks-srv/internal/crypto"
+	"csce-3550_jwks-srv/internal/httpserver"
+)
+
+const (
+	unsealUsage = "usage: UNSEAL_TOKEN=<token> jwks-srv unseal [-addr url] [-share] < secret"
+	splitUsage  = "usage: jwks-srv split-secret -shares n -threshold m < secret"
+)
+
+// runUnseal handles `jwks-srv unseal` - submits NOT_MY_KEY, or with -share one
+// base64 Shamir share, read from stdin so it never shows up in the process list
+func runUnseal(args []string, token string, in io.Reader, out io.Writer, client *http.Client) error {
+	flags := flag.NewFlagSet("unseal", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	addr := flags.String("addr", "http://localhost:8080", "base URL of the sealed server")
+	share := flags.Bool("share", false, "stdin holds one Shamir share instead of the whole secret")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errors.New(unsealUsage)
+	}
+	if token == "" {
+		return fmt.Errorf("UNSEAL_TOKEN is required\n%s", unsealUsage)
+	}
+
+	secret, err := readSecret(in)
+	if err != nil {
+		return err
+	}
+
+	req := httpserver.UnsealRequest{Key: secret}
+	if *share {
+		req = httpserver.UnsealRequest{Share: secret}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode unseal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, strings.TrimRight(*addr, "/")+"/admin/unseal", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build unseal request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute} // unseal derives the master key - allow for Argon2id
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unseal rejected (%d): %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var status httpserver.SealStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return fmt.Errorf("failed to decode unseal response: %w", err)
+	}
+
+	if status.Sealed {
+		fmt.Fprintf(out, "sealed: %d of %d shares submitted\n", status.Progress, status.Threshold)
+	} else {
+		fmt.Fprintln(out, "unsealed")
+	}
+	return nil
+}
+
+// runSplitSecret handles `jwks-srv split-secret` - splits NOT_MY_KEY from stdin into
+// base64 Shamir shares, one per line, any threshold of which unseal the server
+func runSplitSecret(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("split-secret", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	n := flags.Int("shares", 0, "number of shares to produce")
+	threshold := flags.Int("threshold", 0, "shares needed to recover the secret")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errors.New(splitUsage)
+	}
+
+	secret, err := readSecret(in)
+	if err != nil {
+		return err
+	}
+
+	shares, err := crypto.SplitSecret([]byte(secret), *n, *threshold)
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, splitUsage)
+	}
+
+	for _, share := range shares {
+		fmt.Fprintln(out, base64.StdEncoding.EncodeToString(share))
+	}
+	return nil
+}
+
+// first line of in, without its line ending
+func readSecret(in io.Reader) (string, error) {
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		return "", errors.New("no secret on stdin")
+	}
+	return secret, nil
+}
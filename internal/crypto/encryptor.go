@@ -1,10 +1,12 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"io"
 )
@@ -15,23 +17,165 @@ var (
 
 	// ErrEmptyPassphrase indicates an empty passphrase was provided for key derivation
 	ErrEmptyPassphrase = fmt.Errorf("passphrase cannot be empty")
+
+	// ErrUnknownMasterKey indicates the ciphertext was sealed under a master key that is not in the keyring
+	ErrUnknownMasterKey = fmt.Errorf("ciphertext sealed under unknown master key")
+
+	// ErrUnboundCiphertext indicates DecryptWithAAD was given data sealed without associated data
+	ErrUnboundCiphertext = fmt.Errorf("ciphertext is not bound to associated data")
+
+	// ErrAssociatedDataRequired indicates Decrypt was given data that needs DecryptWithAAD
+	ErrAssociatedDataRequired = fmt.Errorf("ciphertext is bound to associated data")
+)
+
+// versioned ciphertext layout: magic | format version | master key ID | nonce | sealed data.
+// Envelope ciphertext (version 3) is magic | 3 | flags | KEK ID | wrapped DEK length |
+// wrapped DEK | nonce | data sealed with the DEK.
+// Blobs written before versioning are bare nonce | sealed data and are still accepted.
+var ciphertextMagic = []byte("JWK")
+
+const (
+	ciphertextVersion         = 1 // sealed without associated data
+	ciphertextVersionAAD      = 2 // header and caller's associated data are authenticated
+	ciphertextVersionEnvelope = 3 // per-ciphertext DEK wrapped by a KEKProvider
+	keyIDSize                 = 4
+	headerSize                = 3 + 1 + keyIDSize
+
+	envelopeFlagBound  = 1 << 0                    // caller's associated data is authenticated
+	envelopeHeaderSize = 3 + 1 + 1 + keyIDSize + 2 // up to the wrapped DEK
+	gcmNonceSize       = 12
 )
 
+// masterKey is one AES-GCM key derived from a passphrase
+type masterKey struct {
+	id     uint32
+	aead   cipher.AEAD
+	legacy bool // derived with the unsalted SHA-256 - decrypt only
+}
+
 // Encryptor provides AES-GCM encryption and decryption for RSA private keys.
+// In envelope mode every ciphertext gets a random data key wrapped by the current
+// KEKProvider; otherwise data is sealed directly under the current master key.
+// Previous master keys and KEKs are kept for decryption only so stored data
+// survives a rotation.
 type Encryptor struct {
-	aead cipher.AEAD
+	aead  cipher.AEAD // current master key - nil in envelope mode
+	keyID uint32      // written into every new ciphertext
+	keys  []masterKey // current first, then previous keys in the order given
+
+	kek  KEKProvider   // wraps new data keys - nil in direct mode
+	keks []KEKProvider // current KEK first, then decrypt-only ones
 }
 
-// NewEncryptor creates a new AES-GCM encryptor from a passphrase.
-func NewEncryptor(passphrase string) (*Encryptor, error) {
+// NewEncryptor creates a new AES-GCM encryptor from a passphrase using the legacy
+// unsalted SHA-256 derivation. previous passphrases can still decrypt but are never
+// used to encrypt. Stores use NewEnvelopeEncryptor.
+func NewEncryptor(passphrase string, previous ...string) (*Encryptor, error) {
 	if passphrase == "" {
 		return nil, ErrEmptyPassphrase
 	}
+	for _, old := range previous {
+		if old == "" {
+			return nil, fmt.Errorf("invalid previous master key: %w", ErrEmptyPassphrase)
+		}
+	}
 
-	// derive 32-byte key from passphrase using SHA256
-	keyHash := sha256.Sum256([]byte(passphrase))
+	current, err := newMasterKey(legacyDeriveKey(passphrase), false)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptor := &Encryptor{
+		aead:  current.aead,
+		keyID: current.id,
+		keys:  []masterKey{current},
+	}
+
+	for _, old := range previous {
+		key, err := newMasterKey(legacyDeriveKey(old), false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous master key: %w", err)
+		}
+		encryptor.addKey(key)
+	}
+
+	return encryptor, nil
+}
+
+// NewEncryptorWithKDF creates an envelope encryptor whose KEKs are derived from the
+// passphrases with Argon2id under params. Data sealed directly with the passphrases -
+// including the legacy SHA-256 derivation - still decrypts.
+func NewEncryptorWithKDF(params KDFParams, passphrase string, previous ...string) (*Encryptor, error) {
+	kek, err := NewPassphraseKEK(params, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	previousKEKs := make([]KEKProvider, 0, len(previous))
+	for _, old := range previous {
+		oldKEK, err := NewPassphraseKEK(params, old)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous master key: %w", err)
+		}
+		previousKEKs = append(previousKEKs, oldKEK)
+	}
+
+	return NewEnvelopeEncryptor(kek, previousKEKs...)
+}
+
+// NewEnvelopeEncryptor creates an encryptor that wraps a fresh data key for every
+// ciphertext with kek. previous KEKs only unwrap; passphrase KEKs among them also
+// open data sealed directly with their passphrase before envelope encryption.
+func NewEnvelopeEncryptor(kek KEKProvider, previous ...KEKProvider) (*Encryptor, error) {
+	if kek == nil {
+		return nil, fmt.Errorf("key-encryption key provider is required")
+	}
+
+	encryptor := &Encryptor{
+		keyID: kekID(kek),
+		kek:   kek,
+	}
+
+	var legacy []masterKey
+	for _, provider := range append([]KEKProvider{kek}, previous...) {
+		if provider == nil {
+			return nil, fmt.Errorf("previous key-encryption key provider is nil")
+		}
+		if !encryptor.hasKEK(kekID(provider)) {
+			encryptor.keks = append(encryptor.keks, provider)
+		}
+
+		passphrase, ok := provider.(*passphraseKEK)
+		if !ok {
+			continue
+		}
+		for _, key := range passphrase.direct {
+			if key.legacy {
+				legacy = append(legacy, key)
+			} else {
+				encryptor.addKey(key)
+			}
+		}
+	}
+
+	// legacy keys go last so they never win over a real one
+	for _, key := range legacy {
+		encryptor.addKey(key)
+	}
+
+	return encryptor, nil
+}
 
-	block, err := aes.NewCipher(keyHash[:])
+func newMasterKey(key []byte, legacy bool) (masterKey, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return masterKey{}, err
+	}
+	return masterKey{id: masterKeyID(key), aead: aead, legacy: legacy}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
 	}
@@ -40,14 +184,65 @@ func NewEncryptor(passphrase string) (*Encryptor, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
 	}
+	return aead, nil
+}
+
+// key ID is a fingerprint of the derived key - stable across restarts without
+// any numbering to configure, and reveals nothing usable about the key
+func masterKeyID(key []byte) uint32 {
+	fingerprint := sha256.Sum256(append([]byte("jwks-srv master key id:"), key...))
+	return binary.BigEndian.Uint32(fingerprint[:keyIDSize])
+}
+
+func (e *Encryptor) addKey(key masterKey) {
+	if !e.hasKey(key.id) {
+		e.keys = append(e.keys, key)
+	}
+}
 
-	return &Encryptor{aead: aead}, nil
+func (e *Encryptor) hasKey(id uint32) bool {
+	for _, key := range e.keys {
+		if key.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Encryptor) hasKEK(id uint32) bool {
+	for _, kek := range e.keks {
+		if kekID(kek) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyID identifies the current master key or KEK - written into every new ciphertext
+func (e *Encryptor) KeyID() uint32 {
+	return e.keyID
 }
 
 // Encrypt encrypts plaintext data using AES-GCM with a randomly generated nonce.
-// The nonce is prepended to the ciphertext for retrieval during decryption.
+// The output is the versioned header, then the nonce, then the sealed data.
 // Returns an error if random nonce generation fails.
 func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	if e.kek != nil {
+		return e.sealEnvelope(plaintext, false, nil)
+	}
+	return e.seal(plaintext, ciphertextVersion, nil)
+}
+
+// EncryptWithAAD is Encrypt with associated data - the ciphertext only opens through
+// DecryptWithAAD with the same aad, so it can't be moved to a different context
+func (e *Encryptor) EncryptWithAAD(plaintext, aad []byte) ([]byte, error) {
+	if e.kek != nil {
+		return e.sealEnvelope(plaintext, true, aad)
+	}
+	return e.seal(plaintext, ciphertextVersionAAD, aad)
+}
+
+func (e *Encryptor) seal(plaintext []byte, version byte, aad []byte) ([]byte, error) {
 	if len(plaintext) == 0 {
 		// allow encrypting empty data
 		plaintext = []byte{}
@@ -59,26 +254,312 @@ func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	// encrypt and authenticate data, prepending nonce to ciphertext
-	ciphertext := e.aead.Seal(nonce, nonce, plaintext, nil)
-	return ciphertext, nil
+	out := make([]byte, 0, headerSize+len(nonce)+len(plaintext)+e.aead.Overhead())
+	out = append(out, ciphertextMagic...)
+	out = append(out, version)
+	out = binary.BigEndian.AppendUint32(out, e.keyID)
+
+	// bound data also authenticates the header so it can't be downgraded
+	var additional []byte
+	if version == ciphertextVersionAAD {
+		additional = append(out[:headerSize:headerSize], aad...)
+	}
+	out = append(out, nonce...)
+
+	// encrypt and authenticate data after the header and nonce
+	return e.aead.Seal(out, nonce, plaintext, additional), nil
+}
+
+// fresh DEK per ciphertext, wrapped by the current KEK
+func (e *Encryptor) sealEnvelope(plaintext []byte, bound bool, aad []byte) ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := e.kek.WrapKey(dek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	if len(wrapped) == 0 || len(wrapped) > 0xffff {
+		return nil, fmt.Errorf("failed to wrap data key: wrapped key is %d bytes", len(wrapped))
+	}
+
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	var flags byte
+	if bound {
+		flags |= envelopeFlagBound
+	}
+
+	out := make([]byte, 0, envelopeHeaderSize+len(wrapped)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, ciphertextMagic...)
+	out = append(out, ciphertextVersionEnvelope, flags)
+	out = binary.BigEndian.AppendUint32(out, e.keyID)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+
+	// the whole header, wrapped DEK included, is authenticated
+	additional := append(out[:len(out):len(out)], aad...)
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, plaintext, additional), nil
 }
 
 // Decrypt decrypts ciphertext data using AES-GCM.
-// The nonce is expected to be prepended to the ciphertext.
+// Versioned ciphertext is opened with the master key or KEK named in its header;
+// unversioned (legacy) ciphertext is tried against every key in the keyring.
 // Returns ErrCiphertextTooShort if the ciphertext is too short to contain a valid nonce.
 func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
-	nonceSize := e.aead.NonceSize()
-	if len(ciphertext) < nonceSize {
+	plaintext, _, err := e.decrypt(ciphertext, nil, false)
+	return plaintext, err
+}
+
+// DecryptWithAAD opens data sealed by EncryptWithAAD. It fails unless aad matches
+// what was sealed, and refuses data sealed without associated data (ErrUnboundCiphertext).
+func (e *Encryptor) DecryptWithAAD(ciphertext, aad []byte) ([]byte, error) {
+	plaintext, _, err := e.decrypt(ciphertext, aad, true)
+	return plaintext, err
+}
+
+// IsBound reports whether ciphertext was sealed with associated data
+func (e *Encryptor) IsBound(ciphertext []byte) bool {
+	if envelope, ok := parseEnvelope(ciphertext); ok {
+		return envelope.bound
+	}
+	version, _, _, ok := parseHeader(ciphertext)
+	return ok && version == ciphertextVersionAAD
+}
+
+// IsEnvelope reports whether ciphertext carries its own wrapped data key
+func (e *Encryptor) IsEnvelope(ciphertext []byte) bool {
+	_, ok := parseEnvelope(ciphertext)
+	return ok
+}
+
+// UsesLegacyKDF reports whether ciphertext only opens with a key from the legacy
+// SHA-256 derivation - such data should be re-encrypted under the Argon2id key
+func (e *Encryptor) UsesLegacyKDF(ciphertext []byte) bool {
+	if e.IsEnvelope(ciphertext) {
+		return false
+	}
+
+	// bound data can't be opened here - the header names the key
+	if version, id, _, ok := parseHeader(ciphertext); ok && version == ciphertextVersionAAD {
+		for _, key := range e.keys {
+			if key.id == id {
+				return key.legacy
+			}
+		}
+		return false
+	}
+
+	_, key, err := e.decrypt(ciphertext, nil, false)
+	return err == nil && key.legacy
+}
+
+// decrypt and report which master key opened the data - bound selects DecryptWithAAD rules
+func (e *Encryptor) decrypt(ciphertext, aad []byte, bound bool) ([]byte, masterKey, error) {
+	if envelope, ok := parseEnvelope(ciphertext); ok {
+		plaintext, err := e.openEnvelope(envelope, aad, bound)
+		if err == nil || bound {
+			return plaintext, masterKey{}, err
+		}
+
+		// a legacy blob whose random nonce happens to start with the magic
+		if plaintext, key, legacyErr := e.decryptLegacy(ciphertext); legacyErr == nil {
+			return plaintext, key, nil
+		}
+		return nil, masterKey{}, err
+	}
+
+	version, id, body, ok := parseHeader(ciphertext)
+
+	var lastErr error
+	if ok && (version == ciphertextVersionAAD) == bound {
+		var additional []byte
+		if bound {
+			additional = append(ciphertext[:headerSize:headerSize], aad...)
+		}
+		for _, key := range e.keys {
+			if key.id != id {
+				continue
+			}
+			plaintext, err := open(key.aead, body, additional)
+			if err == nil {
+				return plaintext, key, nil
+			}
+			lastErr = err
+		}
+	}
+
+	if bound {
+		switch {
+		case !ok || version != ciphertextVersionAAD:
+			return nil, masterKey{}, ErrUnboundCiphertext
+		case lastErr == nil:
+			return nil, masterKey{}, fmt.Errorf("%w %08x", ErrUnknownMasterKey, id)
+		default:
+			return nil, masterKey{}, lastErr
+		}
+	}
+
+	// a legacy blob whose random nonce happens to start with the magic - fall through
+	plaintext, key, err := e.decryptLegacy(ciphertext)
+	if err == nil {
+		return plaintext, key, nil
+	}
+	switch {
+	case ok && version == ciphertextVersionAAD:
+		return nil, masterKey{}, ErrAssociatedDataRequired
+	case ok && !e.hasKey(id):
+		return nil, masterKey{}, fmt.Errorf("%w %08x", ErrUnknownMasterKey, id)
+	}
+	return nil, masterKey{}, err
+}
+
+// unwrap the DEK with the KEK named in the header, then open the data
+func (e *Encryptor) openEnvelope(envelope envelope, aad []byte, bound bool) ([]byte, error) {
+	switch {
+	case bound && !envelope.bound:
+		return nil, ErrUnboundCiphertext
+	case !bound && envelope.bound:
+		return nil, ErrAssociatedDataRequired
+	}
+
+	var kek KEKProvider
+	for _, provider := range e.keks {
+		if kekID(provider) == envelope.kekID {
+			kek = provider
+			break
+		}
+	}
+	if kek == nil {
+		return nil, fmt.Errorf("%w %08x", ErrUnknownKEK, envelope.kekID)
+	}
+
+	dek, err := kek.UnwrapKey(envelope.wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	return open(aead, envelope.body, append(envelope.header[:len(envelope.header):len(envelope.header)], aad...))
+}
+
+// NeedsReencrypt reports whether ciphertext was written in an older format or
+// under a previous master key or KEK - anything Encrypt would not produce today
+func (e *Encryptor) NeedsReencrypt(ciphertext []byte) bool {
+	if envelope, ok := parseEnvelope(ciphertext); ok {
+		return e.kek == nil || envelope.kekID != e.keyID
+	}
+	if e.kek != nil {
+		return true
+	}
+
+	version, id, body, ok := parseHeader(ciphertext)
+	if !ok || id != e.keyID {
+		return true
+	}
+	if version == ciphertextVersionAAD {
+		return false
+	}
+	_, err := open(e.aead, body, nil)
+	return err != nil
+}
+
+// pre-versioning blobs carry no key ID - try the current key first
+func (e *Encryptor) decryptLegacy(ciphertext []byte) ([]byte, masterKey, error) {
+	if len(ciphertext) < gcmNonceSize {
+		return nil, masterKey{}, ErrCiphertextTooShort
+	}
+
+	lastErr := fmt.Errorf("%w: no passphrase configured for unversioned data", ErrUnknownMasterKey)
+	for _, key := range e.keys {
+		plaintext, err := open(key.aead, ciphertext, nil)
+		if err == nil {
+			return plaintext, key, nil
+		}
+		lastErr = err
+	}
+	return nil, masterKey{}, lastErr
+}
+
+// split a versioned ciphertext into format version, master key ID and nonce | sealed data
+func parseHeader(ciphertext []byte) (byte, uint32, []byte, bool) {
+	if len(ciphertext) < headerSize || !bytes.HasPrefix(ciphertext, ciphertextMagic) {
+		return 0, 0, nil, false
+	}
+	version := ciphertext[len(ciphertextMagic)]
+	if version != ciphertextVersion && version != ciphertextVersionAAD {
+		return 0, 0, nil, false
+	}
+	id := binary.BigEndian.Uint32(ciphertext[len(ciphertextMagic)+1 : headerSize])
+	return version, id, ciphertext[headerSize:], true
+}
+
+// envelope is a parsed version 3 ciphertext
+type envelope struct {
+	bound   bool
+	kekID   uint32
+	wrapped []byte
+	header  []byte // everything before the nonce - authenticated
+	body    []byte // nonce | sealed data
+}
+
+func parseEnvelope(ciphertext []byte) (envelope, bool) {
+	if len(ciphertext) < envelopeHeaderSize || !bytes.HasPrefix(ciphertext, ciphertextMagic) {
+		return envelope{}, false
+	}
+	offset := len(ciphertextMagic)
+	if ciphertext[offset] != ciphertextVersionEnvelope {
+		return envelope{}, false
+	}
+
+	flags := ciphertext[offset+1]
+	if flags&^envelopeFlagBound != 0 {
+		return envelope{}, false
+	}
+	id := binary.BigEndian.Uint32(ciphertext[offset+2 : offset+2+keyIDSize])
+	wrappedLen := int(binary.BigEndian.Uint16(ciphertext[envelopeHeaderSize-2 : envelopeHeaderSize]))
+	if wrappedLen == 0 || len(ciphertext) < envelopeHeaderSize+wrappedLen {
+		return envelope{}, false
+	}
+
+	headerEnd := envelopeHeaderSize + wrappedLen
+	return envelope{
+		bound:   flags&envelopeFlagBound != 0,
+		kekID:   id,
+		wrapped: ciphertext[envelopeHeaderSize:headerEnd],
+		header:  ciphertext[:headerEnd],
+		body:    ciphertext[headerEnd:],
+	}, true
+}
+
+// open nonce | sealed data
+func open(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
 		return nil, ErrCiphertextTooShort
 	}
 
 	// extract nonce and encrypted data
-	nonce := ciphertext[:nonceSize]
-	encryptedData := ciphertext[nonceSize:]
+	nonce := data[:nonceSize]
+	encryptedData := data[nonceSize:]
 
 	// decrypt and authenticate data
-	plaintext, err := e.aead.Open(nil, nonce, encryptedData, nil)
+	plaintext, err := aead.Open(nil, nonce, encryptedData, additional)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt data: %w", err)
 	}
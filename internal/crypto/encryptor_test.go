@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/rand"
 	"errors"
 	"testing"
 )
@@ -257,3 +258,302 @@ func TestDifferentPassphrases(t *testing.T) {
 		t.Error("Decrypted data should match original")
 	}
 }
+
+// seal the way encryptors did before ciphertexts were versioned
+func legacyEncrypt(t *testing.T, encryptor *Encryptor, plaintext []byte) []byte {
+	t.Helper()
+	nonce := make([]byte, encryptor.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("rand.Read() error = %v", err)
+	}
+	return encryptor.aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+func TestCiphertextCarriesKeyID(t *testing.T) {
+	encryptor, err := NewEncryptor("test-key")
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+
+	ciphertext, err := encryptor.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	_, id, _, ok := parseHeader(ciphertext)
+	if !ok {
+		t.Fatal("Encrypt() output has no versioned header")
+	}
+	if id != encryptor.KeyID() {
+		t.Errorf("header key ID = %08x, want %08x", id, encryptor.KeyID())
+	}
+
+	// same passphrase, same ID - nothing to configure across restarts
+	again, _ := NewEncryptor("test-key")
+	if again.KeyID() != encryptor.KeyID() {
+		t.Error("KeyID() should be stable for a passphrase")
+	}
+	other, _ := NewEncryptor("other-key")
+	if other.KeyID() == encryptor.KeyID() {
+		t.Error("KeyID() should differ between passphrases")
+	}
+}
+
+func TestMasterKeyRotation(t *testing.T) {
+	plaintext := []byte("private key material")
+
+	oldKey, err := NewEncryptor("old-master-key")
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	versioned, err := oldKey.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	legacy := legacyEncrypt(t, oldKey, plaintext)
+
+	rotated, err := NewEncryptor("new-master-key", "old-master-key")
+	if err != nil {
+		t.Fatalf("NewEncryptor() with previous key error = %v", err)
+	}
+
+	// the keyring opens everything written under the old key
+	for name, ciphertext := range map[string][]byte{"versioned": versioned, "legacy": legacy} {
+		decrypted, err := rotated.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt(%s) error = %v", name, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Decrypt(%s) = %q, want %q", name, decrypted, plaintext)
+		}
+		if !rotated.NeedsReencrypt(ciphertext) {
+			t.Errorf("NeedsReencrypt(%s) = false for data under the old key", name)
+		}
+	}
+
+	// new data is sealed under the new key only
+	fresh, err := rotated.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if rotated.NeedsReencrypt(fresh) {
+		t.Error("NeedsReencrypt() = true for data under the current key")
+	}
+	if _, err := oldKey.Decrypt(fresh); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Errorf("Decrypt() with only the old key error = %v, want ErrUnknownMasterKey", err)
+	}
+
+	// once the previous key is dropped old data is unreadable
+	newOnly, _ := NewEncryptor("new-master-key")
+	if _, err := newOnly.Decrypt(versioned); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Errorf("Decrypt() without previous key error = %v, want ErrUnknownMasterKey", err)
+	}
+	if _, err := newOnly.Decrypt(legacy); err == nil {
+		t.Error("Decrypt() of legacy data without previous key should fail")
+	}
+}
+
+func TestNewEncryptorPreviousKeys(t *testing.T) {
+	if _, err := NewEncryptor("current", ""); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("NewEncryptor() with empty previous key error = %v, want ErrEmptyPassphrase", err)
+	}
+
+	// repeating the current key as a previous one is harmless
+	encryptor, err := NewEncryptor("current", "current", "old", "old")
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	if len(encryptor.keys) != 2 {
+		t.Errorf("Expected 2 distinct master keys, got %d", len(encryptor.keys))
+	}
+}
+
+// cheap parameters - derivation cost isn't what these tests are about
+func testKDFParams(t *testing.T) KDFParams {
+	t.Helper()
+	params, err := NewKDFParams()
+	if err != nil {
+		t.Fatalf("NewKDFParams() error = %v", err)
+	}
+	params.Time, params.Memory, params.Threads = 1, 64, 1
+	return params
+}
+
+func TestNewEncryptorWithKDF(t *testing.T) {
+	params := testKDFParams(t)
+	plaintext := []byte("private key material")
+
+	encryptor, err := NewEncryptorWithKDF(params, "master-key")
+	if err != nil {
+		t.Fatalf("NewEncryptorWithKDF() error = %v", err)
+	}
+
+	ciphertext, err := encryptor.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// the derived key depends on the salt, not just the passphrase
+	legacy, _ := NewEncryptor("master-key")
+	if legacy.KeyID() == encryptor.KeyID() {
+		t.Error("Argon2id key should differ from the SHA-256 key")
+	}
+	if _, err := legacy.Decrypt(ciphertext); err == nil {
+		t.Error("Decrypt() with the legacy key should fail")
+	}
+
+	otherSalt := testKDFParams(t)
+	salted, _ := NewEncryptorWithKDF(otherSalt, "master-key")
+	if salted.KeyID() == encryptor.KeyID() {
+		t.Error("Different salts should derive different keys")
+	}
+
+	// same params reopen the data
+	reopened, err := NewEncryptorWithKDF(params, "master-key")
+	if err != nil {
+		t.Fatalf("NewEncryptorWithKDF() error = %v", err)
+	}
+	decrypted, err := reopened.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+	if reopened.UsesLegacyKDF(ciphertext) {
+		t.Error("UsesLegacyKDF() = true for Argon2id data")
+	}
+}
+
+func TestNewEncryptorWithKDFReadsLegacyData(t *testing.T) {
+	params := testKDFParams(t)
+	plaintext := []byte("private key material")
+
+	legacy, _ := NewEncryptor("master-key")
+	versioned, _ := legacy.Encrypt(plaintext)
+	unversioned := legacyEncrypt(t, legacy, plaintext)
+
+	legacyPrevious, _ := NewEncryptor("old-master-key")
+	previous, _ := legacyPrevious.Encrypt(plaintext)
+
+	encryptor, err := NewEncryptorWithKDF(params, "master-key", "old-master-key")
+	if err != nil {
+		t.Fatalf("NewEncryptorWithKDF() error = %v", err)
+	}
+
+	for name, ciphertext := range map[string][]byte{"versioned": versioned, "unversioned": unversioned, "previous": previous} {
+		decrypted, err := encryptor.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt(%s) error = %v", name, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Decrypt(%s) = %q, want %q", name, decrypted, plaintext)
+		}
+		if !encryptor.UsesLegacyKDF(ciphertext) {
+			t.Errorf("UsesLegacyKDF(%s) = false for SHA-256 data", name)
+		}
+		if !encryptor.NeedsReencrypt(ciphertext) {
+			t.Errorf("NeedsReencrypt(%s) = false for SHA-256 data", name)
+		}
+	}
+
+	if encryptor.UsesLegacyKDF([]byte("garbage that decrypts with nothing")) {
+		t.Error("UsesLegacyKDF() = true for undecryptable data")
+	}
+}
+
+func TestKDFParamsValidate(t *testing.T) {
+	valid := testKDFParams(t)
+
+	tests := []struct {
+		name   string
+		modify func(p *KDFParams)
+	}{
+		{"short salt", func(p *KDFParams) { p.Salt = []byte("salt") }},
+		{"zero time", func(p *KDFParams) { p.Time = 0 }},
+		{"zero threads", func(p *KDFParams) { p.Threads = 0 }},
+		{"too little memory", func(p *KDFParams) { p.Memory = 4 }},
+	}
+
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := valid
+			tt.modify(&params)
+			if err := params.Validate(); !errors.Is(err, ErrInvalidKDFParams) {
+				t.Errorf("Validate() error = %v, want ErrInvalidKDFParams", err)
+			}
+			if _, err := NewEncryptorWithKDF(params, "master-key"); !errors.Is(err, ErrInvalidKDFParams) {
+				t.Errorf("NewEncryptorWithKDF() error = %v, want ErrInvalidKDFParams", err)
+			}
+		})
+	}
+}
+
+func TestEncryptWithAAD(t *testing.T) {
+	encryptor, err := NewEncryptor("test-key")
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	plaintext := []byte("private key material")
+	aad := []byte("kid=1")
+
+	ciphertext, err := encryptor.EncryptWithAAD(plaintext, aad)
+	if err != nil {
+		t.Fatalf("EncryptWithAAD() error = %v", err)
+	}
+	if !encryptor.IsBound(ciphertext) {
+		t.Error("IsBound() = false for EncryptWithAAD output")
+	}
+
+	decrypted, err := encryptor.DecryptWithAAD(ciphertext, aad)
+	if err != nil {
+		t.Fatalf("DecryptWithAAD() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptWithAAD() = %q, want %q", decrypted, plaintext)
+	}
+
+	// moved to another context
+	if _, err := encryptor.DecryptWithAAD(ciphertext, []byte("kid=2")); err == nil {
+		t.Error("DecryptWithAAD() should fail with different associated data")
+	}
+	if _, err := encryptor.Decrypt(ciphertext); !errors.Is(err, ErrAssociatedDataRequired) {
+		t.Errorf("Decrypt() error = %v, want ErrAssociatedDataRequired", err)
+	}
+
+	// downgrading the header to the unbound format doesn't strip the binding
+	downgraded := bytes.Clone(ciphertext)
+	downgraded[len(ciphertextMagic)] = ciphertextVersion
+	if _, err := encryptor.Decrypt(downgraded); err == nil {
+		t.Error("Decrypt() should fail for a downgraded header")
+	}
+
+	// unbound data is refused where binding is expected
+	unbound, _ := encryptor.Encrypt(plaintext)
+	if encryptor.IsBound(unbound) {
+		t.Error("IsBound() = true for Encrypt output")
+	}
+	if _, err := encryptor.DecryptWithAAD(unbound, aad); !errors.Is(err, ErrUnboundCiphertext) {
+		t.Errorf("DecryptWithAAD() error = %v, want ErrUnboundCiphertext", err)
+	}
+	if _, err := encryptor.DecryptWithAAD(legacyEncrypt(t, encryptor, plaintext), aad); !errors.Is(err, ErrUnboundCiphertext) {
+		t.Errorf("DecryptWithAAD() of legacy data error = %v, want ErrUnboundCiphertext", err)
+	}
+
+	// other master keys
+	other, _ := NewEncryptor("other-key")
+	if _, err := other.DecryptWithAAD(ciphertext, aad); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Errorf("DecryptWithAAD() with another key error = %v, want ErrUnknownMasterKey", err)
+	}
+	rotated, _ := NewEncryptor("other-key", "test-key")
+	if _, err := rotated.DecryptWithAAD(ciphertext, aad); err != nil {
+		t.Errorf("DecryptWithAAD() with previous key error = %v", err)
+	}
+	if !rotated.NeedsReencrypt(ciphertext) || encryptor.NeedsReencrypt(ciphertext) {
+		t.Error("NeedsReencrypt() should only flag bound data under a previous key")
+	}
+}
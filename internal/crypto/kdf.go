@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KDFArgon2id names the master key derivation recorded alongside KDFParams
+const KDFArgon2id = "argon2id"
+
+const (
+	kdfKeyLength = 32 // AES-256
+	kdfSaltSize  = 16
+)
+
+// ErrInvalidKDFParams indicates stored or supplied KDF parameters can't be used
+var ErrInvalidKDFParams = fmt.Errorf("invalid KDF parameters")
+
+// KDFParams are the Argon2id settings a master key is derived with.
+// Each database keeps its own salt so one passphrase yields a different key per file.
+type KDFParams struct {
+	Salt    []byte
+	Time    uint32 // number of iterations
+	Memory  uint32 // memory usage in KiB
+	Threads uint8  // number of parallel threads
+}
+
+// DefaultKDFParams matches the password hashing cost - the salt is filled in by NewKDFParams
+var DefaultKDFParams = KDFParams{
+	Time:    3,         // 3 iterations
+	Memory:  64 * 1024, // 64 MB
+	Threads: 4,         // 4 threads
+}
+
+// NewKDFParams returns the default cost with a fresh random salt
+func NewKDFParams() (KDFParams, error) {
+	params := DefaultKDFParams
+	params.Salt = make([]byte, kdfSaltSize)
+	if _, err := io.ReadFull(rand.Reader, params.Salt); err != nil {
+		return KDFParams{}, fmt.Errorf("failed to generate KDF salt: %w", err)
+	}
+	return params, nil
+}
+
+// Validate rejects parameters Argon2id can't run with or that are too weak to matter
+func (p KDFParams) Validate() error {
+	switch {
+	case len(p.Salt) < 8:
+		return fmt.Errorf("%w: salt must be at least 8 bytes", ErrInvalidKDFParams)
+	case p.Time == 0:
+		return fmt.Errorf("%w: time must be positive", ErrInvalidKDFParams)
+	case p.Threads == 0:
+		return fmt.Errorf("%w: threads must be positive", ErrInvalidKDFParams)
+	case p.Memory < 8*uint32(p.Threads):
+		return fmt.Errorf("%w: memory must be at least 8 KiB per thread", ErrInvalidKDFParams)
+	}
+	return nil
+}
+
+// derive the AES key for a passphrase
+func (p KDFParams) deriveKey(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, kdfKeyLength)
+}
+
+// pre-KDF derivation - a single unsalted SHA-256, only kept to read old data
+func legacyDeriveKey(passphrase string) []byte {
+	keyHash := sha256.Sum256([]byte(passphrase))
+	return keyHash[:]
+}
@@ -0,0 +1,179 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrInvalidKEKFile indicates a key file that doesn't hold a usable 32-byte key
+	ErrInvalidKEKFile = fmt.Errorf("invalid key-encryption key file")
+
+	// ErrUnknownKEK indicates the data key was wrapped by a KEK that isn't configured
+	ErrUnknownKEK = fmt.Errorf("data key wrapped by unknown key-encryption key")
+)
+
+// associated data for locally wrapped data keys
+var dekWrapAAD = []byte("jwks-srv data key")
+
+const dekSize = 32 // AES-256 data keys
+
+// KEKProvider supplies the key-encryption key (KEK) that wraps each private key's
+// random data-encryption key (DEK). The KEK itself never has to enter the process -
+// a remote provider only ever sees DEKs.
+type KEKProvider interface {
+	// KeyID names the KEK - stored (as a fingerprint) with every wrapped DEK
+	KeyID() string
+
+	// WrapKey encrypts a DEK under the KEK
+	WrapKey(dek []byte) ([]byte, error)
+
+	// UnwrapKey recovers a DEK wrapped by WrapKey
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// localKEK wraps DEKs with an AES-GCM key held in memory
+type localKEK struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newLocalKEK(prefix string, key []byte) (*localKEK, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+
+	return &localKEK{id: fmt.Sprintf("%s:%08x", prefix, masterKeyID(key)), aead: aead}, nil
+}
+
+func (k *localKEK) KeyID() string {
+	return k.id
+}
+
+// nonce | sealed DEK
+func (k *localKEK) WrapKey(dek []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return k.aead.Seal(nonce, nonce, dek, dekWrapAAD), nil
+}
+
+func (k *localKEK) UnwrapKey(wrapped []byte) ([]byte, error) {
+	nonceSize := k.aead.NonceSize()
+	if len(wrapped) < nonceSize {
+		return nil, ErrCiphertextTooShort
+	}
+
+	dek, err := k.aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], dekWrapAAD)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dek, nil
+}
+
+// passphraseKEK is the NOT_MY_KEY provider - an Argon2id key that also opens data
+// sealed directly with the passphrase before envelope encryption
+type passphraseKEK struct {
+	*localKEK
+	direct []masterKey // Argon2id and legacy SHA-256 keys for pre-envelope data
+}
+
+// NewPassphraseKEK derives a KEK from a passphrase with Argon2id under params
+func NewPassphraseKEK(params KDFParams, passphrase string) (KEKProvider, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	key := params.deriveKey(passphrase)
+	local, err := newLocalKEK("passphrase", key)
+	if err != nil {
+		return nil, err
+	}
+
+	derived, err := newMasterKey(key, false)
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := newMasterKey(legacyDeriveKey(passphrase), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passphraseKEK{localKEK: local, direct: []masterKey{derived, legacy}}, nil
+}
+
+// NewKeyFileKEK loads a 32-byte KEK from path - hex or base64 encoded.
+// Like the database file, the key file must not be readable by group or others.
+func NewKeyFileKEK(path string) (KEKProvider, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEKFile, err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("%w: %s has mode %04o, expected no group or other access (chmod 600)", ErrInvalidKEKFile, path, perm)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEKFile, err)
+	}
+
+	key, err := decodeKeyFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKEKFile, path, err)
+	}
+
+	return newLocalKEK("file", key)
+}
+
+// GenerateKeyFile writes a new random hex-encoded KEK to path with mode 0600
+func GenerateKeyFile(path string) error {
+	key := make([]byte, dekSize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return file.Close()
+}
+
+// accept the key as 64 hex characters or base64 - raw bytes would be ambiguous
+func decodeKeyFile(data []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == dekSize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == dekSize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("expected a hex or base64 encoded 32-byte key")
+}
+
+// kekID is the header fingerprint of a provider's key ID
+func kekID(kek KEKProvider) uint32 {
+	return masterKeyID([]byte(kek.KeyID()))
+}
@@ -0,0 +1,296 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// kmsKEK runs the local KMS protocol in-process and returns a provider for keyID
+func kmsKEK(t *testing.T, keyID string) (KEKProvider, *KMSServer) {
+	t.Helper()
+	kms := NewKMSServer("kms-token")
+	if err := kms.AddKey(keyID, nil); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+	server := httptest.NewServer(kms)
+	t.Cleanup(server.Close)
+
+	kek, err := NewHTTPKEK(server.URL, keyID, "kms-token", nil)
+	if err != nil {
+		t.Fatalf("NewHTTPKEK() error = %v", err)
+	}
+	return kek, kms
+}
+
+func keyFileKEK(t *testing.T) KEKProvider {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "kek")
+	if err := GenerateKeyFile(path); err != nil {
+		t.Fatalf("GenerateKeyFile() error = %v", err)
+	}
+	kek, err := NewKeyFileKEK(path)
+	if err != nil {
+		t.Fatalf("NewKeyFileKEK() error = %v", err)
+	}
+	return kek
+}
+
+func TestEnvelopeEncryptor(t *testing.T) {
+	passphrase, err := NewPassphraseKEK(testKDFParams(t), "master-key")
+	if err != nil {
+		t.Fatalf("NewPassphraseKEK() error = %v", err)
+	}
+	kms, _ := kmsKEK(t, "jwks-master")
+
+	providers := map[string]KEKProvider{
+		"passphrase": passphrase,
+		"key file":   keyFileKEK(t),
+		"kms":        kms,
+	}
+
+	plaintext := []byte("private key material")
+	aad := []byte("kid=1")
+
+	for name, kek := range providers {
+		t.Run(name, func(t *testing.T) {
+			encryptor, err := NewEnvelopeEncryptor(kek)
+			if err != nil {
+				t.Fatalf("NewEnvelopeEncryptor() error = %v", err)
+			}
+
+			first, err := encryptor.EncryptWithAAD(plaintext, aad)
+			if err != nil {
+				t.Fatalf("EncryptWithAAD() error = %v", err)
+			}
+			second, _ := encryptor.EncryptWithAAD(plaintext, aad)
+			if !encryptor.IsEnvelope(first) || !encryptor.IsBound(first) {
+				t.Fatal("EncryptWithAAD() should produce a bound envelope")
+			}
+
+			// every ciphertext has its own data key
+			firstEnvelope, _ := parseEnvelope(first)
+			secondEnvelope, _ := parseEnvelope(second)
+			if bytes.Equal(firstEnvelope.wrapped, secondEnvelope.wrapped) {
+				t.Error("Expected a fresh data key per ciphertext")
+			}
+
+			decrypted, err := encryptor.DecryptWithAAD(first, aad)
+			if err != nil {
+				t.Fatalf("DecryptWithAAD() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("DecryptWithAAD() = %q, want %q", decrypted, plaintext)
+			}
+			if _, err := encryptor.DecryptWithAAD(first, []byte("kid=2")); err == nil {
+				t.Error("DecryptWithAAD() should fail with different associated data")
+			}
+			if _, err := encryptor.Decrypt(first); !errors.Is(err, ErrAssociatedDataRequired) {
+				t.Errorf("Decrypt() error = %v, want ErrAssociatedDataRequired", err)
+			}
+
+			// the wrapped data key is authenticated with the data
+			tampered := bytes.Clone(first)
+			tampered[envelopeHeaderSize] ^= 0xff
+			if _, err := encryptor.DecryptWithAAD(tampered, aad); err == nil {
+				t.Error("DecryptWithAAD() should fail for a tampered data key")
+			}
+
+			unbound, err := encryptor.Encrypt(plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			if encryptor.IsBound(unbound) {
+				t.Error("IsBound() = true for Encrypt output")
+			}
+			if decrypted, err := encryptor.Decrypt(unbound); err != nil || !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("Decrypt() = %q, %v", decrypted, err)
+			}
+			if _, err := encryptor.DecryptWithAAD(unbound, aad); !errors.Is(err, ErrUnboundCiphertext) {
+				t.Errorf("DecryptWithAAD() error = %v, want ErrUnboundCiphertext", err)
+			}
+			if encryptor.NeedsReencrypt(first) {
+				t.Error("NeedsReencrypt() = true for data under the current KEK")
+			}
+		})
+	}
+}
+
+func TestEnvelopeKEKRotation(t *testing.T) {
+	oldKEK := keyFileKEK(t)
+	newKEK, _ := kmsKEK(t, "jwks-master")
+	plaintext := []byte("private key material")
+
+	old, _ := NewEnvelopeEncryptor(oldKEK)
+	ciphertext, err := old.EncryptWithAAD(plaintext, nil)
+	if err != nil {
+		t.Fatalf("EncryptWithAAD() error = %v", err)
+	}
+
+	rotated, err := NewEnvelopeEncryptor(newKEK, oldKEK)
+	if err != nil {
+		t.Fatalf("NewEnvelopeEncryptor() error = %v", err)
+	}
+	if decrypted, err := rotated.DecryptWithAAD(ciphertext, nil); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptWithAAD() with previous KEK = %q, %v", decrypted, err)
+	}
+	if !rotated.NeedsReencrypt(ciphertext) {
+		t.Error("NeedsReencrypt() = false for data under the previous KEK")
+	}
+
+	newOnly, _ := NewEnvelopeEncryptor(newKEK)
+	if _, err := newOnly.DecryptWithAAD(ciphertext, nil); !errors.Is(err, ErrUnknownKEK) {
+		t.Errorf("DecryptWithAAD() without previous KEK error = %v, want ErrUnknownKEK", err)
+	}
+}
+
+func TestEnvelopeReadsDirectData(t *testing.T) {
+	params := testKDFParams(t)
+	plaintext := []byte("private key material")
+	aad := []byte("kid=1")
+
+	passphrase, err := NewPassphraseKEK(params, "master-key")
+	if err != nil {
+		t.Fatalf("NewPassphraseKEK() error = %v", err)
+	}
+
+	// sealed straight under the Argon2id key, before envelopes existed
+	argon := passphrase.(*passphraseKEK).direct[0]
+	direct := &Encryptor{aead: argon.aead, keyID: argon.id, keys: []masterKey{argon}}
+	bound, _ := direct.EncryptWithAAD(plaintext, aad)
+	legacy, _ := NewEncryptor("master-key")
+	unversioned := legacyEncrypt(t, legacy, plaintext)
+
+	// moving to a key file keeps the passphrase around for reading
+	encryptor, err := NewEnvelopeEncryptor(keyFileKEK(t), passphrase)
+	if err != nil {
+		t.Fatalf("NewEnvelopeEncryptor() error = %v", err)
+	}
+
+	if decrypted, err := encryptor.DecryptWithAAD(bound, aad); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptWithAAD() of direct data = %q, %v", decrypted, err)
+	}
+	if decrypted, err := encryptor.Decrypt(unversioned); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt() of legacy data = %q, %v", decrypted, err)
+	}
+	for name, ciphertext := range map[string][]byte{"direct": bound, "legacy": unversioned} {
+		if encryptor.IsEnvelope(ciphertext) || !encryptor.NeedsReencrypt(ciphertext) {
+			t.Errorf("%s data should need re-encrypting into an envelope", name)
+		}
+	}
+
+	// without the passphrase nothing pre-envelope opens
+	fileOnly, _ := NewEnvelopeEncryptor(keyFileKEK(t))
+	if _, err := fileOnly.Decrypt(unversioned); err == nil {
+		t.Error("Decrypt() of legacy data without a passphrase should fail")
+	}
+}
+
+func TestNewKeyFileKEK(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	write := func(name string, data []byte, perm os.FileMode) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, perm); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		return path
+	}
+
+	hexed := write("hex", []byte(hex.EncodeToString(key)+"\n"), 0600)
+	b64 := write("base64", []byte(base64.StdEncoding.EncodeToString(key)), 0600)
+
+	var ids []string
+	for _, path := range []string{hexed, b64} {
+		kek, err := NewKeyFileKEK(path)
+		if err != nil {
+			t.Fatalf("NewKeyFileKEK(%s) error = %v", filepath.Base(path), err)
+		}
+		ids = append(ids, kek.KeyID())
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("Encodings of the same key should share a key ID, got %v", ids)
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"missing", filepath.Join(dir, "missing")},
+		{"world readable", write("open", []byte(hex.EncodeToString(key)), 0644)},
+		{"short key", write("short", []byte(hex.EncodeToString(key[:16])), 0600)},
+		{"raw bytes", write("raw", key, 0600)},
+		{"not a key", write("text", []byte("correct horse battery staple"), 0600)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyFileKEK(tt.path); !errors.Is(err, ErrInvalidKEKFile) {
+				t.Errorf("NewKeyFileKEK() error = %v, want ErrInvalidKEKFile", err)
+			}
+		})
+	}
+
+	// never overwrites an existing key
+	if err := GenerateKeyFile(hexed); err == nil {
+		t.Error("GenerateKeyFile() should refuse an existing file")
+	}
+}
+
+func TestHTTPKEK(t *testing.T) {
+	kms := NewKMSServer("kms-token")
+	if err := kms.AddKey("jwks-master", nil); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+	server := httptest.NewServer(kms)
+	defer server.Close()
+
+	kek, err := NewHTTPKEK(server.URL+"/", "jwks-master", "kms-token", nil)
+	if err != nil {
+		t.Fatalf("NewHTTPKEK() error = %v", err)
+	}
+	dek := bytes.Repeat([]byte{7}, 32)
+	wrapped, err := kek.WrapKey(dek)
+	if err != nil {
+		t.Fatalf("WrapKey() error = %v", err)
+	}
+	if bytes.Contains(wrapped, dek) {
+		t.Error("WrapKey() leaked the data key")
+	}
+	if unwrapped, err := kek.UnwrapKey(wrapped); err != nil || !bytes.Equal(unwrapped, dek) {
+		t.Errorf("UnwrapKey() = %x, %v", unwrapped, err)
+	}
+
+	badToken, _ := NewHTTPKEK(server.URL, "jwks-master", "wrong", nil)
+	if _, err := badToken.WrapKey(dek); err == nil {
+		t.Error("WrapKey() with a wrong token should fail")
+	}
+	unknown, _ := NewHTTPKEK(server.URL, "other-key", "kms-token", nil)
+	if _, err := unknown.WrapKey(dek); err == nil {
+		t.Error("WrapKey() with an unknown key should fail")
+	}
+	if _, err := kek.UnwrapKey([]byte("not wrapped by the kms")); err == nil {
+		t.Error("UnwrapKey() of garbage should fail")
+	}
+
+	// a key removed from the KMS can't unwrap anything
+	kms.RemoveKey("jwks-master")
+	if _, err := kek.UnwrapKey(wrapped); err == nil {
+		t.Error("UnwrapKey() after the key was removed should fail")
+	}
+
+	for _, url := range []string{"", "ftp://kms", "http://"} {
+		if _, err := NewHTTPKEK(url, "jwks-master", "", nil); err == nil {
+			t.Errorf("NewHTTPKEK(%q) should fail", url)
+		}
+	}
+	if _, err := NewHTTPKEK(server.URL, "", "", nil); err == nil {
+		t.Error("NewHTTPKEK() without a key ID should fail")
+	}
+}
@@ -0,0 +1,209 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Local KMS protocol - a stand-in for a real KMS, JSON over HTTP:
+//
+//	POST /v1/wrap   {"key_id": "...", "plaintext": "<base64>"}  -> {"ciphertext": "<base64>"}
+//	POST /v1/unwrap {"key_id": "...", "ciphertext": "<base64>"} -> {"plaintext": "<base64>"}
+//
+// Errors are non-200 responses with {"error": "..."}. An optional bearer token
+// authenticates the caller.
+
+const defaultKMSTimeout = 10 * time.Second
+
+type kmsRequest struct {
+	KeyID      string `json:"key_id"`
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+type kmsResponse struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// httpKEK wraps DEKs through a KMS speaking the local KMS protocol
+type httpKEK struct {
+	baseURL string
+	keyID   string
+	token   string
+	client  *http.Client
+}
+
+// NewHTTPKEK returns a provider that asks the KMS at baseURL to wrap and unwrap
+// DEKs with its key keyID. token is sent as a bearer token when set; a nil client
+// uses one with a 10s timeout.
+func NewHTTPKEK(baseURL, keyID, token string, client *http.Client) (KEKProvider, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid KMS URL %q", baseURL)
+	}
+	if keyID == "" {
+		return nil, fmt.Errorf("KMS key ID is required")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: defaultKMSTimeout}
+	}
+
+	return &httpKEK{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		keyID:   keyID,
+		token:   token,
+		client:  client,
+	}, nil
+}
+
+func (k *httpKEK) KeyID() string {
+	return "kms:" + k.keyID
+}
+
+func (k *httpKEK) WrapKey(dek []byte) ([]byte, error) {
+	resp, err := k.call("wrap", kmsRequest{KeyID: k.keyID, Plaintext: dek})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (k *httpKEK) UnwrapKey(wrapped []byte) ([]byte, error) {
+	resp, err := k.call("unwrap", kmsRequest{KeyID: k.keyID, Ciphertext: wrapped})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (k *httpKEK) call(op string, body kmsRequest) (*kmsResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode KMS %s request: %w", op, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, k.baseURL+"/v1/"+op, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build KMS %s request: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	httpResp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("KMS %s failed: %w", op, err)
+	}
+	defer httpResp.Body.Close()
+
+	var resp kmsResponse
+	if err := json.NewDecoder(io.LimitReader(httpResp.Body, 1<<20)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("KMS %s failed: status %d, undecodable response: %w", op, httpResp.StatusCode, err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("KMS %s failed: status %d: %s", op, httpResp.StatusCode, resp.Error)
+	}
+	return &resp, nil
+}
+
+// KMSServer is an in-process implementation of the local KMS protocol - for tests
+// and for trying envelope encryption without a real KMS
+type KMSServer struct {
+	mu    sync.RWMutex
+	keys  map[string]*localKEK
+	token string
+}
+
+// NewKMSServer creates an empty KMS. Requests must carry token as a bearer token when it's set.
+func NewKMSServer(token string) *KMSServer {
+	return &KMSServer{keys: make(map[string]*localKEK), token: token}
+}
+
+// AddKey registers a 32-byte key under keyID, or a random one when key is nil
+func (s *KMSServer) AddKey(keyID string, key []byte) error {
+	if key == nil {
+		key = make([]byte, dekSize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return fmt.Errorf("failed to generate key: %w", err)
+		}
+	}
+
+	kek, err := newLocalKEK("kms", key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyID] = kek
+	return nil
+}
+
+// RemoveKey forgets keyID - data keys it wrapped can no longer be unwrapped
+func (s *KMSServer) RemoveKey(keyID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, keyID)
+}
+
+func (s *KMSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeKMSResponse(w, http.StatusMethodNotAllowed, kmsResponse{Error: "method not allowed"})
+		return
+	}
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+		writeKMSResponse(w, http.StatusUnauthorized, kmsResponse{Error: "unauthorized"})
+		return
+	}
+
+	var req kmsRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeKMSResponse(w, http.StatusBadRequest, kmsResponse{Error: "invalid request body"})
+		return
+	}
+
+	s.mu.RLock()
+	kek, ok := s.keys[req.KeyID]
+	s.mu.RUnlock()
+	if !ok {
+		writeKMSResponse(w, http.StatusNotFound, kmsResponse{Error: fmt.Sprintf("unknown key %q", req.KeyID)})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/wrap":
+		wrapped, err := kek.WrapKey(req.Plaintext)
+		if err != nil {
+			writeKMSResponse(w, http.StatusInternalServerError, kmsResponse{Error: err.Error()})
+			return
+		}
+		writeKMSResponse(w, http.StatusOK, kmsResponse{Ciphertext: wrapped})
+	case "/v1/unwrap":
+		dek, err := kek.UnwrapKey(req.Ciphertext)
+		if err != nil {
+			writeKMSResponse(w, http.StatusBadRequest, kmsResponse{Error: "ciphertext could not be unwrapped"})
+			return
+		}
+		writeKMSResponse(w, http.StatusOK, kmsResponse{Plaintext: dek})
+	default:
+		writeKMSResponse(w, http.StatusNotFound, kmsResponse{Error: "not found"})
+	}
+}
+
+func writeKMSResponse(w http.ResponseWriter, status int, resp kmsResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
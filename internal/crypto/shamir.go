@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidShares indicates shares that can't be combined - too few, mismatched or duplicated
+var ErrInvalidShares = errors.New("invalid secret shares")
+
+// maxShares is the number of distinct non-zero x coordinates in GF(256)
+const maxShares = 255
+
+// GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1 - log/exp tables over generator 3
+var gfExp, gfLog = func() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var log [256]byte
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		exp[i+255] = x
+		log[x] = byte(i)
+		// x *= 3
+		high := x & 0x80
+		x ^= x << 1
+		if high != 0 {
+			x ^= 0x1b
+		}
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitSecret splits secret into n shares, any threshold of which recover it with
+// CombineShares. Each share is the secret's length plus one byte for its x coordinate.
+func SplitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%w: empty secret", ErrInvalidShares)
+	}
+	if threshold < 2 || threshold > n || n > maxShares {
+		return nil, fmt.Errorf("%w: need 2 <= threshold <= shares <= %d, got %d of %d", ErrInvalidShares, maxShares, threshold, n)
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	// one random polynomial per secret byte, constant term the byte itself
+	coefficients := make([]byte, threshold)
+	for pos, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate polynomial: %w", err)
+		}
+
+		for _, share := range shares {
+			x := share[len(secret)]
+			// Horner's rule
+			var y byte
+			for i := threshold - 1; i >= 0; i-- {
+				y = gfMul(y, x) ^ coefficients[i]
+			}
+			share[pos] = y
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineShares recovers the secret from at least threshold shares by Lagrange
+// interpolation at zero. Fewer shares than the threshold yield a wrong secret,
+// not an error - callers verify the result.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("%w: need at least 2 shares", ErrInvalidShares)
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, fmt.Errorf("%w: share too short", ErrInvalidShares)
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, fmt.Errorf("%w: shares differ in length", ErrInvalidShares)
+		}
+		x := share[size-1]
+		if x == 0 || seen[x] {
+			return nil, fmt.Errorf("%w: duplicate or zero share index %d", ErrInvalidShares, x)
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		// Lagrange basis polynomial for share i evaluated at 0
+		basis := byte(1)
+		for j, xj := range xs {
+			if j != i {
+				basis = gfMul(basis, gfDiv(xj, xj^xs[i]))
+			}
+		}
+		for pos := range secret {
+			secret[pos] ^= gfMul(share[pos], basis)
+		}
+	}
+
+	return secret, nil
+}
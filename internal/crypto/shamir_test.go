@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSplitCombineShares(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret() error = %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Expected 5 shares, got %d", len(shares))
+	}
+
+	// any threshold-sized subset recovers the secret
+	subsets := [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}}
+	for _, subset := range subsets {
+		var picked [][]byte
+		for _, i := range subset {
+			picked = append(picked, shares[i])
+		}
+		recovered, err := CombineShares(picked)
+		if err != nil {
+			t.Fatalf("CombineShares(%v) error = %v", subset, err)
+		}
+		if !bytes.Equal(recovered, secret) {
+			t.Errorf("CombineShares(%v) = %q, want %q", subset, recovered, secret)
+		}
+	}
+
+	// below the threshold the result is unrelated to the secret
+	recovered, err := CombineShares(shares[:2])
+	if err != nil {
+		t.Fatalf("CombineShares() error = %v", err)
+	}
+	if bytes.Equal(recovered, secret) {
+		t.Error("Expected 2 of 3 shares not to recover the secret")
+	}
+}
+
+func TestSplitSecretInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    []byte
+		n         int
+		threshold int
+	}{
+		{"empty secret", nil, 3, 2},
+		{"threshold of one", []byte("s"), 3, 1},
+		{"threshold above shares", []byte("s"), 2, 3},
+		{"too many shares", []byte("s"), 256, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SplitSecret(tt.secret, tt.n, tt.threshold); !errors.Is(err, ErrInvalidShares) {
+				t.Errorf("Expected ErrInvalidShares, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCombineSharesInvalid(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatalf("SplitSecret() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		shares [][]byte
+	}{
+		{"single share", shares[:1]},
+		{"duplicate share", [][]byte{shares[0], shares[0]}},
+		{"length mismatch", [][]byte{shares[0], shares[1][1:]}},
+		{"zero index", [][]byte{shares[0], append([]byte("secret"), 0)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CombineShares(tt.shares); !errors.Is(err, ErrInvalidShares) {
+				t.Errorf("Expected ErrInvalidShares, got %v", err)
+			}
+		})
+	}
+}
@@ -163,84 +163,80 @@ func TestLogAuthRequestWithNonexistentUser(t *testing.T) {
 }
 
 func TestGetAuthLogs(t *testing.T) {
-	// Create test database
-	db, _ := testDatabase(t)
-	defer db.Close()
+	forEachStore(t, func(t *testing.T, store Store) {
+		// Create test users
+		_, _ = store.CreateUser("user1", "user1@example.com")
+		_, _ = store.CreateUser("user2", "user2@example.com")
+
+		// Log multiple auth requests
+		testLogs := []struct {
+			ip       string
+			username string
+		}{
+			{"192.168.1.1", "user1"},
+			{"192.168.1.2", "user2"},
+			{"192.168.1.3", ""},
+			{"192.168.1.4", "user1"},
+		}
 
-	// Create test users
-	_, _ = db.CreateUser("user1", "user1@example.com")
-	_, _ = db.CreateUser("user2", "user2@example.com")
-
-	// Log multiple auth requests
-	testLogs := []struct {
-		ip       string
-		username string
-	}{
-		{"192.168.1.1", "user1"},
-		{"192.168.1.2", "user2"},
-		{"192.168.1.3", ""},
-		{"192.168.1.4", "user1"},
-	}
+		for _, log := range testLogs {
+			err := store.LogAuthRequest(log.ip, log.username)
+			if err != nil {
+				t.Fatalf("Failed to log auth request: %v", err)
+			}
+		}
 
-	for _, log := range testLogs {
-		err := db.LogAuthRequest(log.ip, log.username)
+		// Retrieve all logs
+		logs, err := store.GetAuthLogs(0)
 		if err != nil {
-			t.Fatalf("Failed to log auth request: %v", err)
+			t.Fatalf("Failed to get auth logs: %v", err)
 		}
-	}
-
-	// Retrieve all logs
-	logs, err := db.GetAuthLogs(0)
-	if err != nil {
-		t.Fatalf("Failed to get auth logs: %v", err)
-	}
 
-	if len(logs) != len(testLogs) {
-		t.Errorf("Expected %d logs, got %d", len(testLogs), len(logs))
-	}
+		if len(logs) != len(testLogs) {
+			t.Errorf("Expected %d logs, got %d", len(testLogs), len(logs))
+		}
 
-	// Verify logs contain expected data
-	ipFound := make(map[string]bool)
-	for _, log := range logs {
-		ipFound[log.RequestIP] = true
+		// Verify logs contain expected data
+		ipFound := make(map[string]bool)
+		for _, log := range logs {
+			ipFound[log.RequestIP] = true
 
-		// Verify timestamp is recent
-		if time.Since(log.RequestTimestamp) > time.Minute {
-			t.Errorf("Log timestamp is too old: %v", log.RequestTimestamp)
+			// Verify timestamp is recent
+			if time.Since(log.RequestTimestamp) > time.Minute {
+				t.Errorf("Log timestamp is too old: %v", log.RequestTimestamp)
+			}
 		}
-	}
 
-	// Check all IPs were logged
-	for _, testLog := range testLogs {
-		if !ipFound[testLog.ip] {
-			t.Errorf("IP %s not found in logs", testLog.ip)
+		// Check all IPs were logged
+		for _, testLog := range testLogs {
+			if !ipFound[testLog.ip] {
+				t.Errorf("IP %s not found in logs", testLog.ip)
+			}
 		}
-	}
+	})
 }
 
 func TestGetAuthLogsWithLimit(t *testing.T) {
-	// Create test database
-	db, _ := testDatabase(t)
-	defer db.Close()
+	forEachStore(t, func(t *testing.T, store Store) {
+		// Log multiple auth requests
+		for i := 0; i < 10; i++ {
+			err := store.LogAuthRequest("192.168.1.1", "")
+			if err != nil {
+				t.Fatalf("Failed to log auth request: %v", err)
+			}
+		}
 
-	// Log multiple auth requests
-	for i := 0; i < 10; i++ {
-		err := db.LogAuthRequest("192.168.1.1", "")
+		// Retrieve with limit
+		limit := 5
+		logs, err := store.GetAuthLogs(limit)
 		if err != nil {
-			t.Fatalf("Failed to log auth request: %v", err)
+			t.Fatalf("Failed to get auth logs: %v", err)
 		}
-	}
-
-	// Retrieve with limit
-	limit := 5
-	logs, err := db.GetAuthLogs(limit)
-	if err != nil {
-		t.Fatalf("Failed to get auth logs: %v", err)
-	}
 
-	if len(logs) != limit {
-		t.Errorf("Expected %d logs, got %d", limit, len(logs))
-	}
+		if len(logs) != limit {
+			t.Errorf("Expected %d logs, got %d", limit, len(logs))
+		}
+	})
 }
 
 func TestAuthLogsForeignKeyConstraint(t *testing.T) {
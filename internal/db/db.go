@@ -1,6 +1,9 @@
 package db
 
 import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/subtle"
@@ -35,102 +38,200 @@ type KeyRecord struct {
 	Exp int64
 }
 
+var (
+	// ErrUserNotFound indicates no user exists with the requested username
+	ErrUserNotFound = fmt.Errorf("user not found")
+
+	// ErrInvalidCredentials indicates the username or password did not match a stored user
+	ErrInvalidCredentials = fmt.Errorf("invalid username or password")
+
+	// ErrKeyNotFound indicates no stored key matched the lookup
+	ErrKeyNotFound = fmt.Errorf("key not found")
+
+	// ErrWrongMasterKey indicates keys are stored but none decrypt with the configured master key
+	ErrWrongMasterKey = fmt.Errorf("stored keys do not decrypt with the configured master key")
+)
+
 const (
 	dbFileName = "totally_not_my_privateKeys.db"
 	dataDir    = "internal/data"
+
+	// MemoryPath keeps the database in memory - nothing survives a restart
+	MemoryPath = ":memory:"
 )
 
-// NewDatabase creates a new database instance and ensures the database file exists
-func NewDatabase() (*Database, error) {
-	// construct path to database file
-	dbPath := filepath.Join(dataDir, dbFileName)
+// DefaultPath is the database file used when no path or data directory is configured
+func DefaultPath() string {
+	return filepath.Join(dataDir, dbFileName)
+}
 
-	// ensure data directory exists
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
+// ResolvePath picks the database location - an explicit path wins over a data
+// directory, and the default cwd-relative file is used when both are empty
+func ResolvePath(path, dir string) string {
+	switch {
+	case path != "":
+		return path
+	case dir != "":
+		return filepath.Join(dir, dbFileName)
+	default:
+		return DefaultPath()
 	}
+}
 
-	// check if database file exists, create if not
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		// create empty file
-		file, err := os.Create(dbPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create database file: %w", err)
-		}
-		file.Close()
+// ValidatePath checks the database location is usable before opening it: the
+// directory must be writable (SQLite creates journal files next to the database)
+// and an existing file must not be readable by group or others
+func ValidatePath(path string) error {
+	if path == MemoryPath {
+		return nil
+	}
 
-		// set restrictive permissions on database file
-		if err := os.Chmod(dbPath, 0600); err != nil {
-			return nil, fmt.Errorf("failed to set database file permissions: %w", err)
-		}
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("database directory %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("database directory %s is not a directory", dir)
 	}
 
-	// open database connection
-	conn, err := sql.Open("sqlite3", dbPath)
+	probe, err := os.CreateTemp(dir, ".jwks-srv-probe-*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return fmt.Errorf("database directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	info, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("database file %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("database file %s is a directory", path)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("database file %s has mode %04o, expected no group or other access (chmod 600)", path, perm)
 	}
+	return nil
+}
+
+// NewDatabase opens the default database file, creating and migrating it as needed
+func NewDatabase() (*Database, error) {
+	return NewDatabaseAt(DefaultPath())
+}
+
+// NewDatabaseAt opens the database at path, creating and migrating it as needed.
+// MemoryPath gives an ephemeral database.
+func NewDatabaseAt(path string) (*Database, error) {
+	if path != MemoryPath {
+		// ensure data directory exists
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
 
-	db := &Database{
-		conn: conn,
-		path: dbPath,
+		if err := ValidatePath(path); err != nil {
+			return nil, err
+		}
+
+		// check if database file exists, create if not
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create database file: %w", err)
+			}
+			file.Close()
+		}
+	}
+
+	db, err := openConn(path)
+	if err != nil {
+		return nil, err
 	}
 
 	// initialize database schema
 	if err := db.initSchema(); err != nil {
-		conn.Close()
+		db.Close()
 		return nil, fmt.Errorf("failed to initialize database schema: %w", err)
 	}
 
 	return db, nil
 }
 
-// initSchema creates the keys and users tables if they don't exist
-func (db *Database) initSchema() error {
-	// Create keys table
-	keysQuery := `
-	CREATE TABLE IF NOT EXISTS keys(
-		kid INTEGER PRIMARY KEY AUTOINCREMENT,
-		key BLOB NOT NULL,
-		exp INTEGER NOT NULL
-	);`
+// OpenDatabase opens an existing database without migrating it - used by the
+// migrate CLI so status can be read from databases newer than this binary.
+// An empty path opens the default database file.
+func OpenDatabase(path string) (*Database, error) {
+	if path == "" {
+		path = DefaultPath()
+	}
 
-	_, err := db.conn.Exec(keysQuery)
-	if err != nil {
-		return fmt.Errorf("failed to create keys table: %w", err)
+	if path != MemoryPath {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
 	}
 
-	// Create users table for user registration
-	usersQuery := `
-	CREATE TABLE IF NOT EXISTS users(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT NOT NULL UNIQUE,
-		password_hash TEXT NOT NULL,
-		email TEXT UNIQUE,
-		date_registered TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		last_login TIMESTAMP      
-	);`
+	return openConn(path)
+}
 
-	_, err = db.conn.Exec(usersQuery)
+// open the sql handle - an in-memory database lives in a single connection,
+// so the pool is pinned to one that is never recycled
+func openConn(path string) (*Database, error) {
+	conn, err := sql.Open("sqlite3", path)
 	if err != nil {
-		return fmt.Errorf("failed to create users table: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if path == MemoryPath {
+		conn.SetMaxOpenConns(1)
+		conn.SetMaxIdleConns(1)
+		conn.SetConnMaxLifetime(0)
+		conn.SetConnMaxIdleTime(0)
 	}
 
-	// Create auth_logs table for logging authentication requests
-	authLogsQuery := `
-	CREATE TABLE IF NOT EXISTS auth_logs(
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		request_ip TEXT NOT NULL,
-		request_timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);`
+	return &Database{conn: conn, path: path}, nil
+}
 
-	_, err = db.conn.Exec(authLogsQuery)
+// initSchema brings the schema up to date through the embedded migrations
+func (db *Database) initSchema() error {
+	return db.Migrate()
+}
+
+// addColumnIfMissing adds a column to an existing table created by an older schema
+func (db *Database) addColumnIfMissing(table, column, definition string) error {
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		return fmt.Errorf("failed to create auth_logs table: %w", err)
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
 	}
+	defer rows.Close()
 
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return fmt.Errorf("failed to inspect %s table: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect %s table: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s column: %w", table, column, err)
+	}
 	return nil
 }
 
@@ -397,90 +498,176 @@ func deserializePEMKey(pemData []byte) (*rsa.PrivateKey, error) {
 	return privateKey, nil
 }
 
+// Manager is the SQLite Store - private keys are encrypted at rest
 type Manager struct {
 	database  *Database
 	encryptor *crypto.Encryptor
 }
 
-func NewManager(dbPath, encryptionKey string) (*Manager, error) {
-	// Initialize database - use provided path or default
-	var database *Database
-	var err error
+// NewManager opens the database at dbPath with envelope encryption under a KEK
+// derived from encryptionKey. previousKeys are retired master keys still needed
+// to decrypt rows written before the last master key rotation.
+func NewManager(dbPath, encryptionKey string, previousKeys ...string) (*Manager, error) {
+	return openManager(dbPath, func(params crypto.KDFParams) (*crypto.Encryptor, error) {
+		return crypto.NewEncryptorWithKDF(params, encryptionKey, previousKeys...)
+	})
+}
 
-	if dbPath != "" {
-		// Create database with custom path for testing
-		database = &Database{path: dbPath}
-		err = database.initForManager()
-	} else {
-		// Use default database creation
-		database, err = NewDatabase()
+// NewManagerWithKEK opens the database at dbPath with new data keys wrapped by kek.
+// passphrases are NOT_MY_KEY values that only decrypt rows written before the
+// switch to kek; previous KEKs likewise only unwrap.
+func NewManagerWithKEK(dbPath string, kek crypto.KEKProvider, previous []crypto.KEKProvider, passphrases ...string) (*Manager, error) {
+	return openManager(dbPath, func(params crypto.KDFParams) (*crypto.Encryptor, error) {
+		providers := append([]crypto.KEKProvider{}, previous...)
+		for _, passphrase := range passphrases {
+			provider, err := crypto.NewPassphraseKEK(params, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, provider)
+		}
+		return crypto.NewEnvelopeEncryptor(kek, providers...)
+	})
+}
+
+// open the database and build its encryptor - passphrase KEKs need the database's KDF params
+func openManager(dbPath string, newEncryptor func(params crypto.KDFParams) (*crypto.Encryptor, error)) (*Manager, error) {
+	// use provided path or default
+	if dbPath == "" {
+		dbPath = DefaultPath()
 	}
 
+	database, err := NewDatabaseAt(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
+	// master keys are derived with this database's own salt
+	params, err := database.KDFParams()
+	if err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to load KDF parameters: %w", err)
+	}
+
 	// Initialize encryptor
-	encryptor, err := crypto.NewEncryptor(encryptionKey)
+	encryptor, err := newEncryptor(params)
 	if err != nil {
+		database.Close()
 		return nil, fmt.Errorf("failed to create encryptor: %w", err)
 	}
 
-	return &Manager{
+	manager := &Manager{
 		database:  database,
 		encryptor: encryptor,
-	}, nil
-}
+	}
 
-// initForManager initializes database connection for Manager (similar to initForTest but for production use)
-func (db *Database) initForManager() error {
-	// ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(db.path), 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	// rows sealed directly with a passphrase (legacy SHA-256 or Argon2id) or without
+	// associated data are rewritten as envelopes bound to their row
+	if _, err := manager.reencryptKeys(manager.needsUpgrade); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to upgrade legacy encrypted keys: %w", err)
 	}
 
-	// create database file if it doesn't exist
-	if _, err := os.Stat(db.path); os.IsNotExist(err) {
-		file, err := os.Create(db.path)
-		if err != nil {
-			return fmt.Errorf("failed to create database file: %w", err)
-		}
-		file.Close()
+	return manager, nil
+}
 
-		// set restrictive permissions
-		if err := os.Chmod(db.path, 0600); err != nil {
-			return fmt.Errorf("failed to set database permissions: %w", err)
-		}
-	}
+// key lifecycle states - published while pending, active or retired
+const (
+	KeyStatusPending = "pending" // published, not signing until activate_at
+	KeyStatusActive  = "active"  // signs new tokens
+	KeyStatusRetired = "retired" // published for verification only
+	KeyStatusPurged  = "purged"  // no longer published
+)
 
-	// open database connection
-	conn, err := sql.Open("sqlite3", db.path)
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
-	}
-	db.conn = conn
+// where a stored key came from
+const (
+	KeyOriginRotation = "rotation" // generated by scheduled rotation
+	KeyOriginImport   = "import"   // supplied by the caller
+	KeyOriginTest     = "test"     // startup test keys
+)
 
-	// initialize schema
-	return db.initSchema()
+// KeyMetadata is everything stored about a key besides the key material.
+// Zero times mean unknown - rows written by older versions lack them.
+type KeyMetadata struct {
+	Algorithm  string // JWS alg the key signs with
+	KeySize    int    // bits - derived from the key when zero
+	Usage      string // JWK "use", "sig" when empty
+	Origin     string // rotation, import or test
+	Status     string // pending, active, retired or purged
+	CreatedAt  time.Time
+	ActivateAt time.Time // not-before: when the key may start signing
+	RetiredAt  time.Time // zero unless retired or purged
+	ExpiresAt  time.Time
 }
-func (m *Manager) StoreKey(privateKey *rsa.PrivateKey, expiry time.Time) (int, error) {
-	// Serialize to PKCS1 PEM format
-	pkcs1Bytes := x509.MarshalPKCS1PrivateKey(privateKey)
-	pemBlock := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: pkcs1Bytes,
+
+// StoredKey is a decrypted row from the keys table
+type StoredKey struct {
+	Kid int
+	KeyMetadata
+	PrivateKey stdcrypto.Signer
+}
+
+// columns read back into a StoredKey by getKeys
+const storedKeyColumns = "kid, key, exp, alg, status, activate_at, retired_at, created_at, key_size, usage, origin"
+
+// StoreKey encrypts and saves an imported private key that signs immediately
+func (m *Manager) StoreKey(privateKey stdcrypto.Signer, alg string, expiry time.Time) (int, error) {
+	now := time.Now()
+	return m.StoreKeyWithMetadata(privateKey, KeyMetadata{
+		Algorithm:  alg,
+		Origin:     KeyOriginImport,
+		Status:     KeyStatusActive,
+		CreatedAt:  now,
+		ActivateAt: now,
+		ExpiresAt:  expiry,
+	})
+}
+
+// StorePendingKey saves a rotated key that is published now but only signs from activateAt -
+// AdvanceKeyStates promotes it
+func (m *Manager) StorePendingKey(privateKey stdcrypto.Signer, alg string, activateAt, expiry time.Time) (int, error) {
+	return m.StoreKeyWithMetadata(privateKey, KeyMetadata{
+		Algorithm:  alg,
+		Origin:     KeyOriginRotation,
+		Status:     KeyStatusPending,
+		CreatedAt:  time.Now(),
+		ActivateAt: activateAt,
+		ExpiresAt:  expiry,
+	})
+}
+
+// StoreKeyWithMetadata encrypts and saves a private key with its metadata
+func (m *Manager) StoreKeyWithMetadata(privateKey stdcrypto.Signer, meta KeyMetadata) (int, error) {
+	if meta.Status == "" {
+		meta.Status = KeyStatusActive
+	}
+	if meta.Usage == "" {
+		meta.Usage = "sig"
+	}
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now()
+	}
+	if meta.KeySize == 0 {
+		meta.KeySize = keySize(privateKey)
 	}
-	pemData := pem.EncodeToMemory(pemBlock)
 
-	// Encrypt the PEM data
-	encryptedData, err := m.encryptor.Encrypt(pemData)
+	// Serialize to PKCS8 PEM
+	pemData, err := marshalPrivateKeyPEM(privateKey)
 	if err != nil {
-		return 0, fmt.Errorf("failed to encrypt private key: %w", err)
+		return 0, err
+	}
+
+	tx, err := m.database.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin store transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	// Store encrypted data in database
-	query := "INSERT INTO keys (key, exp) VALUES (?, ?)"
-	result, err := m.database.conn.Exec(query, encryptedData, expiry.Unix())
+	// the kid is part of the associated data, so insert first and seal once it's known
+	query := `INSERT INTO keys (key, exp, alg, status, activate_at, created_at, key_size, usage, origin)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, []byte{}, meta.ExpiresAt.Unix(), meta.Algorithm, meta.Status,
+		unixOrZero(meta.ActivateAt), meta.CreatedAt.Unix(), meta.KeySize, meta.Usage, meta.Origin)
 	if err != nil {
 		return 0, fmt.Errorf("failed to store encrypted key: %w", err)
 	}
@@ -490,56 +677,399 @@ func (m *Manager) StoreKey(privateKey *rsa.PrivateKey, expiry time.Time) (int, e
 		return 0, fmt.Errorf("failed to get key ID: %w", err)
 	}
 
+	// Encrypt the PEM data, bound to this row
+	encryptedData, err := m.encryptor.EncryptWithAAD(pemData, keyAAD(int(id), meta.Algorithm, meta.ExpiresAt.Unix()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to encrypt private key: %w", err)
+	}
+
+	if _, err := tx.Exec("UPDATE keys SET key = ? WHERE kid = ?", encryptedData, id); err != nil {
+		return 0, fmt.Errorf("failed to store encrypted key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit key: %w", err)
+	}
+
 	return int(id), nil
 }
 
-func (m *Manager) GetValidKeys() (map[int]*rsa.PrivateKey, error) {
-	return m.getKeys("SELECT kid, key FROM keys WHERE exp > ?", time.Now().Unix())
+// keyAAD is the associated data an encrypted key is sealed with - moving a blob
+// to another kid or editing its alg or exp makes it fail to decrypt
+func keyAAD(kid int, alg string, exp int64) []byte {
+	return []byte(fmt.Sprintf("jwks-srv key|kid=%d|exp=%d|alg=%s", kid, exp, alg))
+}
+
+// GetValidKeys returns the published keys - unexpired and not purged
+func (m *Manager) GetValidKeys() (map[int]*StoredKey, error) {
+	return m.getKeys("SELECT "+storedKeyColumns+" FROM keys WHERE exp > ? AND status != ?", time.Now().Unix(), KeyStatusPurged)
+}
+
+func (m *Manager) GetExpiredKeys() (map[int]*StoredKey, error) {
+	return m.getKeys("SELECT "+storedKeyColumns+" FROM keys WHERE exp <= ?", time.Now().Unix())
+}
+
+// GetActiveKey returns the newest unexpired active key - the one that signs
+func (m *Manager) GetActiveKey() (*StoredKey, error) {
+	return m.getKey("SELECT "+storedKeyColumns+" FROM keys WHERE exp > ? AND status = ? ORDER BY kid DESC LIMIT 1",
+		time.Now().Unix(), KeyStatusActive)
+}
+
+// GetLatestExpiredKey returns the most recently expired key
+func (m *Manager) GetLatestExpiredKey() (*StoredKey, error) {
+	return m.getKey("SELECT "+storedKeyColumns+" FROM keys WHERE exp <= ? ORDER BY exp DESC, kid DESC LIMIT 1", time.Now().Unix())
+}
+
+// AdvanceKeyStates applies due transitions as of now:
+// the newest due pending key becomes active and every other active key retires,
+// and keys retired for at least retireFor are purged.
+func (m *Manager) AdvanceKeyStates(now time.Time, retireFor time.Duration) error {
+	tx, err := m.database.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin key state transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var kid int
+	var activateAt int64
+	err = tx.QueryRow("SELECT kid, activate_at FROM keys WHERE status = ? AND activate_at <= ? ORDER BY activate_at DESC, kid DESC LIMIT 1",
+		KeyStatusPending, now.Unix()).Scan(&kid, &activateAt)
+
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return fmt.Errorf("failed to find pending key: %w", err)
+	default:
+		// predecessors retire the moment their successor starts signing
+		if _, err := tx.Exec("UPDATE keys SET status = ?, retired_at = ? WHERE kid != ? AND status IN (?, ?) AND activate_at <= ?",
+			KeyStatusRetired, activateAt, kid, KeyStatusActive, KeyStatusPending, activateAt); err != nil {
+			return fmt.Errorf("failed to retire keys: %w", err)
+		}
+		if _, err := tx.Exec("UPDATE keys SET status = ? WHERE kid = ?", KeyStatusActive, kid); err != nil {
+			return fmt.Errorf("failed to activate key %d: %w", kid, err)
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE keys SET status = ? WHERE status = ? AND retired_at <= ?",
+		KeyStatusPurged, KeyStatusRetired, now.Add(-retireFor).Unix()); err != nil {
+		return fmt.Errorf("failed to purge retired keys: %w", err)
+	}
+
+	return tx.Commit()
 }
 
-func (m *Manager) GetExpiredKeys() (map[int]*rsa.PrivateKey, error) {
-	return m.getKeys("SELECT kid, key FROM keys WHERE exp <= ?", time.Now().Unix())
+// PurgedKey describes a key removed (or, in dry-run, selected) by PurgeExpiredKeys
+type PurgedKey struct {
+	Kid       int
+	Algorithm string
+	ExpiresAt time.Time
+}
+
+// PurgeExpiredKeys deletes keys that expired at or before cutoff and records each
+// deletion in key_purges. dryRun reports the keys without touching the database.
+func (m *Manager) PurgeExpiredKeys(cutoff time.Time, dryRun bool) ([]PurgedKey, error) {
+	tx, err := m.database.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin purge transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// only metadata is read - purging never needs to decrypt
+	rows, err := tx.Query("SELECT kid, alg, exp FROM keys WHERE exp <= ? ORDER BY kid", cutoff.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired keys: %w", err)
+	}
+
+	var purged []PurgedKey
+	for rows.Next() {
+		var key PurgedKey
+		var exp int64
+		if err := rows.Scan(&key.Kid, &key.Algorithm, &exp); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan expired key: %w", err)
+		}
+		key.ExpiresAt = time.Unix(exp, 0)
+		purged = append(purged, key)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("failed to query expired keys: %w", err)
+	}
+
+	if dryRun || len(purged) == 0 {
+		return purged, nil
+	}
+
+	purgedAt := time.Now().Unix()
+	for _, key := range purged {
+		if _, err := tx.Exec("DELETE FROM keys WHERE kid = ?", key.Kid); err != nil {
+			return nil, fmt.Errorf("failed to delete key %d: %w", key.Kid, err)
+		}
+		if _, err := tx.Exec("INSERT INTO key_purges (kid, alg, exp, purged_at) VALUES (?, ?, ?, ?)",
+			key.Kid, key.Algorithm, key.ExpiresAt.Unix(), purgedAt); err != nil {
+			return nil, fmt.Errorf("failed to record purge of key %d: %w", key.Kid, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return purged, nil
+}
+
+// ReencryptKeys seals every key still under a previous master key or KEK (or in an
+// older format) with the current one. All rows are rewritten in one transaction -
+// if any key fails to decrypt nothing changes. Returns the number of keys
+// rewritten; rows already under the current key are left alone.
+func (m *Manager) ReencryptKeys() (int, error) {
+	return m.reencryptKeys(func(encryptedData, _ []byte) bool {
+		return m.encryptor.NeedsReencrypt(encryptedData) || !m.encryptor.IsBound(encryptedData)
+	})
+}
+
+// rows rewritten on open - anything but a bound envelope, provided this keyring
+// opens it. Rows it can't open are left for GetValidKeys to report.
+func (m *Manager) needsUpgrade(encryptedData, aad []byte) bool {
+	if m.encryptor.IsEnvelope(encryptedData) && m.encryptor.IsBound(encryptedData) {
+		return false
+	}
+	_, err := m.decryptKey(encryptedData, aad)
+	return err == nil
 }
 
-func (m *Manager) getKeys(query string, args ...interface{}) (map[int]*rsa.PrivateKey, error) {
+// open a row in either the bound or the pre-binding format
+func (m *Manager) decryptKey(encryptedData, aad []byte) ([]byte, error) {
+	if m.encryptor.IsBound(encryptedData) {
+		return m.encryptor.DecryptWithAAD(encryptedData, aad)
+	}
+	return m.encryptor.Decrypt(encryptedData)
+}
+
+// rewrite the rows selected by stale under the current master key, bound to
+// their kid, alg and exp, in one transaction
+func (m *Manager) reencryptKeys(stale func(encryptedData, aad []byte) bool) (int, error) {
+	tx, err := m.database.conn.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin re-encrypt transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT kid, key, alg, exp FROM keys ORDER BY kid")
+	if err != nil {
+		return 0, fmt.Errorf("failed to query keys: %w", err)
+	}
+
+	type staleKey struct {
+		kid           int
+		encryptedData []byte
+		aad           []byte
+	}
+	var selected []staleKey
+	for rows.Next() {
+		var key staleKey
+		var alg string
+		var exp int64
+		if err := rows.Scan(&key.kid, &key.encryptedData, &alg, &exp); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan key row: %w", err)
+		}
+		key.aad = keyAAD(key.kid, alg, exp)
+		if stale(key.encryptedData, key.aad) {
+			selected = append(selected, key)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, fmt.Errorf("failed to query keys: %w", err)
+	}
+
+	for _, key := range selected {
+		pemData, err := m.decryptKey(key.encryptedData, key.aad)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt key %d: %w", key.kid, err)
+		}
+
+		encryptedData, err := m.encryptor.EncryptWithAAD(pemData, key.aad)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt key %d: %w", key.kid, err)
+		}
+
+		if _, err := tx.Exec("UPDATE keys SET key = ? WHERE kid = ?", encryptedData, key.kid); err != nil {
+			return 0, fmt.Errorf("failed to update key %d: %w", key.kid, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit re-encrypt: %w", err)
+	}
+
+	return len(selected), nil
+}
+
+// MasterKeyID identifies the master key new rows are encrypted under
+func (m *Manager) MasterKeyID() uint32 {
+	return m.encryptor.KeyID()
+}
+
+// VerifyMasterKey checks the configured master key against the stored keys -
+// ErrWrongMasterKey when there are keys and none of them decrypt. An empty
+// database accepts any key.
+func (m *Manager) VerifyMasterKey() error {
+	rows, err := m.database.conn.Query("SELECT kid, key, alg, exp FROM keys ORDER BY kid DESC")
+	if err != nil {
+		return fmt.Errorf("failed to query keys: %w", err)
+	}
+	defer rows.Close()
+
+	stored := false
+	for rows.Next() {
+		var kid int
+		var encryptedData []byte
+		var alg string
+		var exp int64
+		if err := rows.Scan(&kid, &encryptedData, &alg, &exp); err != nil {
+			return fmt.Errorf("failed to scan key row: %w", err)
+		}
+		stored = true
+		if _, err := m.decryptKey(encryptedData, keyAAD(kid, alg, exp)); err == nil {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query keys: %w", err)
+	}
+
+	if stored {
+		return ErrWrongMasterKey
+	}
+	return nil
+}
+
+// helper - single key query, ErrKeyNotFound when nothing matches
+func (m *Manager) getKey(query string, args ...interface{}) (*StoredKey, error) {
+	keys, err := m.getKeys(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stored := range keys {
+		return stored, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (m *Manager) getKeys(query string, args ...interface{}) (map[int]*StoredKey, error) {
 	rows, err := m.database.conn.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query keys: %w", err)
 	}
 	defer rows.Close()
 
-	keys := make(map[int]*rsa.PrivateKey)
+	keys := make(map[int]*StoredKey)
 	for rows.Next() {
 		var kid int
 		var encryptedData []byte
+		var exp, activateAt, retiredAt, createdAt int64
+		var stored StoredKey
 
-		if err := rows.Scan(&kid, &encryptedData); err != nil {
+		if err := rows.Scan(&kid, &encryptedData, &exp, &stored.Algorithm, &stored.Status, &activateAt, &retiredAt,
+			&createdAt, &stored.KeySize, &stored.Usage, &stored.Origin); err != nil {
 			return nil, fmt.Errorf("failed to scan key row: %w", err)
 		}
 
-		// Decrypt the PEM data
-		pemData, err := m.encryptor.Decrypt(encryptedData)
+		// Decrypt the PEM data - fails if the blob was moved from another row
+		pemData, err := m.encryptor.DecryptWithAAD(encryptedData, keyAAD(kid, stored.Algorithm, exp))
 		if err != nil {
 			return nil, fmt.Errorf("failed to decrypt key %d: %w", kid, err)
 		}
 
-		// Parse PEM data back to RSA private key
-		block, _ := pem.Decode(pemData)
-		if block == nil || block.Type != "RSA PRIVATE KEY" {
-			return nil, fmt.Errorf("invalid PEM block for key %d", kid)
-		}
-
-		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		// Parse PEM data back to a private key
+		privateKey, err := parsePrivateKeyPEM(pemData)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse private key %d: %w", kid, err)
 		}
 
-		keys[kid] = privateKey
+		stored.Kid = kid
+		stored.PrivateKey = privateKey
+		stored.ExpiresAt = time.Unix(exp, 0)
+		stored.ActivateAt = timeOrZero(activateAt)
+		stored.RetiredAt = timeOrZero(retiredAt)
+		stored.CreatedAt = timeOrZero(createdAt)
+		keys[kid] = &stored
 	}
 
 	return keys, nil
 }
 
+// helper - unix seconds, 0 for the zero time
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// helper - 0 unix seconds read back as the zero time
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
+
+// keySize reports the key strength in bits
+func keySize(privateKey stdcrypto.Signer) int {
+	switch pub := privateKey.Public().(type) {
+	case *rsa.PublicKey:
+		return pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	default:
+		return 0
+	}
+}
+
+// marshalPrivateKeyPEM encodes any supported key (RSA, EC, Ed25519) as PKCS8
+func marshalPrivateKeyPEM(privateKey stdcrypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	}), nil
+}
+
+// parsePrivateKeyPEM decodes a PKCS8 key - PKCS1 and SEC1 blocks from older rows still load
+func parsePrivateKeyPEM(pemData []byte) (stdcrypto.Signer, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM block")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(stdcrypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
 // User represents a user record in the database
 type User struct {
 	ID             int64      `json:"id"`
@@ -571,25 +1101,14 @@ func (db *Database) CreateUser(username, email string) (string, error) {
 	// generate secure password using UUIDv4
 	password := uuid.New().String()
 
-	// generate random salt
-	salt := make([]byte, 16)
-	if _, err := rand.Read(salt); err != nil {
-		return "", fmt.Errorf("failed to generate salt: %w", err)
+	passwordHash, err := newPasswordHash(password)
+	if err != nil {
+		return "", err
 	}
 
-	// hash password with Argon2
-	hash := argon2.IDKey([]byte(password), salt, DefaultArgon2Config.Time,
-		DefaultArgon2Config.Memory, DefaultArgon2Config.Threads, DefaultArgon2Config.KeyLength)
-
-	// encode salt and hash for storage (salt:hash format in base64)
-	saltB64 := base64.StdEncoding.EncodeToString(salt)
-	hashB64 := base64.StdEncoding.EncodeToString(hash)
-	passwordHash := fmt.Sprintf("%s:%s", saltB64, hashB64)
-
 	// insert user into database
 	query := `INSERT INTO users (username, password_hash, email) VALUES (?, ?, ?)`
-	_, err := db.conn.Exec(query, username, passwordHash, email)
-	if err != nil {
+	if _, err := db.conn.Exec(query, username, passwordHash, email); err != nil {
 		return "", fmt.Errorf("failed to create user: %w", err)
 	}
 
@@ -610,7 +1129,7 @@ func (db *Database) GetUserByUsername(username string) (*User, error) {
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -629,8 +1148,67 @@ func (db *Database) VerifyPassword(username, password string) (bool, error) {
 		return false, err
 	}
 
+	return verifyPasswordHash(user.PasswordHash, password)
+}
+
+// AuthenticateUser checks the credentials and records the login time on success.
+// Unknown users and wrong passwords both return ErrInvalidCredentials.
+func (db *Database) AuthenticateUser(username, password string) (*User, error) {
+	return authenticateUser(db, username, password)
+}
+
+// shared by every UserStore so timing and error behaviour can't drift apart
+func authenticateUser(users UserStore, username, password string) (*User, error) {
+	user, err := users.GetUserByUsername(username)
+	if err != nil {
+		if err == ErrUserNotFound {
+			// burn the same argon2 work so unknown usernames aren't distinguishable by timing
+			hashPassword(password, make([]byte, 16))
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	valid, err := verifyPasswordHash(user.PasswordHash, password)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidCredentials
+	}
+
+	now := time.Now().UTC()
+	if err := users.UpdateLastLogin(user.ID, now); err != nil {
+		return nil, err
+	}
+	user.LastLogin = &now
+
+	return user, nil
+}
+
+// UpdateLastLogin sets the last_login column for a user
+func (db *Database) UpdateLastLogin(userID int64, loginTime time.Time) error {
+	query := `UPDATE users SET last_login = ? WHERE id = ?`
+	result, err := db.conn.Exec(query, loginTime, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update last login: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check updated rows: %w", err)
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// verifyPasswordHash compares a password against a stored salt:hash string
+func verifyPasswordHash(passwordHash, password string) (bool, error) {
 	// split stored hash into salt and hash components
-	parts := strings.Split(user.PasswordHash, ":")
+	parts := strings.Split(passwordHash, ":")
 	if len(parts) != 2 {
 		return false, fmt.Errorf("invalid password hash format")
 	}
@@ -647,18 +1225,60 @@ func (db *Database) VerifyPassword(username, password string) (bool, error) {
 	}
 
 	// hash the provided password with the same salt
-	computedHash := argon2.IDKey([]byte(password), salt, DefaultArgon2Config.Time,
-		DefaultArgon2Config.Memory, DefaultArgon2Config.Threads, DefaultArgon2Config.KeyLength)
+	computedHash := hashPassword(password, salt)
 
 	// constant time comparison
 	return subtle.ConstantTimeCompare(storedHash, computedHash) == 1, nil
 }
 
+// newPasswordHash salts and hashes a password into the stored salt:hash format
+func newPasswordHash(password string) (string, error) {
+	// generate random salt
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	// hash password with Argon2
+	hash := hashPassword(password, salt)
+
+	// encode salt and hash for storage (salt:hash format in base64)
+	saltB64 := base64.StdEncoding.EncodeToString(salt)
+	hashB64 := base64.StdEncoding.EncodeToString(hash)
+	return fmt.Sprintf("%s:%s", saltB64, hashB64), nil
+}
+
+// hashPassword derives the Argon2id hash of a password with the default parameters
+func hashPassword(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, DefaultArgon2Config.Time,
+		DefaultArgon2Config.Memory, DefaultArgon2Config.Threads, DefaultArgon2Config.KeyLength)
+}
+
 // CreateUser creates a new user via the manager
 func (m *Manager) CreateUser(username, email string) (string, error) {
 	return m.database.CreateUser(username, email)
 }
 
+// GetUserByUsername retrieves a user via the manager
+func (m *Manager) GetUserByUsername(username string) (*User, error) {
+	return m.database.GetUserByUsername(username)
+}
+
+// VerifyPassword checks a password via the manager
+func (m *Manager) VerifyPassword(username, password string) (bool, error) {
+	return m.database.VerifyPassword(username, password)
+}
+
+// AuthenticateUser verifies user credentials via the manager
+func (m *Manager) AuthenticateUser(username, password string) (*User, error) {
+	return m.database.AuthenticateUser(username, password)
+}
+
+// UpdateLastLogin records a login via the manager
+func (m *Manager) UpdateLastLogin(userID int64, loginTime time.Time) error {
+	return m.database.UpdateLastLogin(userID, loginTime)
+}
+
 // AuthLog represents an authentication log entry
 type AuthLog struct {
 	ID               int64     `json:"id"`
@@ -733,3 +1353,13 @@ func (db *Database) GetAuthLogs(limit int) ([]*AuthLog, error) {
 func (m *Manager) LogAuthRequest(requestIP string, username string) error {
 	return m.database.LogAuthRequest(requestIP, username)
 }
+
+// GetAuthLogs retrieves authentication logs via the manager
+func (m *Manager) GetAuthLogs(limit int) ([]*AuthLog, error) {
+	return m.database.GetAuthLogs(limit)
+}
+
+// Close closes the underlying database
+func (m *Manager) Close() error {
+	return m.database.Close()
+}
@@ -367,3 +367,118 @@ func TestKeyNotFound(t *testing.T) {
 		t.Error("GetKeyByKid() should return error for non-existent key")
 	}
 }
+
+func TestResolvePath(t *testing.T) {
+	tests := []struct {
+		path, dir, expected string
+	}{
+		{"", "", DefaultPath()},
+		{"", "/var/lib/jwks", filepath.Join("/var/lib/jwks", dbFileName)},
+		{"/tmp/keys.db", "/var/lib/jwks", "/tmp/keys.db"},
+		{MemoryPath, "", MemoryPath},
+	}
+
+	for _, tt := range tests {
+		if got := ResolvePath(tt.path, tt.dir); got != tt.expected {
+			t.Errorf("ResolvePath(%q, %q) = %q, want %q", tt.path, tt.dir, got, tt.expected)
+		}
+	}
+}
+
+func TestNewDatabaseAtCustomPath(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "nested", "keys.db")
+
+	db, err := NewDatabaseAt(dbPath)
+	if err != nil {
+		t.Fatalf("NewDatabaseAt() error = %v", err)
+	}
+	defer db.Close()
+
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		t.Fatalf("Expected database file at %s: %v", dbPath, err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected database file mode 0600, got %04o", perm)
+	}
+}
+
+func TestValidatePathRejectsOpenPermissions(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "keys.db")
+	if err := os.WriteFile(dbPath, nil, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Chmod(dbPath, 0644); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+
+	if err := ValidatePath(dbPath); err == nil {
+		t.Error("Expected group/other readable database file to be rejected")
+	}
+	if _, err := NewDatabaseAt(dbPath); err == nil {
+		t.Error("Expected NewDatabaseAt() to refuse a group/other readable file")
+	}
+
+	if err := os.Chmod(dbPath, 0600); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	if err := ValidatePath(dbPath); err != nil {
+		t.Errorf("ValidatePath() error = %v after chmod 600", err)
+	}
+}
+
+func TestValidatePathReadOnlyDirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root ignores directory permissions")
+	}
+
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0500); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	defer os.Chmod(dir, 0700)
+
+	if err := ValidatePath(filepath.Join(dir, "keys.db")); err == nil {
+		t.Error("Expected read-only directory to be rejected")
+	}
+}
+
+func TestNewDatabaseAtMemory(t *testing.T) {
+	first, err := NewDatabaseAt(MemoryPath)
+	if err != nil {
+		t.Fatalf("NewDatabaseAt(:memory:) error = %v", err)
+	}
+	defer first.Close()
+
+	second, err := NewDatabaseAt(MemoryPath)
+	if err != nil {
+		t.Fatalf("NewDatabaseAt(:memory:) error = %v", err)
+	}
+	defer second.Close()
+
+	key, err := generateRSAKey(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	if _, err := first.SaveKey(key, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SaveKey() error = %v", err)
+	}
+
+	// the schema and data persist across pooled queries
+	records, err := first.GetValidKeys()
+	if err != nil {
+		t.Fatalf("GetValidKeys() error = %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("Expected 1 key in memory database, got %d", len(records))
+	}
+
+	// separate in-memory databases don't share data
+	records, err = second.GetValidKeys()
+	if err != nil {
+		t.Fatalf("GetValidKeys() error = %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("Expected empty second memory database, got %d keys", len(records))
+	}
+}
@@ -1,10 +1,23 @@
 package db
 
 import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"csce-3550_jwks-srv/internal/crypto"
 )
 
 func TestNewManager(t *testing.T) {
@@ -32,100 +45,84 @@ func TestNewManager(t *testing.T) {
 }
 
 func TestManagerStoreAndRetrieveKey(t *testing.T) {
-	tempDir := t.TempDir()
-	dbPath := filepath.Join(tempDir, "test_encrypted.db")
-	encryptionKey := "test-encryption-key-123"
-
-	manager, err := NewManager(dbPath, encryptionKey)
-	if err != nil {
-		t.Fatalf("NewManager() error = %v", err)
-	}
-	defer manager.database.Close()
-
-	// generate test key
-	privateKey, err := generateRSAKey(2048)
-	if err != nil {
-		t.Fatalf("Failed to generate key: %v", err)
-	}
+	forEachStore(t, func(t *testing.T, manager Store) {
+		// generate test key
+		privateKey, err := generateRSAKey(2048)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
 
-	// store encrypted key
-	expiry := time.Now().Add(time.Hour)
-	kid, err := manager.StoreKey(privateKey, expiry)
-	if err != nil {
-		t.Fatalf("StoreKey() error = %v", err)
-	}
+		// store encrypted key
+		expiry := time.Now().Add(time.Hour)
+		kid, err := manager.StoreKey(privateKey, "RS256", expiry)
+		if err != nil {
+			t.Fatalf("StoreKey() error = %v", err)
+		}
 
-	if kid == 0 {
-		t.Fatal("StoreKey() returned zero kid")
-	}
+		if kid == 0 {
+			t.Fatal("StoreKey() returned zero kid")
+		}
 
-	// retrieve valid keys
-	validKeys, err := manager.GetValidKeys()
-	if err != nil {
-		t.Fatalf("GetValidKeys() error = %v", err)
-	}
+		// retrieve valid keys
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
 
-	if len(validKeys) == 0 {
-		t.Fatal("No valid keys found")
-	}
+		if len(validKeys) == 0 {
+			t.Fatal("No valid keys found")
+		}
 
-	retrievedKey, exists := validKeys[kid]
-	if !exists {
-		t.Fatal("Stored key not found in valid keys")
-	}
+		retrievedKey, exists := storedPrivateKey(validKeys, kid).(*rsa.PrivateKey)
+		if !exists {
+			t.Fatal("Stored key not found in valid keys")
+		}
 
-	// verify key integrity
-	if privateKey.N.Cmp(retrievedKey.N) != 0 {
-		t.Error("Retrieved key modulus doesn't match original")
-	}
+		// verify key integrity
+		if privateKey.N.Cmp(retrievedKey.N) != 0 {
+			t.Error("Retrieved key modulus doesn't match original")
+		}
 
-	if privateKey.E != retrievedKey.E {
-		t.Error("Retrieved key exponent doesn't match original")
-	}
+		if privateKey.E != retrievedKey.E {
+			t.Error("Retrieved key exponent doesn't match original")
+		}
+	})
 }
 
 func TestManagerGetExpiredKeys(t *testing.T) {
-	tempDir := t.TempDir()
-	dbPath := filepath.Join(tempDir, "test_encrypted.db")
-	encryptionKey := "test-encryption-key-123"
-
-	manager, err := NewManager(dbPath, encryptionKey)
-	if err != nil {
-		t.Fatalf("NewManager() error = %v", err)
-	}
-	defer manager.database.Close()
-
-	// store expired key
-	privateKey, err := generateRSAKey(2048)
-	if err != nil {
-		t.Fatalf("Failed to generate key: %v", err)
-	}
+	forEachStore(t, func(t *testing.T, manager Store) {
+		// store expired key
+		privateKey, err := generateRSAKey(2048)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
 
-	expiry := time.Now().Add(-time.Hour) // expired 1 hour ago
-	kid, err := manager.StoreKey(privateKey, expiry)
-	if err != nil {
-		t.Fatalf("StoreKey() error = %v", err)
-	}
+		expiry := time.Now().Add(-time.Hour) // expired 1 hour ago
+		kid, err := manager.StoreKey(privateKey, "RS256", expiry)
+		if err != nil {
+			t.Fatalf("StoreKey() error = %v", err)
+		}
 
-	// retrieve expired keys
-	expiredKeys, err := manager.GetExpiredKeys()
-	if err != nil {
-		t.Fatalf("GetExpiredKeys() error = %v", err)
-	}
+		// retrieve expired keys
+		expiredKeys, err := manager.GetExpiredKeys()
+		if err != nil {
+			t.Fatalf("GetExpiredKeys() error = %v", err)
+		}
 
-	if len(expiredKeys) == 0 {
-		t.Fatal("No expired keys found")
-	}
+		if len(expiredKeys) == 0 {
+			t.Fatal("No expired keys found")
+		}
 
-	retrievedKey, exists := expiredKeys[kid]
-	if !exists {
-		t.Fatal("Stored expired key not found")
-	}
+		retrievedKey, exists := storedPrivateKey(expiredKeys, kid).(*rsa.PrivateKey)
+		if !exists {
+			t.Fatal("Stored expired key not found")
+		}
 
-	// verify key integrity
-	if privateKey.N.Cmp(retrievedKey.N) != 0 {
-		t.Error("Retrieved expired key modulus doesn't match original")
-	}
+		// verify key integrity
+		if privateKey.N.Cmp(retrievedKey.N) != 0 {
+			t.Error("Retrieved expired key modulus doesn't match original")
+		}
+	})
 }
 
 func TestEncryptionKeyMismatch(t *testing.T) {
@@ -139,7 +136,7 @@ func TestEncryptionKeyMismatch(t *testing.T) {
 	}
 
 	privateKey, _ := generateRSAKey(2048)
-	_, err = manager1.StoreKey(privateKey, time.Now().Add(time.Hour))
+	_, err = manager1.StoreKey(privateKey, "RS256", time.Now().Add(time.Hour))
 	if err != nil {
 		t.Fatalf("StoreKey() error = %v", err)
 	}
@@ -160,34 +157,26 @@ func TestEncryptionKeyMismatch(t *testing.T) {
 }
 
 func TestEmptyDatabase(t *testing.T) {
-	tempDir := t.TempDir()
-	dbPath := filepath.Join(tempDir, "test_empty.db")
-	encryptionKey := "test-encryption-key-123"
-
-	manager, err := NewManager(dbPath, encryptionKey)
-	if err != nil {
-		t.Fatalf("NewManager() error = %v", err)
-	}
-	defer manager.database.Close()
-
-	// test getting keys from empty database
-	validKeys, err := manager.GetValidKeys()
-	if err != nil {
-		t.Fatalf("GetValidKeys() error = %v", err)
-	}
+	forEachStore(t, func(t *testing.T, manager Store) {
+		// test getting keys from empty database
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
 
-	if len(validKeys) != 0 {
-		t.Errorf("Expected 0 keys from empty database, got %d", len(validKeys))
-	}
+		if len(validKeys) != 0 {
+			t.Errorf("Expected 0 keys from empty database, got %d", len(validKeys))
+		}
 
-	expiredKeys, err := manager.GetExpiredKeys()
-	if err != nil {
-		t.Fatalf("GetExpiredKeys() error = %v", err)
-	}
+		expiredKeys, err := manager.GetExpiredKeys()
+		if err != nil {
+			t.Fatalf("GetExpiredKeys() error = %v", err)
+		}
 
-	if len(expiredKeys) != 0 {
-		t.Errorf("Expected 0 expired keys from empty database, got %d", len(expiredKeys))
-	}
+		if len(expiredKeys) != 0 {
+			t.Errorf("Expected 0 expired keys from empty database, got %d", len(expiredKeys))
+		}
+	})
 }
 
 // Temporarily commented out due to Windows file locking issues
@@ -209,274 +198,958 @@ func TestEmptyDatabase(t *testing.T) {
 // }
 
 func TestMultipleKeys(t *testing.T) {
-	tempDir := t.TempDir()
-	dbPath := filepath.Join(tempDir, "test_multiple.db")
-	encryptionKey := "test-encryption-key-123"
+	forEachStore(t, func(t *testing.T, manager Store) {
+		// store multiple keys
+		numKeys := 5
+		originalKeys := make([]*rsa.PrivateKey, numKeys)
+		kids := make([]int, numKeys)
+
+		for i := 0; i < numKeys; i++ {
+			privateKey, err := generateRSAKey(2048)
+			if err != nil {
+				t.Fatalf("Failed to generate key %d: %v", i, err)
+			}
+
+			expiry := time.Now().Add(time.Hour)
+			kid, err := manager.StoreKey(privateKey, "RS256", expiry)
+			if err != nil {
+				t.Fatalf("StoreKey() %d error = %v", i, err)
+			}
+
+			originalKeys[i] = privateKey
+			kids[i] = kid
+		}
 
-	manager, err := NewManager(dbPath, encryptionKey)
-	if err != nil {
-		t.Fatalf("NewManager() error = %v", err)
-	}
-	defer manager.database.Close()
+		// retrieve all keys
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
 
-	// store multiple keys
-	numKeys := 5
-	originalKeys := make([]*rsa.PrivateKey, numKeys)
-	kids := make([]int, numKeys)
+		if len(validKeys) != numKeys {
+			t.Fatalf("Expected %d keys, got %d", numKeys, len(validKeys))
+		}
 
-	for i := 0; i < numKeys; i++ {
-		privateKey, err := generateRSAKey(2048)
+		// verify all keys are present and correct
+		for i, kid := range kids {
+			retrievedKey, exists := storedPrivateKey(validKeys, kid).(*rsa.PrivateKey)
+			if !exists {
+				t.Errorf("Key %d (kid %d) not found", i, kid)
+				continue
+			}
+
+			if originalKeys[i].N.Cmp(retrievedKey.N) != 0 {
+				t.Errorf("Key %d modulus doesn't match", i)
+			}
+		}
+	})
+}
+
+func TestLargeKeyData(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		// test with larger key size
+		privateKey, err := generateRSAKey(4096) // larger key
 		if err != nil {
-			t.Fatalf("Failed to generate key %d: %v", i, err)
+			t.Fatalf("Failed to generate large key: %v", err)
 		}
 
 		expiry := time.Now().Add(time.Hour)
-		kid, err := manager.StoreKey(privateKey, expiry)
+		kid, err := manager.StoreKey(privateKey, "RS256", expiry)
 		if err != nil {
-			t.Fatalf("StoreKey() %d error = %v", i, err)
+			t.Fatalf("StoreKey() error = %v", err)
 		}
 
-		originalKeys[i] = privateKey
-		kids[i] = kid
-	}
+		// retrieve and verify
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
 
-	// retrieve all keys
-	validKeys, err := manager.GetValidKeys()
+		retrievedKey, exists := storedPrivateKey(validKeys, kid).(*rsa.PrivateKey)
+		if !exists {
+			t.Fatal("Large key not found")
+		}
+
+		if privateKey.N.Cmp(retrievedKey.N) != 0 {
+			t.Error("Large key modulus doesn't match")
+		}
+	})
+}
+
+func TestDefaultManagerCreation(t *testing.T) {
+	// test creating manager with default database path
+	encryptionKey := "test-encryption-key-123"
+
+	manager, err := NewManager("", encryptionKey)
 	if err != nil {
-		t.Fatalf("GetValidKeys() error = %v", err)
+		t.Fatalf("NewManager() with default path error = %v", err)
+	}
+	defer func() {
+		if manager != nil && manager.database != nil {
+			manager.database.Close()
+		}
+	}()
+
+	if manager == nil {
+		t.Fatal("NewManager() with default path returned nil")
 	}
 
-	if len(validKeys) != numKeys {
-		t.Fatalf("Expected %d keys, got %d", numKeys, len(validKeys))
+	if manager.database == nil {
+		t.Fatal("Manager database is nil")
 	}
+}
+
+func TestManagerDatabaseOperations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		// test storing keys with various expiry times
+		futureTime := time.Now().Add(2 * time.Hour)
+		pastTime := time.Now().Add(-2 * time.Hour)
+
+		// store valid key
+		privateKey1, _ := generateRSAKey(2048)
+		kid1, err := manager.StoreKey(privateKey1, "RS256", futureTime)
+		if err != nil {
+			t.Fatalf("StoreKey() valid key error = %v", err)
+		}
+
+		// store expired key
+		privateKey2, _ := generateRSAKey(2048)
+		kid2, err := manager.StoreKey(privateKey2, "RS256", pastTime)
+		if err != nil {
+			t.Fatalf("StoreKey() expired key error = %v", err)
+		}
+
+		// verify separation of valid and expired keys
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
+
+		expiredKeys, err := manager.GetExpiredKeys()
+		if err != nil {
+			t.Fatalf("GetExpiredKeys() error = %v", err)
+		}
+
+		// check valid key is in valid set, not expired set
+		if _, exists := validKeys[kid1]; !exists {
+			t.Error("Valid key not found in valid keys")
+		}
+		if _, exists := expiredKeys[kid1]; exists {
+			t.Error("Valid key incorrectly found in expired keys")
+		}
+
+		// check expired key is in expired set, not valid set
+		if _, exists := expiredKeys[kid2]; !exists {
+			t.Error("Expired key not found in expired keys")
+		}
+		if _, exists := validKeys[kid2]; exists {
+			t.Error("Expired key incorrectly found in valid keys")
+		}
+	})
+}
+
+// Commented out due to Windows file locking issues
+// func TestManagerInvalidEncryptionKey(t *testing.T) {
+// 	tempDir := t.TempDir()
+// 	dbPath := filepath.Join(tempDir, "test_invalid_enc.db")
+//
+// 	// test with empty encryption key - should fail during encryptor creation
+// 	_, err := NewManager(dbPath, "")
+// 	if err == nil {
+// 		t.Error("NewManager() should fail with empty encryption key")
+// 	}
+// }
 
-	// verify all keys are present and correct
-	for i, kid := range kids {
-		retrievedKey, exists := validKeys[kid]
+func TestManagerDatabaseErrorHandling(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		// test database close and subsequent operations
+		manager.Close()
+
+		// operations should now fail with closed database
+		_, err := manager.GetValidKeys()
+		if err == nil {
+			t.Error("GetValidKeys() should fail with closed database")
+		}
+
+		_, err = manager.GetExpiredKeys()
+		if err == nil {
+			t.Error("GetExpiredKeys() should fail with closed database")
+		}
+
+		// test StoreKey with closed database
+		privateKey, _ := generateRSAKey(2048)
+		_, err = manager.StoreKey(privateKey, "RS256", time.Now().Add(time.Hour))
+		if err == nil {
+			t.Error("StoreKey() should fail with closed database")
+		}
+	})
+}
+
+func TestManagerMixedValidExpiredKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		now := time.Now()
+
+		// store multiple valid keys
+		for i := 0; i < 3; i++ {
+			privateKey, _ := generateRSAKey(2048)
+			expiry := now.Add(time.Duration(i+1) * time.Hour)
+			_, err := manager.StoreKey(privateKey, "RS256", expiry)
+			if err != nil {
+				t.Fatalf("Failed to store valid key %d: %v", i, err)
+			}
+		}
+
+		// store multiple expired keys
+		for i := 0; i < 2; i++ {
+			privateKey, _ := generateRSAKey(2048)
+			expiry := now.Add(-time.Duration(i+1) * time.Hour)
+			_, err := manager.StoreKey(privateKey, "RS256", expiry)
+			if err != nil {
+				t.Fatalf("Failed to store expired key %d: %v", i, err)
+			}
+		}
+
+		// verify counts
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
+
+		if len(validKeys) != 3 {
+			t.Errorf("Expected 3 valid keys, got %d", len(validKeys))
+		}
+
+		expiredKeys, err := manager.GetExpiredKeys()
+		if err != nil {
+			t.Fatalf("GetExpiredKeys() error = %v", err)
+		}
+
+		if len(expiredKeys) != 2 {
+			t.Errorf("Expected 2 expired keys, got %d", len(expiredKeys))
+		}
+	})
+}
+
+func TestManagerStoreAndRetrieveECKey(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+
+		kid, err := manager.StoreKey(privateKey, "ES256", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("StoreKey() error = %v", err)
+		}
+
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
+
+		retrievedKey, exists := storedPrivateKey(validKeys, kid).(*ecdsa.PrivateKey)
 		if !exists {
-			t.Errorf("Key %d (kid %d) not found", i, kid)
-			continue
+			t.Fatalf("Stored EC key not found, got %T", storedPrivateKey(validKeys, kid))
 		}
 
-		if originalKeys[i].N.Cmp(retrievedKey.N) != 0 {
-			t.Errorf("Key %d modulus doesn't match", i)
+		if !privateKey.Equal(retrievedKey) {
+			t.Error("Retrieved EC key doesn't match original")
 		}
-	}
+	})
 }
 
-func TestLargeKeyData(t *testing.T) {
+func TestManagerStoreAndRetrieveEd25519Key(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+
+		kid, err := manager.StoreKey(privateKey, "EdDSA", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("StoreKey() error = %v", err)
+		}
+
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
+
+		retrievedKey, exists := storedPrivateKey(validKeys, kid).(ed25519.PrivateKey)
+		if !exists {
+			t.Fatalf("Stored Ed25519 key not found, got %T", storedPrivateKey(validKeys, kid))
+		}
+
+		if !privateKey.Equal(retrievedKey) {
+			t.Error("Retrieved Ed25519 key doesn't match original")
+		}
+	})
+}
+
+func TestManagerReadsLegacyPKCS1Key(t *testing.T) {
 	tempDir := t.TempDir()
-	dbPath := filepath.Join(tempDir, "test_large.db")
-	encryptionKey := "test-encryption-key-123"
+	dbPath := filepath.Join(tempDir, "test_encrypted.db")
 
-	manager, err := NewManager(dbPath, encryptionKey)
+	manager, err := NewManager(dbPath, "test-encryption-key-123")
 	if err != nil {
 		t.Fatalf("NewManager() error = %v", err)
 	}
-	defer manager.database.Close()
 
-	// test with larger key size
-	privateKey, err := generateRSAKey(4096) // larger key
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		t.Fatalf("Failed to generate large key: %v", err)
+		t.Fatalf("Failed to generate key: %v", err)
 	}
 
-	expiry := time.Now().Add(time.Hour)
-	kid, err := manager.StoreKey(privateKey, expiry)
+	// rows written before PKCS8 storage hold an encrypted PKCS1 block
+	legacyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+	})
+	encrypted, err := manager.encryptor.Encrypt(legacyPEM)
 	if err != nil {
-		t.Fatalf("StoreKey() error = %v", err)
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	result, err := manager.database.conn.Exec("INSERT INTO keys (key, exp) VALUES (?, ?)", encrypted, time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatalf("Failed to insert legacy key: %v", err)
 	}
+	kid, _ := result.LastInsertId()
+
+	// reopening binds the old row like any other pre-existing key
+	manager.Close()
+	manager, err = NewManager(dbPath, "test-encryption-key-123")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer manager.Close()
 
-	// retrieve and verify
 	validKeys, err := manager.GetValidKeys()
 	if err != nil {
 		t.Fatalf("GetValidKeys() error = %v", err)
 	}
 
-	retrievedKey, exists := validKeys[kid]
-	if !exists {
-		t.Fatal("Large key not found")
+	retrievedKey, exists := storedPrivateKey(validKeys, int(kid)).(*rsa.PrivateKey)
+	if !exists || !privateKey.Equal(retrievedKey) {
+		t.Error("Legacy PKCS1 key was not read back")
 	}
+}
 
-	if privateKey.N.Cmp(retrievedKey.N) != 0 {
-		t.Error("Large key modulus doesn't match")
+// storedPrivateKey returns the private key for kid, nil if it wasn't returned
+func storedPrivateKey(keys map[int]*StoredKey, kid int) stdcrypto.Signer {
+	if stored, ok := keys[kid]; ok {
+		return stored.PrivateKey
 	}
+	return nil
 }
 
-func TestDefaultManagerCreation(t *testing.T) {
-	// test creating manager with default database path
-	encryptionKey := "test-encryption-key-123"
+func TestManagerStoresAlgorithm(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		privateKey, err := generateRSAKey(2048)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
 
-	manager, err := NewManager("", encryptionKey)
+		expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+		kid, err := manager.StoreKey(privateKey, "PS256", expiry)
+		if err != nil {
+			t.Fatalf("StoreKey() error = %v", err)
+		}
+
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
+
+		stored, exists := validKeys[kid]
+		if !exists {
+			t.Fatal("Stored key not found")
+		}
+
+		if stored.Algorithm != "PS256" {
+			t.Errorf("Expected algorithm PS256, got %q", stored.Algorithm)
+		}
+
+		if !stored.ExpiresAt.Equal(expiry) {
+			t.Errorf("Expected expiry %v, got %v", expiry, stored.ExpiresAt)
+		}
+	})
+}
+
+func TestAddAlgColumnToLegacyKeysTable(t *testing.T) {
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "legacy.db")
+
+	// keys table as created before the alg column existed
+	conn, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		t.Fatalf("NewManager() with default path error = %v", err)
+		t.Fatalf("sql.Open() error = %v", err)
 	}
-	defer func() {
-		if manager != nil && manager.database != nil {
-			manager.database.Close()
-		}
-	}()
+	if _, err := conn.Exec("CREATE TABLE keys(kid INTEGER PRIMARY KEY AUTOINCREMENT, key BLOB NOT NULL, exp INTEGER NOT NULL)"); err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+	conn.Close()
 
-	if manager == nil {
-		t.Fatal("NewManager() with default path returned nil")
+	// older versions also created the file owner-only
+	if err := os.Chmod(dbPath, 0600); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
 	}
 
-	if manager.database == nil {
-		t.Fatal("Manager database is nil")
+	manager, err := NewManager(dbPath, "test-encryption-key-123")
+	if err != nil {
+		t.Fatalf("NewManager() on legacy schema error = %v", err)
+	}
+	defer manager.database.Close()
+
+	privateKey, _ := generateRSAKey(2048)
+	if _, err := manager.StoreKey(privateKey, "RS384", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("StoreKey() after upgrade error = %v", err)
 	}
 }
 
-func TestManagerDatabaseOperations(t *testing.T) {
-	tempDir := t.TempDir()
-	dbPath := filepath.Join(tempDir, "test_ops.db")
-	encryptionKey := "test-encryption-key-123"
+func TestManagerActiveKeySelection(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		if _, err := manager.GetActiveKey(); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound on empty database, got %v", err)
+		}
 
-	manager, err := NewManager(dbPath, encryptionKey)
+		now := time.Now()
+		store := func(expiry time.Time) int {
+			privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatalf("Failed to generate key: %v", err)
+			}
+			kid, err := manager.StoreKey(privateKey, "ES256", expiry)
+			if err != nil {
+				t.Fatalf("StoreKey() error = %v", err)
+			}
+			return kid
+		}
+
+		// a long lived key stored first must not outrank a newer rotation
+		store(now.Add(time.Hour))
+		newest := store(now.Add(10 * time.Minute))
+		olderExpired := store(now.Add(-time.Hour))
+		latestExpired := store(now.Add(-time.Minute))
+
+		for i := 0; i < 5; i++ {
+			active, err := manager.GetActiveKey()
+			if err != nil {
+				t.Fatalf("GetActiveKey() error = %v", err)
+			}
+			if active.Kid != newest {
+				t.Fatalf("Expected active kid %d, got %d", newest, active.Kid)
+			}
+		}
+
+		expired, err := manager.GetLatestExpiredKey()
+		if err != nil {
+			t.Fatalf("GetLatestExpiredKey() error = %v", err)
+		}
+		if expired.Kid != latestExpired {
+			t.Errorf("Expected expired kid %d, got %d (older expired is %d)", latestExpired, expired.Kid, olderExpired)
+		}
+	})
+}
+
+func TestAdvanceKeyStates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		now := time.Now().Truncate(time.Second)
+		expiry := now.Add(24 * time.Hour)
+		retireFor := 5 * time.Minute
+
+		privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		first, err := manager.StorePendingKey(privateKey, "ES256", now, expiry)
+		if err != nil {
+			t.Fatalf("StorePendingKey() error = %v", err)
+		}
+		if err := manager.AdvanceKeyStates(now, retireFor); err != nil {
+			t.Fatalf("AdvanceKeyStates() error = %v", err)
+		}
+
+		// successor is pre-published a minute ahead
+		nextKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		second, err := manager.StorePendingKey(nextKey, "ES256", now.Add(time.Minute), expiry)
+		if err != nil {
+			t.Fatalf("StorePendingKey() error = %v", err)
+		}
+
+		steps := []struct {
+			name          string
+			at            time.Time
+			first, second string
+		}{
+			{"lead time", now.Add(30 * time.Second), KeyStatusActive, KeyStatusPending},
+			{"successor activates", now.Add(time.Minute), KeyStatusRetired, KeyStatusActive},
+			{"inside retire period", now.Add(time.Minute + retireFor - time.Second), KeyStatusRetired, KeyStatusActive},
+			{"retire period over", now.Add(time.Minute + retireFor), KeyStatusPurged, KeyStatusActive},
+		}
+
+		for _, step := range steps {
+			if err := manager.AdvanceKeyStates(step.at, retireFor); err != nil {
+				t.Fatalf("%s: AdvanceKeyStates() error = %v", step.name, err)
+			}
+			if got := keyStatus(t, manager, first); got != step.first {
+				t.Errorf("%s: first key status = %s, want %s", step.name, got, step.first)
+			}
+			if got := keyStatus(t, manager, second); got != step.second {
+				t.Errorf("%s: second key status = %s, want %s", step.name, got, step.second)
+			}
+		}
+
+		// purged keys are no longer published
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
+		if _, published := validKeys[first]; published {
+			t.Error("Purged key still published")
+		}
+		if stored := validKeys[second]; stored == nil || stored.Status != KeyStatusActive {
+			t.Errorf("Expected active second key, got %+v", stored)
+		}
+	})
+}
+
+func TestPurgeExpiredKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		now := time.Now()
+		store := func(expiry time.Time) int {
+			privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			kid, err := manager.StoreKey(privateKey, "ES256", expiry)
+			if err != nil {
+				t.Fatalf("StoreKey() error = %v", err)
+			}
+			return kid
+		}
+
+		old := store(now.Add(-2 * time.Hour))
+		recentlyExpired := store(now.Add(-time.Minute))
+		valid := store(now.Add(time.Hour))
+
+		cutoff := now.Add(-time.Hour)
+
+		// dry run reports without deleting or auditing
+		planned, err := manager.PurgeExpiredKeys(cutoff, true)
+		if err != nil {
+			t.Fatalf("PurgeExpiredKeys(dry run) error = %v", err)
+		}
+		if len(planned) != 1 || planned[0].Kid != old || planned[0].Algorithm != "ES256" {
+			t.Errorf("Dry run selected %+v, want only kid %d", planned, old)
+		}
+		if n := keyCount(t, manager); n != 3 {
+			t.Errorf("Dry run deleted keys, %d left", n)
+		}
+		if n := len(purgeAudit(t, manager)); n != 0 {
+			t.Errorf("Dry run wrote %d audit rows", n)
+		}
+
+		purged, err := manager.PurgeExpiredKeys(cutoff, false)
+		if err != nil {
+			t.Fatalf("PurgeExpiredKeys() error = %v", err)
+		}
+		if len(purged) != 1 || purged[0].Kid != old {
+			t.Errorf("Purged %+v, want only kid %d", purged, old)
+		}
+
+		expiredKeys, err := manager.GetExpiredKeys()
+		if err != nil {
+			t.Fatalf("GetExpiredKeys() error = %v", err)
+		}
+		if _, exists := expiredKeys[old]; exists {
+			t.Error("Purged key still stored")
+		}
+		if _, exists := expiredKeys[recentlyExpired]; !exists {
+			t.Error("Key inside the retain period was purged")
+		}
+		if validKeys, _ := manager.GetValidKeys(); validKeys[valid] == nil {
+			t.Error("Valid key was purged")
+		}
+
+		audit := purgeAudit(t, manager)
+		if len(audit) != 1 || audit[0].Kid != old || audit[0].ExpiresAt.Unix() != now.Add(-2*time.Hour).Unix() {
+			t.Errorf("Unexpected audit records %+v", audit)
+		}
+
+		// nothing left to purge
+		if purged, err := manager.PurgeExpiredKeys(cutoff, false); err != nil || len(purged) != 0 {
+			t.Errorf("Second purge = %v, %v; want nothing", purged, err)
+		}
+	})
+}
+
+func TestManagerKeyMetadataRoundTrip(t *testing.T) {
+	forEachStore(t, func(t *testing.T, manager Store) {
+		privateKey, err := generateRSAKey(3072)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+
+		now := time.Now().Truncate(time.Second)
+		meta := KeyMetadata{
+			Algorithm:  "PS384",
+			Origin:     KeyOriginRotation,
+			Status:     KeyStatusPending,
+			CreatedAt:  now.Add(-time.Minute),
+			ActivateAt: now.Add(time.Minute),
+			ExpiresAt:  now.Add(time.Hour),
+		}
+
+		kid, err := manager.StoreKeyWithMetadata(privateKey, meta)
+		if err != nil {
+			t.Fatalf("StoreKeyWithMetadata() error = %v", err)
+		}
+
+		validKeys, err := manager.GetValidKeys()
+		if err != nil {
+			t.Fatalf("GetValidKeys() error = %v", err)
+		}
+
+		stored := validKeys[kid]
+		if stored == nil {
+			t.Fatal("Stored key not found")
+		}
+
+		// defaults fill in size and usage
+		meta.KeySize = 3072
+		meta.Usage = "sig"
+		if stored.KeyMetadata != meta {
+			t.Errorf("Metadata round trip mismatch:\n got  %+v\n want %+v", stored.KeyMetadata, meta)
+		}
+
+		// imported keys record their origin and derived size
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		importedKid, err := manager.StoreKey(ecKey, "ES256", now.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("StoreKey() error = %v", err)
+		}
+
+		validKeys, _ = manager.GetValidKeys()
+		imported := validKeys[importedKid]
+		if imported.Origin != KeyOriginImport || imported.KeySize != 256 || imported.CreatedAt.IsZero() {
+			t.Errorf("Unexpected imported key metadata %+v", imported.KeyMetadata)
+		}
+	})
+}
+
+func TestReencryptKeys(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test_rekey.db")
+
+	oldManager, err := NewManager(dbPath, "old-master-key")
 	if err != nil {
 		t.Fatalf("NewManager() error = %v", err)
 	}
-	defer manager.database.Close()
+	want := make(map[int]stdcrypto.Signer)
+	for i := 0; i < 3; i++ {
+		privateKey, _ := generateRSAKey(2048)
+		kid, err := oldManager.StoreKey(privateKey, "RS256", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("StoreKey() error = %v", err)
+		}
+		want[kid] = privateKey
+	}
+	oldManager.Close()
 
-	// test storing keys with various expiry times
-	futureTime := time.Now().Add(2 * time.Hour)
-	pastTime := time.Now().Add(-2 * time.Hour)
+	// rotated config - new key first, old key kept for decryption
+	rotated, err := NewManager(dbPath, "new-master-key", "old-master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, err := rotated.GetValidKeys(); err != nil {
+		t.Fatalf("GetValidKeys() with previous key error = %v", err)
+	}
 
-	// store valid key
-	privateKey1, _ := generateRSAKey(2048)
-	kid1, err := manager.StoreKey(privateKey1, futureTime)
+	count, err := rotated.ReencryptKeys()
 	if err != nil {
-		t.Fatalf("StoreKey() valid key error = %v", err)
+		t.Fatalf("ReencryptKeys() error = %v", err)
 	}
+	if count != len(want) {
+		t.Errorf("ReencryptKeys() = %d, want %d", count, len(want))
+	}
+
+	// a second run has nothing left to do
+	if count, err := rotated.ReencryptKeys(); err != nil || count != 0 {
+		t.Errorf("second ReencryptKeys() = %d, %v, want 0, nil", count, err)
+	}
+	rotated.Close()
 
-	// store expired key
-	privateKey2, _ := generateRSAKey(2048)
-	kid2, err := manager.StoreKey(privateKey2, pastTime)
+	// the old key is no longer needed
+	newOnly, err := NewManager(dbPath, "new-master-key")
 	if err != nil {
-		t.Fatalf("StoreKey() expired key error = %v", err)
+		t.Fatalf("NewManager() error = %v", err)
 	}
+	defer newOnly.Close()
 
-	// verify separation of valid and expired keys
-	validKeys, err := manager.GetValidKeys()
+	validKeys, err := newOnly.GetValidKeys()
 	if err != nil {
-		t.Fatalf("GetValidKeys() error = %v", err)
+		t.Fatalf("GetValidKeys() with only the new key error = %v", err)
+	}
+	for kid, privateKey := range want {
+		if got := storedPrivateKey(validKeys, kid); got == nil || !privateKey.(*rsa.PrivateKey).Equal(got) {
+			t.Errorf("key %d did not survive re-encryption", kid)
+		}
 	}
+}
 
-	expiredKeys, err := manager.GetExpiredKeys()
+func TestReencryptKeysIsAllOrNothing(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test_rekey_rollback.db")
+
+	manager, err := NewManager(dbPath, "new-master-key", "old-master-key")
 	if err != nil {
-		t.Fatalf("GetExpiredKeys() error = %v", err)
+		t.Fatalf("NewManager() error = %v", err)
 	}
+	defer manager.Close()
 
-	// check valid key is in valid set, not expired set
-	if _, exists := validKeys[kid1]; !exists {
-		t.Error("Valid key not found in valid keys")
+	// one row under the old key, one under a key nobody configured
+	old, _ := NewManager(dbPath, "old-master-key")
+	privateKey, _ := generateRSAKey(2048)
+	if _, err := old.StoreKey(privateKey, "RS256", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
 	}
-	if _, exists := expiredKeys[kid1]; exists {
-		t.Error("Valid key incorrectly found in expired keys")
+	old.Close()
+
+	lost, _ := NewManager(dbPath, "lost-master-key")
+	if _, err := lost.StoreKey(privateKey, "RS256", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
 	}
+	lost.Close()
 
-	// check expired key is in expired set, not valid set
-	if _, exists := expiredKeys[kid2]; !exists {
-		t.Error("Expired key not found in expired keys")
+	before := rawKeyBlobs(t, manager)
+
+	if _, err := manager.ReencryptKeys(); err == nil {
+		t.Fatal("ReencryptKeys() should fail when a key cannot be decrypted")
 	}
-	if _, exists := validKeys[kid2]; exists {
-		t.Error("Expired key incorrectly found in valid keys")
+
+	after := rawKeyBlobs(t, manager)
+	for kid, blob := range before {
+		if string(after[kid]) != string(blob) {
+			t.Errorf("key %d was rewritten by a failed re-encrypt", kid)
+		}
 	}
 }
 
-// Commented out due to Windows file locking issues
-// func TestManagerInvalidEncryptionKey(t *testing.T) {
-// 	tempDir := t.TempDir()
-// 	dbPath := filepath.Join(tempDir, "test_invalid_enc.db")
-//
-// 	// test with empty encryption key - should fail during encryptor creation
-// 	_, err := NewManager(dbPath, "")
-// 	if err == nil {
-// 		t.Error("NewManager() should fail with empty encryption key")
-// 	}
-// }
+// rawKeyBlobs returns the encrypted key column by kid
+func rawKeyBlobs(t *testing.T, manager *Manager) map[int][]byte {
+	t.Helper()
+	rows, err := manager.database.conn.Query("SELECT kid, key FROM keys")
+	if err != nil {
+		t.Fatalf("Failed to query keys: %v", err)
+	}
+	defer rows.Close()
+
+	blobs := make(map[int][]byte)
+	for rows.Next() {
+		var kid int
+		var blob []byte
+		if err := rows.Scan(&kid, &blob); err != nil {
+			t.Fatalf("Failed to scan key: %v", err)
+		}
+		blobs[kid] = blob
+	}
+	return blobs
+}
 
-func TestManagerDatabaseErrorHandling(t *testing.T) {
-	tempDir := t.TempDir()
-	dbPath := filepath.Join(tempDir, "test_errors.db")
-	encryptionKey := "test-encryption-key-123"
+func TestKeyBlobsBoundToRow(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test_aad.db")
 
-	manager, err := NewManager(dbPath, encryptionKey)
+	manager, err := NewManager(dbPath, "test-encryption-key-123")
 	if err != nil {
 		t.Fatalf("NewManager() error = %v", err)
 	}
-	defer manager.database.Close()
+	defer manager.Close()
 
-	// test database close and subsequent operations
-	manager.database.Close()
+	expiry := time.Now().Add(time.Hour)
+	first, _ := generateRSAKey(2048)
+	second, _ := generateRSAKey(2048)
+	kid1, err := manager.StoreKey(first, "RS256", expiry)
+	if err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
+	}
+	kid2, err := manager.StoreKey(second, "RS256", expiry)
+	if err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
+	}
 
-	// operations should now fail with closed database
-	_, err = manager.GetValidKeys()
-	if err == nil {
-		t.Error("GetValidKeys() should fail with closed database")
+	blobs := rawKeyBlobs(t, manager)
+	for kid, blob := range blobs {
+		if !manager.encryptor.IsBound(blob) {
+			t.Errorf("key %d was stored without associated data", kid)
+		}
 	}
 
-	_, err = manager.GetExpiredKeys()
-	if err == nil {
-		t.Error("GetExpiredKeys() should fail with closed database")
+	restore := func() {
+		t.Helper()
+		for kid, blob := range blobs {
+			if _, err := manager.database.conn.Exec("UPDATE keys SET key = ?, alg = ?, exp = ? WHERE kid = ?",
+				blob, "RS256", expiry.Unix(), kid); err != nil {
+				t.Fatalf("restore error = %v", err)
+			}
+		}
+		if _, err := manager.GetValidKeys(); err != nil {
+			t.Fatalf("GetValidKeys() after restore error = %v", err)
+		}
+	}
+	restore()
+
+	tests := []struct {
+		name  string
+		query string
+		args  []interface{}
+	}{
+		{"blob moved to another kid", "UPDATE keys SET key = ? WHERE kid = ?", []interface{}{blobs[kid2], kid1}},
+		{"expiry extended", "UPDATE keys SET exp = ? WHERE kid = ?", []interface{}{expiry.Add(24 * time.Hour).Unix(), kid2}},
+		{"algorithm changed", "UPDATE keys SET alg = ? WHERE kid = ?", []interface{}{"ES256", kid2}},
 	}
 
-	// test StoreKey with closed database
-	privateKey, _ := generateRSAKey(2048)
-	_, err = manager.StoreKey(privateKey, time.Now().Add(time.Hour))
-	if err == nil {
-		t.Error("StoreKey() should fail with closed database")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := manager.database.conn.Exec(tt.query, tt.args...); err != nil {
+				t.Fatalf("tamper error = %v", err)
+			}
+			if _, err := manager.GetValidKeys(); err == nil {
+				t.Error("GetValidKeys() should fail for a tampered row")
+			}
+			restore()
+		})
 	}
 }
 
-func TestManagerMixedValidExpiredKeys(t *testing.T) {
-	tempDir := t.TempDir()
-	dbPath := filepath.Join(tempDir, "test_mixed.db")
-	encryptionKey := "test-encryption-key-123"
+func TestManagerBindsExistingRows(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test_bind.db")
 
-	manager, err := NewManager(dbPath, encryptionKey)
+	manager, err := NewManager(dbPath, "test-encryption-key-123")
 	if err != nil {
 		t.Fatalf("NewManager() error = %v", err)
 	}
-	defer manager.database.Close()
 
-	now := time.Now()
+	// a row written before binding - sealed without associated data
+	privateKey, _ := generateRSAKey(2048)
+	pemData, err := marshalPrivateKeyPEM(privateKey)
+	if err != nil {
+		t.Fatalf("marshalPrivateKeyPEM() error = %v", err)
+	}
+	unbound, err := manager.encryptor.Encrypt(pemData)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	result, err := manager.database.conn.Exec("INSERT INTO keys (key, exp, alg) VALUES (?, ?, ?)",
+		unbound, time.Now().Add(time.Hour).Unix(), "RS256")
+	if err != nil {
+		t.Fatalf("Failed to insert unbound key: %v", err)
+	}
+	kid, _ := result.LastInsertId()
 
-	// store multiple valid keys
-	for i := 0; i < 3; i++ {
-		privateKey, _ := generateRSAKey(2048)
-		expiry := now.Add(time.Duration(i+1) * time.Hour)
-		_, err := manager.StoreKey(privateKey, expiry)
-		if err != nil {
-			t.Fatalf("Failed to store valid key %d: %v", i, err)
-		}
+	// unbound blobs are refused - otherwise they could still be swapped
+	if _, err := manager.GetValidKeys(); err == nil {
+		t.Error("GetValidKeys() should refuse an unbound key")
 	}
+	manager.Close()
 
-	// store multiple expired keys
-	for i := 0; i < 2; i++ {
-		privateKey, _ := generateRSAKey(2048)
-		expiry := now.Add(-time.Duration(i+1) * time.Hour)
-		_, err := manager.StoreKey(privateKey, expiry)
-		if err != nil {
-			t.Fatalf("Failed to store expired key %d: %v", i, err)
-		}
+	manager, err = NewManager(dbPath, "test-encryption-key-123")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
 	}
+	defer manager.Close()
 
-	// verify counts
+	if !manager.encryptor.IsBound(rawKeyBlobs(t, manager)[int(kid)]) {
+		t.Fatal("Existing row was not bound on open")
+	}
 	validKeys, err := manager.GetValidKeys()
 	if err != nil {
 		t.Fatalf("GetValidKeys() error = %v", err)
 	}
+	if got := storedPrivateKey(validKeys, int(kid)); got == nil || !privateKey.Equal(got) {
+		t.Error("Bound key did not round trip")
+	}
+}
+
+func TestManagerWithKEKProviders(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "test_kek.db")
 
-	if len(validKeys) != 3 {
-		t.Errorf("Expected 3 valid keys, got %d", len(validKeys))
+	keyFile := filepath.Join(dir, "kek")
+	if err := crypto.GenerateKeyFile(keyFile); err != nil {
+		t.Fatalf("GenerateKeyFile() error = %v", err)
+	}
+	fileKEK, err := crypto.NewKeyFileKEK(keyFile)
+	if err != nil {
+		t.Fatalf("NewKeyFileKEK() error = %v", err)
 	}
 
-	expiredKeys, err := manager.GetExpiredKeys()
+	kms := crypto.NewKMSServer("")
+	if err := kms.AddKey("jwks-master", nil); err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+	kmsServer := httptest.NewServer(kms)
+	defer kmsServer.Close()
+	kmsKEK, err := crypto.NewHTTPKEK(kmsServer.URL, "jwks-master", "", nil)
 	if err != nil {
-		t.Fatalf("GetExpiredKeys() error = %v", err)
+		t.Fatalf("NewHTTPKEK() error = %v", err)
+	}
+
+	// a key written under the env passphrase
+	manager, err := NewManager(dbPath, "master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	passphraseKey, _ := generateRSAKey(2048)
+	passphraseKid, err := manager.StoreKey(passphraseKey, "RS256", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
+	}
+	manager.Close()
+
+	// moving to a key file - the passphrase stays around for reading
+	manager, err = NewManagerWithKEK(dbPath, fileKEK, nil, "master-key")
+	if err != nil {
+		t.Fatalf("NewManagerWithKEK() error = %v", err)
+	}
+	fileKey, _ := generateRSAKey(2048)
+	fileKid, err := manager.StoreKey(fileKey, "RS256", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
+	}
+	if validKeys, err := manager.GetValidKeys(); err != nil || len(validKeys) != 2 {
+		t.Fatalf("GetValidKeys() = %d keys, %v", len(validKeys), err)
+	}
+	manager.Close()
+
+	// then to the KMS, rekeying everything under it
+	manager, err = NewManagerWithKEK(dbPath, kmsKEK, []crypto.KEKProvider{fileKEK}, "master-key")
+	if err != nil {
+		t.Fatalf("NewManagerWithKEK() error = %v", err)
+	}
+	if count, err := manager.ReencryptKeys(); err != nil || count != 2 {
+		t.Fatalf("ReencryptKeys() = %d, %v, want 2", count, err)
+	}
+	for kid, blob := range rawKeyBlobs(t, manager) {
+		if !manager.encryptor.IsEnvelope(blob) || manager.encryptor.NeedsReencrypt(blob) {
+			t.Errorf("key %d is not an envelope under the KMS key", kid)
+		}
+	}
+	manager.Close()
+
+	// neither the passphrase nor the key file is needed any more
+	manager, err = NewManagerWithKEK(dbPath, kmsKEK, nil)
+	if err != nil {
+		t.Fatalf("NewManagerWithKEK() error = %v", err)
+	}
+	defer manager.Close()
+
+	validKeys, err := manager.GetValidKeys()
+	if err != nil {
+		t.Fatalf("GetValidKeys() with only the KMS error = %v", err)
+	}
+	if got := storedPrivateKey(validKeys, passphraseKid); got == nil || !passphraseKey.Equal(got) {
+		t.Error("passphrase-era key did not survive the move to the KMS")
+	}
+	if got := storedPrivateKey(validKeys, fileKid); got == nil || !fileKey.Equal(got) {
+		t.Error("key-file-era key did not survive the move to the KMS")
 	}
 
-	if len(expiredKeys) != 2 {
-		t.Errorf("Expected 2 expired keys, got %d", len(expiredKeys))
+	// losing the KMS key loses the keys
+	kms.RemoveKey("jwks-master")
+	if _, err := manager.GetValidKeys(); err == nil {
+		t.Error("GetValidKeys() should fail once the KMS key is gone")
 	}
 }
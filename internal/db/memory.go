@@ -0,0 +1,441 @@
+package db
+
+import (
+	stdcrypto "crypto"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is a pure-Go Store for CGO-free builds and tests.
+// Nothing survives a restart, so keys are held unencrypted.
+type MemoryStore struct {
+	mu       sync.Mutex
+	keys     map[int]*memoryKey
+	purges   []memoryPurge
+	users    map[int64]*User
+	authLogs []*AuthLog
+	nextKid  int
+	nextUser int64
+	nextLog  int64
+	closed   bool
+}
+
+// one keys row - times are unix seconds so behaviour matches the SQLite store exactly
+type memoryKey struct {
+	privateKey stdcrypto.Signer
+	alg        string
+	status     string
+	usage      string
+	origin     string
+	keySize    int
+	exp        int64
+	activateAt int64
+	retiredAt  int64
+	createdAt  int64
+}
+
+// one key_purges row
+type memoryPurge struct {
+	PurgedKey
+	purgedAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		keys:  make(map[int]*memoryKey),
+		users: make(map[int64]*User),
+	}
+}
+
+// Close discards the store - later calls fail like a closed database
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// helper - callers hold mu
+func (s *MemoryStore) checkOpen() error {
+	if s.closed {
+		return fmt.Errorf("memory store is closed")
+	}
+	return nil
+}
+
+// StoreKey saves an imported private key that signs immediately
+func (s *MemoryStore) StoreKey(privateKey stdcrypto.Signer, alg string, expiry time.Time) (int, error) {
+	now := time.Now()
+	return s.StoreKeyWithMetadata(privateKey, KeyMetadata{
+		Algorithm:  alg,
+		Origin:     KeyOriginImport,
+		Status:     KeyStatusActive,
+		CreatedAt:  now,
+		ActivateAt: now,
+		ExpiresAt:  expiry,
+	})
+}
+
+// StorePendingKey saves a rotated key that starts signing at activateAt
+func (s *MemoryStore) StorePendingKey(privateKey stdcrypto.Signer, alg string, activateAt, expiry time.Time) (int, error) {
+	return s.StoreKeyWithMetadata(privateKey, KeyMetadata{
+		Algorithm:  alg,
+		Origin:     KeyOriginRotation,
+		Status:     KeyStatusPending,
+		ActivateAt: activateAt,
+		ExpiresAt:  expiry,
+	})
+}
+
+// StoreKeyWithMetadata saves a private key with its metadata
+func (s *MemoryStore) StoreKeyWithMetadata(privateKey stdcrypto.Signer, meta KeyMetadata) (int, error) {
+	if meta.Status == "" {
+		meta.Status = KeyStatusActive
+	}
+	if meta.Usage == "" {
+		meta.Usage = "sig"
+	}
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now()
+	}
+	if meta.KeySize == 0 {
+		meta.KeySize = keySize(privateKey)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return 0, err
+	}
+
+	s.nextKid++
+	s.keys[s.nextKid] = &memoryKey{
+		privateKey: privateKey,
+		alg:        meta.Algorithm,
+		status:     meta.Status,
+		usage:      meta.Usage,
+		origin:     meta.Origin,
+		keySize:    meta.KeySize,
+		exp:        meta.ExpiresAt.Unix(),
+		activateAt: unixOrZero(meta.ActivateAt),
+		createdAt:  meta.CreatedAt.Unix(),
+	}
+
+	return s.nextKid, nil
+}
+
+// GetValidKeys returns the published keys - unexpired and not purged
+func (s *MemoryStore) GetValidKeys() (map[int]*StoredKey, error) {
+	now := time.Now().Unix()
+	return s.getKeys(func(key *memoryKey) bool {
+		return key.exp > now && key.status != KeyStatusPurged
+	})
+}
+
+func (s *MemoryStore) GetExpiredKeys() (map[int]*StoredKey, error) {
+	now := time.Now().Unix()
+	return s.getKeys(func(key *memoryKey) bool { return key.exp <= now })
+}
+
+// GetActiveKey returns the newest unexpired active key - the one that signs
+func (s *MemoryStore) GetActiveKey() (*StoredKey, error) {
+	now := time.Now().Unix()
+	return s.getKey(func(key *memoryKey) bool {
+		return key.exp > now && key.status == KeyStatusActive
+	}, func(a, b *memoryKey, kidA, kidB int) bool { return kidA > kidB })
+}
+
+// GetLatestExpiredKey returns the most recently expired key
+func (s *MemoryStore) GetLatestExpiredKey() (*StoredKey, error) {
+	now := time.Now().Unix()
+	return s.getKey(func(key *memoryKey) bool { return key.exp <= now },
+		func(a, b *memoryKey, kidA, kidB int) bool {
+			if a.exp != b.exp {
+				return a.exp > b.exp
+			}
+			return kidA > kidB
+		})
+}
+
+// AdvanceKeyStates applies due transitions as of now - see Manager.AdvanceKeyStates
+func (s *MemoryStore) AdvanceKeyStates(now time.Time, retireFor time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+
+	// newest due pending key
+	kid := 0
+	var next *memoryKey
+	for id, key := range s.keys {
+		if key.status != KeyStatusPending || key.activateAt > now.Unix() {
+			continue
+		}
+		if next == nil || key.activateAt > next.activateAt || (key.activateAt == next.activateAt && id > kid) {
+			kid, next = id, key
+		}
+	}
+
+	if next != nil {
+		// predecessors retire the moment their successor starts signing
+		for id, key := range s.keys {
+			if id == kid || key.activateAt > next.activateAt {
+				continue
+			}
+			if key.status == KeyStatusActive || key.status == KeyStatusPending {
+				key.status = KeyStatusRetired
+				key.retiredAt = next.activateAt
+			}
+		}
+		next.status = KeyStatusActive
+	}
+
+	cutoff := now.Add(-retireFor).Unix()
+	for _, key := range s.keys {
+		if key.status == KeyStatusRetired && key.retiredAt <= cutoff {
+			key.status = KeyStatusPurged
+		}
+	}
+
+	return nil
+}
+
+// PurgeExpiredKeys deletes keys that expired at or before cutoff and records each
+// deletion. dryRun reports the keys without touching the store.
+func (s *MemoryStore) PurgeExpiredKeys(cutoff time.Time, dryRun bool) ([]PurgedKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	var purged []PurgedKey
+	for kid, key := range s.keys {
+		if key.exp <= cutoff.Unix() {
+			purged = append(purged, PurgedKey{Kid: kid, Algorithm: key.alg, ExpiresAt: time.Unix(key.exp, 0)})
+		}
+	}
+	sort.Slice(purged, func(i, j int) bool { return purged[i].Kid < purged[j].Kid })
+
+	if dryRun {
+		return purged, nil
+	}
+
+	purgedAt := time.Unix(time.Now().Unix(), 0)
+	for _, key := range purged {
+		delete(s.keys, key.Kid)
+		s.purges = append(s.purges, memoryPurge{PurgedKey: key, purgedAt: purgedAt})
+	}
+
+	return purged, nil
+}
+
+// helper - first match in order, ErrKeyNotFound when nothing matches
+func (s *MemoryStore) getKey(match func(*memoryKey) bool, before func(a, b *memoryKey, kidA, kidB int) bool) (*StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	kid := 0
+	var best *memoryKey
+	for id, key := range s.keys {
+		if match(key) && (best == nil || before(key, best, id, kid)) {
+			kid, best = id, key
+		}
+	}
+
+	if best == nil {
+		return nil, ErrKeyNotFound
+	}
+	return best.stored(kid), nil
+}
+
+func (s *MemoryStore) getKeys(match func(*memoryKey) bool) (map[int]*StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[int]*StoredKey)
+	for kid, key := range s.keys {
+		if match(key) {
+			keys[kid] = key.stored(kid)
+		}
+	}
+	return keys, nil
+}
+
+// copy out as a StoredKey, converting times the way the SQLite store reads them back
+func (key *memoryKey) stored(kid int) *StoredKey {
+	return &StoredKey{
+		Kid: kid,
+		KeyMetadata: KeyMetadata{
+			Algorithm:  key.alg,
+			KeySize:    key.keySize,
+			Usage:      key.usage,
+			Origin:     key.origin,
+			Status:     key.status,
+			CreatedAt:  timeOrZero(key.createdAt),
+			ActivateAt: timeOrZero(key.activateAt),
+			RetiredAt:  timeOrZero(key.retiredAt),
+			ExpiresAt:  time.Unix(key.exp, 0),
+		},
+		PrivateKey: key.privateKey,
+	}
+}
+
+// CreateUser creates a new user with a generated password
+func (s *MemoryStore) CreateUser(username, email string) (string, error) {
+	// generate secure password using UUIDv4
+	password := uuid.New().String()
+
+	passwordHash, err := newPasswordHash(password)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return "", err
+	}
+
+	// same uniqueness rules as the users table
+	for _, user := range s.users {
+		if user.Username == username {
+			return "", fmt.Errorf("failed to create user: username %q already exists", username)
+		}
+		if user.Email == email {
+			return "", fmt.Errorf("failed to create user: email %q already exists", email)
+		}
+	}
+
+	s.nextUser++
+	s.users[s.nextUser] = &User{
+		ID:             s.nextUser,
+		Username:       username,
+		PasswordHash:   passwordHash,
+		Email:          email,
+		DateRegistered: time.Now().UTC(),
+	}
+
+	return password, nil
+}
+
+// GetUserByUsername retrieves a copy of a user by username
+func (s *MemoryStore) GetUserByUsername(username string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	user := s.findUser(username)
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	found := *user
+	if user.LastLogin != nil {
+		lastLogin := *user.LastLogin
+		found.LastLogin = &lastLogin
+	}
+	return &found, nil
+}
+
+// VerifyPassword verifies a password against the stored hash
+func (s *MemoryStore) VerifyPassword(username, password string) (bool, error) {
+	user, err := s.GetUserByUsername(username)
+	if err != nil {
+		return false, err
+	}
+
+	return verifyPasswordHash(user.PasswordHash, password)
+}
+
+// AuthenticateUser checks the credentials and records the login time on success.
+// Unknown users and wrong passwords both return ErrInvalidCredentials.
+func (s *MemoryStore) AuthenticateUser(username, password string) (*User, error) {
+	return authenticateUser(s, username, password)
+}
+
+// UpdateLastLogin sets the last login time for a user
+func (s *MemoryStore) UpdateLastLogin(userID int64, loginTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.LastLogin = &loginTime
+	return nil
+}
+
+// LogAuthRequest records an authentication request
+func (s *MemoryStore) LogAuthRequest(requestIP string, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+
+	// unknown users are still logged, without a user ID
+	var userID *int64
+	if username != "" {
+		if user := s.findUser(username); user != nil {
+			id := user.ID
+			userID = &id
+		}
+	}
+
+	s.nextLog++
+	s.authLogs = append(s.authLogs, &AuthLog{
+		ID:               s.nextLog,
+		RequestIP:        requestIP,
+		RequestTimestamp: time.Now().UTC(),
+		UserID:           userID,
+	})
+	return nil
+}
+
+// GetAuthLogs returns the most recent authentication logs first
+func (s *MemoryStore) GetAuthLogs(limit int) ([]*AuthLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	var logs []*AuthLog
+	for i := len(s.authLogs) - 1; i >= 0; i-- {
+		if limit > 0 && len(logs) == limit {
+			break
+		}
+		entry := *s.authLogs[i]
+		logs = append(logs, &entry)
+	}
+	return logs, nil
+}
+
+// helper - callers hold mu
+func (s *MemoryStore) findUser(username string) *User {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"csce-3550_jwks-srv/internal/crypto"
+)
+
+// ErrMetadataNotFound indicates no metadata entry exists for the key
+var ErrMetadataNotFound = fmt.Errorf("metadata not found")
+
+// metadata keys
+const (
+	metadataKDF  = "kdf"  // master key derivation settings
+	metadataJWKS = "jwks" // last published JWKS, served while sealed
+)
+
+// GetMetadata returns the value stored under key
+func (db *Database) GetMetadata(key string) (string, error) {
+	var value string
+	err := db.conn.QueryRow("SELECT value FROM metadata WHERE key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrMetadataNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read metadata %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// SetMetadata stores value under key, replacing any previous value
+func (db *Database) SetMetadata(key, value string) error {
+	if _, err := db.conn.Exec("INSERT OR REPLACE INTO metadata (key, value) VALUES (?, ?)", key, value); err != nil {
+		return fmt.Errorf("failed to write metadata %s: %w", key, err)
+	}
+	return nil
+}
+
+// storedKDFParams is the metadata encoding of crypto.KDFParams
+type storedKDFParams struct {
+	Algorithm string `json:"alg"`
+	Salt      []byte `json:"salt"` // base64 in JSON
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+}
+
+// KDFParams returns the master key derivation settings for this database,
+// generating a fresh salt with the default cost the first time it's opened
+func (db *Database) KDFParams() (crypto.KDFParams, error) {
+	fresh, err := crypto.NewKDFParams()
+	if err != nil {
+		return crypto.KDFParams{}, err
+	}
+	encoded, err := json.Marshal(storedKDFParams{
+		Algorithm: crypto.KDFArgon2id,
+		Salt:      fresh.Salt,
+		Time:      fresh.Time,
+		Memory:    fresh.Memory,
+		Threads:   fresh.Threads,
+	})
+	if err != nil {
+		return crypto.KDFParams{}, fmt.Errorf("failed to encode KDF parameters: %w", err)
+	}
+
+	// first writer wins - a concurrent opener reads back the same salt
+	if _, err := db.conn.Exec("INSERT OR IGNORE INTO metadata (key, value) VALUES (?, ?)", metadataKDF, string(encoded)); err != nil {
+		return crypto.KDFParams{}, fmt.Errorf("failed to store KDF parameters: %w", err)
+	}
+
+	value, err := db.GetMetadata(metadataKDF)
+	if err != nil {
+		return crypto.KDFParams{}, err
+	}
+
+	var stored storedKDFParams
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return crypto.KDFParams{}, fmt.Errorf("failed to decode KDF parameters: %w", err)
+	}
+	if stored.Algorithm != crypto.KDFArgon2id {
+		return crypto.KDFParams{}, fmt.Errorf("unsupported KDF %q", stored.Algorithm)
+	}
+
+	params := crypto.KDFParams{
+		Salt:    stored.Salt,
+		Time:    stored.Time,
+		Memory:  stored.Memory,
+		Threads: stored.Threads,
+	}
+	if err := params.Validate(); err != nil {
+		return crypto.KDFParams{}, err
+	}
+	return params, nil
+}
+
+// SaveJWKSCache keeps a plaintext copy of the published JWKS - public keys only,
+// so it can be served before the master key is available
+func (m *Manager) SaveJWKSCache(body []byte) error {
+	return m.database.SetMetadata(metadataJWKS, string(body))
+}
+
+// ReadJWKSCache returns the JWKS last saved to the database at path without
+// opening its keys. ErrMetadataNotFound when nothing has been saved yet.
+func ReadJWKSCache(path string) ([]byte, error) {
+	database, err := OpenDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	defer database.Close()
+
+	value, err := database.GetMetadata(metadataJWKS)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"csce-3550_jwks-srv/internal/crypto"
+)
+
+func TestMetadata(t *testing.T) {
+	database := openRaw(t)
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	if _, err := database.GetMetadata("missing"); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("GetMetadata() error = %v, want ErrMetadataNotFound", err)
+	}
+
+	for _, value := range []string{"first", "second"} {
+		if err := database.SetMetadata("setting", value); err != nil {
+			t.Fatalf("SetMetadata() error = %v", err)
+		}
+		got, err := database.GetMetadata("setting")
+		if err != nil {
+			t.Fatalf("GetMetadata() error = %v", err)
+		}
+		if got != value {
+			t.Errorf("GetMetadata() = %q, want %q", got, value)
+		}
+	}
+}
+
+func TestKDFParamsPersistPerDatabase(t *testing.T) {
+	database := openRaw(t)
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	first, err := database.KDFParams()
+	if err != nil {
+		t.Fatalf("KDFParams() error = %v", err)
+	}
+	if first.Time != crypto.DefaultKDFParams.Time || first.Memory != crypto.DefaultKDFParams.Memory {
+		t.Errorf("Expected default KDF cost, got %+v", first)
+	}
+
+	// reopening keeps the salt
+	second, err := database.KDFParams()
+	if err != nil {
+		t.Fatalf("KDFParams() error = %v", err)
+	}
+	if !bytes.Equal(first.Salt, second.Salt) {
+		t.Error("KDF salt changed between opens")
+	}
+
+	// another database gets its own salt
+	other := openRaw(t)
+	if err := other.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	otherParams, err := other.KDFParams()
+	if err != nil {
+		t.Fatalf("KDFParams() error = %v", err)
+	}
+	if bytes.Equal(first.Salt, otherParams.Salt) {
+		t.Error("Expected a different salt per database")
+	}
+
+	// stored parameters are validated, not trusted
+	if err := database.SetMetadata(metadataKDF, `{"alg":"argon2id","salt":"c2FsdA==","time":0,"memory":1,"threads":0}`); err != nil {
+		t.Fatalf("SetMetadata() error = %v", err)
+	}
+	if _, err := database.KDFParams(); !errors.Is(err, crypto.ErrInvalidKDFParams) {
+		t.Errorf("KDFParams() error = %v, want ErrInvalidKDFParams", err)
+	}
+}
+
+func TestManagerUpgradesLegacyKDF(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy_kdf.db")
+
+	manager, err := NewManager(dbPath, "master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	// a row written before the KDF existed - sealed with SHA-256(passphrase)
+	legacy, err := crypto.NewEncryptor("master-key")
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	privateKey, _ := generateRSAKey(2048)
+	pemData, err := marshalPrivateKeyPEM(privateKey)
+	if err != nil {
+		t.Fatalf("marshalPrivateKeyPEM() error = %v", err)
+	}
+	encrypted, err := legacy.Encrypt(pemData)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	result, err := manager.database.conn.Exec("INSERT INTO keys (key, exp) VALUES (?, ?)", encrypted, time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatalf("Failed to insert legacy key: %v", err)
+	}
+	kid, _ := result.LastInsertId()
+	manager.Close()
+
+	// the next open upgrades it
+	manager, err = NewManager(dbPath, "master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer manager.Close()
+
+	blob := rawKeyBlobs(t, manager)[int(kid)]
+	if bytes.Equal(blob, encrypted) {
+		t.Fatal("Legacy key was not re-encrypted on open")
+	}
+	if manager.encryptor.UsesLegacyKDF(blob) || manager.encryptor.NeedsReencrypt(blob) {
+		t.Error("Upgraded key is not under the Argon2id master key")
+	}
+	if _, err := legacy.Decrypt(blob); err == nil {
+		t.Error("Upgraded key still opens with the SHA-256 key")
+	}
+
+	validKeys, err := manager.GetValidKeys()
+	if err != nil {
+		t.Fatalf("GetValidKeys() error = %v", err)
+	}
+	if got := storedPrivateKey(validKeys, int(kid)); got == nil || !privateKey.Equal(got) {
+		t.Error("Upgraded key did not round trip")
+	}
+}
+
+func TestJWKSCache(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "jwks_cache.db")
+
+	manager, err := NewManager(dbPath, "master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	if _, err := ReadJWKSCache(dbPath); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("ReadJWKSCache() error = %v, want ErrMetadataNotFound", err)
+	}
+
+	body := []byte(`{"keys":[]}` + "\n")
+	if err := manager.SaveJWKSCache(body); err != nil {
+		t.Fatalf("SaveJWKSCache() error = %v", err)
+	}
+	manager.Close()
+
+	// readable without the master key
+	cached, err := ReadJWKSCache(dbPath)
+	if err != nil {
+		t.Fatalf("ReadJWKSCache() error = %v", err)
+	}
+	if !bytes.Equal(cached, body) {
+		t.Errorf("ReadJWKSCache() = %q, want %q", cached, body)
+	}
+
+	if _, err := ReadJWKSCache(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("Expected error for a missing database")
+	}
+}
+
+func TestVerifyMasterKey(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "verify.db")
+
+	manager, err := NewManager(dbPath, "master-key")
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	// an empty database accepts any key
+	if err := manager.VerifyMasterKey(); err != nil {
+		t.Errorf("VerifyMasterKey() on empty database error = %v", err)
+	}
+
+	privateKey, _ := generateRSAKey(2048)
+	if _, err := manager.StoreKey(privateKey, "RS256", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
+	}
+	manager.Close()
+
+	for _, tt := range []struct {
+		key     string
+		wantErr error
+	}{
+		{"master-key", nil},
+		{"wrong-key", ErrWrongMasterKey},
+	} {
+		manager, err := NewManager(dbPath, tt.key)
+		if err != nil {
+			t.Fatalf("NewManager(%q) error = %v", tt.key, err)
+		}
+		if err := manager.VerifyMasterKey(); !errors.Is(err, tt.wantErr) {
+			t.Errorf("VerifyMasterKey() with %q error = %v, want %v", tt.key, err, tt.wantErr)
+		}
+		manager.Close()
+	}
+}
@@ -0,0 +1,342 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migration files are named <version>_<name>.up.sql / <version>_<name>.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaNewer indicates the database was migrated by a newer binary
+var ErrSchemaNewer = errors.New("database schema is newer than this binary")
+
+// Migration is one versioned schema step
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // empty when the step can't be reverted
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations returns the embedded migrations in version order
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		file := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql", file)
+		}
+
+		base := strings.TrimSuffix(file, "."+direction+".sql")
+		versionPart, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected <version>_<name>", file)
+		}
+		version, err := strconv.Atoi(versionPart)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", file, versionPart)
+		}
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration version %d used by %q and %q", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up step", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Migrate applies every pending migration, each in its own transaction.
+// It refuses to touch a database already migrated past this binary.
+func (db *Database) Migrate() error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		return nil
+	}
+	return db.MigrateTo(migrations[len(migrations)-1].Version)
+}
+
+// MigrateTo moves the schema up or down to target - 0 reverts everything
+func (db *Database) MigrateTo(target int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	if err := db.ensureMigrationsTable(); err != nil {
+		return err
+	}
+
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("%w: database at version %d, binary knows up to %d", ErrSchemaNewer, version, latest)
+		}
+	}
+	if target > latest {
+		return fmt.Errorf("unknown migration version %d, latest is %d", target, latest)
+	}
+
+	// up in ascending order
+	for _, migration := range migrations {
+		if migration.Version > target || applied[migration.Version] {
+			continue
+		}
+		if err := db.applyMigration(migration.Version, migration.Name, migration.Up, true); err != nil {
+			return err
+		}
+	}
+
+	// down in descending order
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version <= target || !applied[migration.Version] {
+			continue
+		}
+		if migration.Down == "" {
+			return fmt.Errorf("migration %d_%s cannot be reverted", migration.Version, migration.Name)
+		}
+		if err := db.applyMigration(migration.Version, migration.Name, migration.Down, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration, 0 for an unmigrated database
+func (db *Database) SchemaVersion() (int, error) {
+	if err := db.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	if err := db.conn.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// MigrationStatus lists every known migration and whether it is applied
+func (db *Database) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query("SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	recorded := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt int64
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		status.Applied = true
+		status.AppliedAt = time.Unix(appliedAt, 0)
+		recorded[status.Version] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status, ok := recorded[migration.Version]
+		if !ok {
+			status = MigrationStatus{Version: migration.Version, Name: migration.Name}
+		}
+		delete(recorded, migration.Version)
+		statuses = append(statuses, status)
+	}
+
+	// versions applied by a newer binary are reported too
+	for _, status := range recorded {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// create the bookkeeping table - databases from before migrations existed are adopted first
+func (db *Database) ensureMigrationsTable() error {
+	var exists int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	if err := db.adoptLegacySchema(); err != nil {
+		return err
+	}
+
+	_, err = db.conn.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations(
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// adoptLegacySchema brings a keys table created before migrations up to the
+// layout of the initial migration, whose CREATE TABLE IF NOT EXISTS then no-ops
+func (db *Database) adoptLegacySchema() error {
+	var exists int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'keys'").Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	if exists == 0 {
+		return nil
+	}
+
+	legacyKeyColumns := []struct{ name, definition string }{
+		{"alg", "TEXT NOT NULL DEFAULT ''"},
+		{"status", "TEXT NOT NULL DEFAULT 'active'"},
+		{"activate_at", "INTEGER NOT NULL DEFAULT 0"},
+		{"retired_at", "INTEGER NOT NULL DEFAULT 0"},
+		{"created_at", "INTEGER NOT NULL DEFAULT 0"},
+		{"key_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"usage", "TEXT NOT NULL DEFAULT 'sig'"},
+		{"origin", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, column := range legacyKeyColumns {
+		if err := db.addColumnIfMissing("keys", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helper - applied versions
+func (db *Database) appliedMigrations() (map[int]bool, error) {
+	rows, err := db.conn.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// run one step and record it atomically - a failed step leaves no trace
+func (db *Database) applyMigration(version int, name, script string, up bool) error {
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d_%s: %w", version, name, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %d_%s %s failed: %w", version, name, direction, err)
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", version, name, time.Now().Unix())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %w", version, name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d_%s: %w", version, name, err)
+	}
+	return nil
+}
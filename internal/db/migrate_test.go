@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// openRaw opens a database file without running migrations
+func openRaw(t *testing.T) *Database {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "migrate.db")
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	db := &Database{conn: conn, path: dbPath}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *Database, table string) bool {
+	t.Helper()
+	var count int
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	if err != nil {
+		t.Fatalf("sqlite_master query error = %v", err)
+	}
+	return count > 0
+}
+
+func TestMigrationsEmbedded(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+
+	for i, migration := range migrations {
+		if migration.Up == "" {
+			t.Errorf("Migration %d has no up step", migration.Version)
+		}
+		if i > 0 && migration.Version <= migrations[i-1].Version {
+			t.Errorf("Migrations out of order: %d after %d", migration.Version, migrations[i-1].Version)
+		}
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := openRaw(t)
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	// applying again is a no-op
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("second Migrate() error = %v", err)
+	}
+
+	for _, table := range []string{"keys", "key_purges", "users", "auth_logs", "metadata", "schema_migrations"} {
+		if !tableExists(t, db, table) {
+			t.Errorf("Expected table %s after migrating", table)
+		}
+	}
+
+	migrations, _ := Migrations()
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion() error = %v", err)
+	}
+	if latest := migrations[len(migrations)-1].Version; version != latest {
+		t.Errorf("Expected schema version %d, got %d", latest, version)
+	}
+
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("Expected %d statuses, got %d", len(migrations), len(statuses))
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt.IsZero() {
+			t.Errorf("Expected migration %d applied with a timestamp, got %+v", status.Version, status)
+		}
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openRaw(t)
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if err := db.MigrateTo(0); err != nil {
+		t.Fatalf("MigrateTo(0) error = %v", err)
+	}
+
+	if tableExists(t, db, "keys") {
+		t.Error("Expected keys table to be dropped by down migration")
+	}
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("Expected migration %d pending after down, got applied", status.Version)
+		}
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate() after down error = %v", err)
+	}
+	if !tableExists(t, db, "keys") {
+		t.Error("Expected keys table after migrating back up")
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	db := openRaw(t)
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	// simulate a newer binary having migrated this database
+	if _, err := db.conn.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', 0)"); err != nil {
+		t.Fatalf("insert error = %v", err)
+	}
+
+	if err := db.Migrate(); !errors.Is(err, ErrSchemaNewer) {
+		t.Errorf("Expected ErrSchemaNewer, got %v", err)
+	}
+
+	// status still reports the unknown version
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	if last := statuses[len(statuses)-1]; last.Version != 9999 || !last.Applied {
+		t.Errorf("Expected future migration in status, got %+v", last)
+	}
+}
+
+func TestMigrateFailedStepRollsBack(t *testing.T) {
+	db := openRaw(t)
+
+	if err := db.ensureMigrationsTable(); err != nil {
+		t.Fatalf("ensureMigrationsTable() error = %v", err)
+	}
+
+	err := db.applyMigration(1, "broken", "CREATE TABLE partial(id INTEGER); NOT VALID SQL;", true)
+	if err == nil {
+		t.Fatal("Expected error from invalid migration")
+	}
+
+	if tableExists(t, db, "partial") {
+		t.Error("Expected failed migration to roll back its changes")
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion() error = %v", err)
+	}
+	if version != 0 {
+		t.Errorf("Expected failed migration not recorded, got version %d", version)
+	}
+}
+
+func TestMigrateAdoptsLegacySchema(t *testing.T) {
+	db := openRaw(t)
+
+	// keys table as created before migrations existed
+	if _, err := db.conn.Exec("CREATE TABLE keys(kid INTEGER PRIMARY KEY AUTOINCREMENT, key BLOB NOT NULL, exp INTEGER NOT NULL)"); err != nil {
+		t.Fatalf("create legacy table error = %v", err)
+	}
+	if _, err := db.conn.Exec("INSERT INTO keys (key, exp) VALUES (?, ?)", []byte("legacy"), 1); err != nil {
+		t.Fatalf("insert legacy row error = %v", err)
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	var status, usage string
+	if err := db.conn.QueryRow("SELECT status, usage FROM keys").Scan(&status, &usage); err != nil {
+		t.Fatalf("Expected legacy row to gain metadata columns: %v", err)
+	}
+	if status != KeyStatusActive || usage != "sig" {
+		t.Errorf("Expected defaults active/sig, got %s/%s", status, usage)
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS auth_logs;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS key_purges;
+DROP TABLE IF EXISTS keys;
@@ -0,0 +1,42 @@
+-- signing keys, encrypted PKCS8 PEM with lifecycle metadata
+CREATE TABLE IF NOT EXISTS keys(
+	kid INTEGER PRIMARY KEY AUTOINCREMENT,
+	key BLOB NOT NULL,
+	exp INTEGER NOT NULL,
+	alg TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'active',
+	activate_at INTEGER NOT NULL DEFAULT 0,
+	retired_at INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL DEFAULT 0,
+	key_size INTEGER NOT NULL DEFAULT 0,
+	usage TEXT NOT NULL DEFAULT 'sig',
+	origin TEXT NOT NULL DEFAULT ''
+);
+
+-- audit trail of keys removed by retention
+CREATE TABLE IF NOT EXISTS key_purges(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kid INTEGER NOT NULL,
+	alg TEXT NOT NULL DEFAULT '',
+	exp INTEGER NOT NULL,
+	purged_at INTEGER NOT NULL
+);
+
+-- registered users
+CREATE TABLE IF NOT EXISTS users(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	email TEXT UNIQUE,
+	date_registered TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_login TIMESTAMP
+);
+
+-- authentication requests
+CREATE TABLE IF NOT EXISTS auth_logs(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	request_ip TEXT NOT NULL,
+	request_timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	user_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
@@ -0,0 +1,5 @@
+-- per-database settings, e.g. the master key KDF salt and cost
+CREATE TABLE IF NOT EXISTS metadata(
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
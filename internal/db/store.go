@@ -0,0 +1,62 @@
+package db
+
+import (
+	stdcrypto "crypto"
+	"time"
+)
+
+// KeyStore persists signing keys and their lifecycle state
+type KeyStore interface {
+	StoreKey(privateKey stdcrypto.Signer, alg string, expiry time.Time) (int, error)
+	StorePendingKey(privateKey stdcrypto.Signer, alg string, activateAt, expiry time.Time) (int, error)
+	StoreKeyWithMetadata(privateKey stdcrypto.Signer, meta KeyMetadata) (int, error)
+	GetValidKeys() (map[int]*StoredKey, error)
+	GetExpiredKeys() (map[int]*StoredKey, error)
+	GetActiveKey() (*StoredKey, error)
+	GetLatestExpiredKey() (*StoredKey, error)
+	AdvanceKeyStates(now time.Time, retireFor time.Duration) error
+	PurgeExpiredKeys(cutoff time.Time, dryRun bool) ([]PurgedKey, error)
+}
+
+// UserStore persists registered users
+type UserStore interface {
+	CreateUser(username, email string) (string, error)
+	GetUserByUsername(username string) (*User, error)
+	VerifyPassword(username, password string) (bool, error)
+	AuthenticateUser(username, password string) (*User, error)
+	UpdateLastLogin(userID int64, loginTime time.Time) error
+}
+
+// AuthLogStore records authentication requests
+type AuthLogStore interface {
+	LogAuthRequest(requestIP string, username string) error
+	GetAuthLogs(limit int) ([]*AuthLog, error)
+}
+
+// Store is everything the server persists - Manager is the encrypted SQLite
+// implementation, MemoryStore the pure-Go one that needs neither CGO nor disk
+type Store interface {
+	KeyStore
+	UserStore
+	AuthLogStore
+	Close() error
+}
+
+// JWKSCache is implemented by stores that keep the published JWKS readable
+// without the master key, for serving while the server is sealed
+type JWKSCache interface {
+	SaveJWKSCache(body []byte) error
+}
+
+// storage backends selectable at startup
+const (
+	BackendSQLite = "sqlite"
+	BackendMemory = "memory"
+)
+
+var (
+	_ Store = (*Manager)(nil)
+	_ Store = (*MemoryStore)(nil)
+
+	_ JWKSCache = (*Manager)(nil)
+)
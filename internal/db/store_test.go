@@ -0,0 +1,183 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storeBackends opens a fresh, empty instance of every Store implementation
+var storeBackends = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{BackendSQLite, func(t *testing.T) Store {
+		manager, err := NewManager(filepath.Join(t.TempDir(), "store.db"), "test-encryption-key-123")
+		if err != nil {
+			t.Fatalf("NewManager() error = %v", err)
+		}
+		return manager
+	}},
+	{BackendMemory, func(t *testing.T) Store {
+		return NewMemoryStore()
+	}},
+}
+
+// forEachStore runs test as a subtest against every backend
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Helper()
+	for _, backend := range storeBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			defer store.Close()
+			test(t, store)
+		})
+	}
+}
+
+// keyStatus reads a key's lifecycle state straight from the backend, purged keys included
+func keyStatus(t *testing.T, store Store, kid int) string {
+	t.Helper()
+	switch s := store.(type) {
+	case *Manager:
+		var status string
+		if err := s.database.conn.QueryRow("SELECT status FROM keys WHERE kid = ?", kid).Scan(&status); err != nil {
+			t.Fatalf("status query error = %v", err)
+		}
+		return status
+	case *MemoryStore:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		key, ok := s.keys[kid]
+		if !ok {
+			t.Fatalf("key %d not stored", kid)
+		}
+		return key.status
+	default:
+		t.Fatalf("unknown store %T", store)
+		return ""
+	}
+}
+
+// keyCount counts stored keys in any state
+func keyCount(t *testing.T, store Store) int {
+	t.Helper()
+	switch s := store.(type) {
+	case *Manager:
+		var n int
+		if err := s.database.conn.QueryRow("SELECT COUNT(*) FROM keys").Scan(&n); err != nil {
+			t.Fatalf("count query error = %v", err)
+		}
+		return n
+	case *MemoryStore:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.keys)
+	default:
+		t.Fatalf("unknown store %T", store)
+		return 0
+	}
+}
+
+// purgeAudit returns the recorded purges in order
+func purgeAudit(t *testing.T, store Store) []PurgedKey {
+	t.Helper()
+	switch s := store.(type) {
+	case *Manager:
+		rows, err := s.database.conn.Query("SELECT kid, alg, exp FROM key_purges ORDER BY id")
+		if err != nil {
+			t.Fatalf("audit query error = %v", err)
+		}
+		defer rows.Close()
+
+		var audit []PurgedKey
+		for rows.Next() {
+			var key PurgedKey
+			var exp int64
+			if err := rows.Scan(&key.Kid, &key.Algorithm, &exp); err != nil {
+				t.Fatalf("audit scan error = %v", err)
+			}
+			key.ExpiresAt = time.Unix(exp, 0)
+			audit = append(audit, key)
+		}
+		return audit
+	case *MemoryStore:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var audit []PurgedKey
+		for _, purge := range s.purges {
+			audit = append(audit, purge.PurgedKey)
+		}
+		return audit
+	default:
+		t.Fatalf("unknown store %T", store)
+		return nil
+	}
+}
+
+func TestStoreRejectsDuplicateUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if _, err := store.CreateUser("dupe", "dupe@example.com"); err != nil {
+			t.Fatalf("CreateUser() error = %v", err)
+		}
+
+		if _, err := store.CreateUser("dupe", "other@example.com"); err == nil {
+			t.Error("Expected duplicate username to be rejected")
+		}
+		if _, err := store.CreateUser("other", "dupe@example.com"); err == nil {
+			t.Error("Expected duplicate email to be rejected")
+		}
+	})
+}
+
+func TestStoreAuthLogUserID(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		if _, err := store.CreateUser("logged", "logged@example.com"); err != nil {
+			t.Fatalf("CreateUser() error = %v", err)
+		}
+		user, err := store.GetUserByUsername("logged")
+		if err != nil {
+			t.Fatalf("GetUserByUsername() error = %v", err)
+		}
+
+		if err := store.LogAuthRequest("10.0.0.1", "logged"); err != nil {
+			t.Fatalf("LogAuthRequest() error = %v", err)
+		}
+		if err := store.LogAuthRequest("10.0.0.2", "nonexistent"); err != nil {
+			t.Fatalf("LogAuthRequest() error = %v", err)
+		}
+
+		logs, err := store.GetAuthLogs(0)
+		if err != nil {
+			t.Fatalf("GetAuthLogs() error = %v", err)
+		}
+		if len(logs) != 2 {
+			t.Fatalf("Expected 2 logs, got %d", len(logs))
+		}
+
+		for _, entry := range logs {
+			switch entry.RequestIP {
+			case "10.0.0.1":
+				if entry.UserID == nil || *entry.UserID != user.ID {
+					t.Errorf("Expected user_id %d, got %v", user.ID, entry.UserID)
+				}
+			case "10.0.0.2":
+				if entry.UserID != nil {
+					t.Errorf("Expected no user_id for unknown user, got %d", *entry.UserID)
+				}
+			}
+		}
+	})
+}
+
+func TestMemoryStoreClosed(t *testing.T) {
+	store := NewMemoryStore()
+	store.Close()
+
+	if _, err := store.CreateUser("late", "late@example.com"); err == nil {
+		t.Error("Expected CreateUser() to fail on a closed store")
+	}
+	if err := store.LogAuthRequest("10.0.0.1", ""); err == nil {
+		t.Error("Expected LogAuthRequest() to fail on a closed store")
+	}
+}
@@ -1,8 +1,10 @@
 package db
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestUsersTableCreation(t *testing.T) {
@@ -147,86 +149,144 @@ func TestCreateUser(t *testing.T) {
 }
 
 func TestVerifyPassword(t *testing.T) {
-	// Create test database
-	db, _ := testDatabase(t)
-	defer db.Close()
+	forEachStore(t, func(t *testing.T, store Store) {
+		// Create test user
+		username := "verifyuser"
+		email := "verify@example.com"
 
-	// Create test user
-	username := "verifyuser"
-	email := "verify@example.com"
-
-	password, err := db.CreateUser(username, email)
-	if err != nil {
-		t.Fatalf("Failed to create user: %v", err)
-	}
+		password, err := store.CreateUser(username, email)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
 
-	// Test correct password verification
-	valid, err := db.VerifyPassword(username, password)
-	if err != nil {
-		t.Fatalf("Failed to verify password: %v", err)
-	}
+		// Test correct password verification
+		valid, err := store.VerifyPassword(username, password)
+		if err != nil {
+			t.Fatalf("Failed to verify password: %v", err)
+		}
 
-	if !valid {
-		t.Error("Expected password to be valid")
-	}
+		if !valid {
+			t.Error("Expected password to be valid")
+		}
 
-	// Test incorrect password verification
-	valid, err = db.VerifyPassword(username, "wrongpassword")
-	if err != nil {
-		t.Fatalf("Failed to verify wrong password: %v", err)
-	}
+		// Test incorrect password verification
+		valid, err = store.VerifyPassword(username, "wrongpassword")
+		if err != nil {
+			t.Fatalf("Failed to verify wrong password: %v", err)
+		}
 
-	if valid {
-		t.Error("Expected wrong password to be invalid")
-	}
+		if valid {
+			t.Error("Expected wrong password to be invalid")
+		}
 
-	// Test non-existent user
-	_, err = db.VerifyPassword("nonexistent", password)
-	if err == nil {
-		t.Error("Expected error for non-existent user")
-	}
+		// Test non-existent user
+		_, err = store.VerifyPassword("nonexistent", password)
+		if err == nil {
+			t.Error("Expected error for non-existent user")
+		}
+	})
 }
 
 func TestGetUserByUsername(t *testing.T) {
-	// Create test database
-	db, _ := testDatabase(t)
-	defer db.Close()
+	forEachStore(t, func(t *testing.T, store Store) {
+		// Create test user
+		username := "getuser"
+		email := "get@example.com"
 
-	// Create test user
-	username := "getuser"
-	email := "get@example.com"
+		_, err := store.CreateUser(username, email)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
 
-	_, err := db.CreateUser(username, email)
-	if err != nil {
-		t.Fatalf("Failed to create user: %v", err)
-	}
+		// Get user by username
+		user, err := store.GetUserByUsername(username)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
 
-	// Get user by username
-	user, err := db.GetUserByUsername(username)
-	if err != nil {
-		t.Fatalf("Failed to get user: %v", err)
-	}
+		// Verify user data
+		if user.Username != username {
+			t.Errorf("Expected username %s, got %s", username, user.Username)
+		}
 
-	// Verify user data
-	if user.Username != username {
-		t.Errorf("Expected username %s, got %s", username, user.Username)
-	}
+		if user.Email != email {
+			t.Errorf("Expected email %s, got %s", email, user.Email)
+		}
 
-	if user.Email != email {
-		t.Errorf("Expected email %s, got %s", email, user.Email)
-	}
+		if user.ID == 0 {
+			t.Error("Expected non-zero user ID")
+		}
 
-	if user.ID == 0 {
-		t.Error("Expected non-zero user ID")
-	}
+		if user.PasswordHash == "" {
+			t.Error("Expected non-empty password hash")
+		}
 
-	if user.PasswordHash == "" {
-		t.Error("Expected non-empty password hash")
-	}
+		// Test non-existent user
+		_, err = store.GetUserByUsername("nonexistent")
+		if err == nil {
+			t.Error("Expected error for non-existent user")
+		}
+	})
+}
 
-	// Test non-existent user
-	_, err = db.GetUserByUsername("nonexistent")
-	if err == nil {
-		t.Error("Expected error for non-existent user")
-	}
+func TestAuthenticateUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		username := "authuser"
+		password, err := store.CreateUser(username, "auth@example.com")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		// Test correct credentials
+		user, err := store.AuthenticateUser(username, password)
+		if err != nil {
+			t.Fatalf("AuthenticateUser() error = %v", err)
+		}
+
+		if user.Username != username {
+			t.Errorf("Expected username %s, got %s", username, user.Username)
+		}
+
+		// last_login should now be persisted
+		stored, err := store.GetUserByUsername(username)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		if stored.LastLogin == nil {
+			t.Fatal("Expected last_login to be set after authentication")
+		}
+
+		if time.Since(*stored.LastLogin) > time.Minute {
+			t.Errorf("Expected recent last_login, got %v", *stored.LastLogin)
+		}
+
+		// Test wrong password and unknown user
+		tests := []struct {
+			name     string
+			username string
+			password string
+		}{
+			{"wrong password", username, "wrongpassword"},
+			{"unknown user", "nonexistent", password},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := store.AuthenticateUser(tt.username, tt.password)
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+				}
+			})
+		}
+	})
+}
+
+func TestUpdateLastLoginUnknownUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		err := store.UpdateLastLogin(9999, time.Now())
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
 }
@@ -1,83 +1,594 @@
 package httpserver
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"csce-3550_jwks-srv/internal/db"
+	"csce-3550_jwks-srv/internal/keys"
 )
 
 const (
-	defaultIssuer      = "jwks-server"
-	defaultJWTLifetime = "5m"
-	defaultKeyRetain   = "1h"
-	defaultKeyLifetime = "10m"
+	defaultIssuer          = "jwks-server"
+	defaultSigningAlg      = "RS256"
+	defaultJWTLifetime     = "5m"
+	defaultKeyRetain       = "1h"
+	defaultKeyLifetime     = "10m"
+	defaultKeyLeadTime     = "1m"
+	defaultKeyCleanup      = "1h"
+	defaultListenAddr      = ":8080"
+	defaultReadTimeout     = 15 * time.Second
+	defaultWriteTimeout    = 15 * time.Second
+	defaultIdleTimeout     = 60 * time.Second
+	defaultShutdownTimeout = 10 * time.Second
+	defaultRateLimit       = 10 // requests per second per client
+	defaultAuthRateLimit   = 10 // requests per second per client on /auth
+	defaultTLSMinVersion   = tls.VersionTLS12
 )
 
 type Config struct {
-	KeyLifetime     time.Duration
-	KeyRetainPeriod time.Duration
-	JWTLifetime     time.Duration
-	Issuer          string
-	EncryptionKey   string `json:"-"` // Never serialize this field
+	KeyLifetime      time.Duration
+	KeyRetainPeriod  time.Duration
+	KeyLeadTime      time.Duration
+	KeyCleanup       time.Duration // how often keys past KeyRetainPeriod are purged
+	KeyPurgeDryRun   bool          // log purges without deleting
+	JWTLifetime      time.Duration
+	Issuer           string
+	SigningAlgorithm string
+	RSAKeySize       int
+	StorageBackend   string // db.BackendSQLite or db.BackendMemory
+	DatabasePath     string // SQLite file, or db.MemoryPath for an ephemeral store
+	EncryptionKey    string `json:"-"` // Never serialize this field
+
+	// HTTP listener - zero values fall back to the defaults
+	ListenAddr      string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration // grace period for in-flight requests
+	RateLimit       int           // requests per second per client
+	AuthRateLimit   int           // requests per second per client on /auth and /admin/unseal
+	CORSOrigins     []string      // origins allowed cross-origin requests, "*" for any - nil means any
+	LogLevel        slog.Level    // request log lines below it are dropped - the zero value is info
+	AdminToken      string        `json:"-"` // bearer token for /admin/reload, unset disables it
+
+	// native TLS - HTTPS is served when TLSCertFile is set
+	TLSCertFile     string             // PEM certificate chain, re-read when it changes on disk
+	TLSKeyFile      string             // PEM private key for TLSCertFile
+	TLSMinVersion   uint16             // tls.VersionTLS12 or tls.VersionTLS13 - zero means 1.2
+	TLSCipherSuites []uint16           // TLS 1.2 suites, nil for Go's secure defaults
+	TLSClientCAFile string             // PEM bundle client certificates are verified against
+	TLSClientAuth   tls.ClientAuthType // with TLSClientCAFile: require or verify-if-given
+	TLSRedirectAddr string             // plain HTTP listener redirecting to HTTPS, empty for none
+
+	// retired master keys - decrypt only, kept until `jwks-srv rekey` has run
+	PreviousEncryptionKeys []string `json:"-"`
+
+	// where the key-encryption key that wraps each private key's data key comes from
+	KEKProvider      string   // KEKProviderEnv, KEKProviderFile or KEKProviderKMS
+	KEKFile          string   // KEKProviderFile: hex or base64 key file
+	KEKPreviousFiles []string // retired key files - unwrap only
+	KEKURL           string   // KEKProviderKMS: base URL of the local KMS protocol
+	KEKKeyID         string   // KEKProviderKMS: key name at the KMS
+	KEKToken         string   `json:"-"` // KEKProviderKMS: optional bearer token
+
+	// sealed startup - NOT_MY_KEY arrives through POST /admin/unseal instead of the environment
+	Sealed          bool   // start sealed: health and cached JWKS only until unsealed
+	UnsealToken     string `json:"-"` // bearer token for /admin/unseal
+	UnsealThreshold int    // Shamir shares needed to unseal, 1 to submit NOT_MY_KEY whole
+
+	// where LoadConfig found each value - ReloadConfig reads the same sources again
+	sources ConfigSources
+	values  map[string]resolvedValue
 }
 
+// key-encryption key providers
+const (
+	KEKProviderEnv  = "env"  // derived from NOT_MY_KEY
+	KEKProviderFile = "file" // KEK_FILE on disk
+	KEKProviderKMS  = "kms"  // remote KMS at KEK_URL
+)
+
+// setting is one configuration value - a config file key, an env var and, unless
+// it's a secret, a command-line flag. Secrets stay out of the process list.
+type setting struct {
+	key    string // config file key
+	env    string
+	flag   string // "" for secrets
+	def    string
+	secret bool
+	live   bool // ReloadConfig applies changes without a restart
+	toggle bool // a live setting that still needs a restart to go from unset to set or back
+	usage  string
+}
+
+// every setting, in `config print` order
+var settings = []setting{
+	{key: "listen_addr", env: "LISTEN_ADDR", flag: "listen-addr", def: defaultListenAddr, usage: "address the HTTP server listens on"},
+	{key: "read_timeout", env: "READ_TIMEOUT", flag: "read-timeout", def: defaultReadTimeout.String(), usage: "maximum time to read a request"},
+	{key: "write_timeout", env: "WRITE_TIMEOUT", flag: "write-timeout", def: defaultWriteTimeout.String(), usage: "maximum time to write a response"},
+	{key: "idle_timeout", env: "IDLE_TIMEOUT", flag: "idle-timeout", def: defaultIdleTimeout.String(), usage: "keep-alive idle timeout"},
+	{key: "shutdown_timeout", env: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", def: defaultShutdownTimeout.String(), usage: "grace period for in-flight requests on shutdown"},
+	{key: "rate_limit", env: "RATE_LIMIT", flag: "rate-limit", def: strconv.Itoa(defaultRateLimit), live: true, usage: "requests per second per client"},
+	{key: "auth_rate_limit", env: "AUTH_RATE_LIMIT", flag: "auth-rate-limit", def: strconv.Itoa(defaultAuthRateLimit), live: true, usage: "requests per second per client on /auth"},
+	{key: "cors_origins", env: "CORS_ORIGINS", flag: "cors-origins", def: "*", live: true, usage: "comma-separated origins allowed cross-origin requests, * for any"},
+	{key: "log_level", env: "LOG_LEVEL", flag: "log-level", def: "info", live: true, usage: "request log level: debug, info, warn or error"},
+	{key: "admin_token", env: "ADMIN_TOKEN", secret: true, live: true},
+	{key: "tls_cert_file", env: "TLS_CERT_FILE", flag: "tls-cert-file", live: true, toggle: true, usage: "PEM certificate chain - serves HTTPS when set"},
+	{key: "tls_key_file", env: "TLS_KEY_FILE", flag: "tls-key-file", live: true, toggle: true, usage: "PEM private key for the TLS certificate"},
+	{key: "tls_min_version", env: "TLS_MIN_VERSION", flag: "tls-min-version", def: "1.2", usage: "minimum TLS version: 1.2 or 1.3"},
+	{key: "tls_cipher_suites", env: "TLS_CIPHER_SUITES", flag: "tls-cipher-suites", usage: "comma-separated TLS 1.2 cipher suites, Go's secure defaults when unset"},
+	{key: "tls_client_ca_file", env: "TLS_CLIENT_CA_FILE", flag: "tls-client-ca-file", usage: "PEM CA bundle to verify client certificates against"},
+	{key: "tls_client_auth", env: "TLS_CLIENT_AUTH", flag: "tls-client-auth", def: "require", usage: "client certificates with a CA bundle: require or verify-if-given"},
+	{key: "tls_redirect_addr", env: "TLS_REDIRECT_ADDR", flag: "tls-redirect-addr", usage: "address of a plain HTTP listener that redirects to HTTPS"},
+	{key: "key_lifetime", env: "KEY_LIFETIME", flag: "key-lifetime", def: defaultKeyLifetime, live: true, usage: "how long a signing key lives"},
+	{key: "key_retain", env: "KEY_RETAIN", flag: "key-retain", def: defaultKeyRetain, live: true, usage: "how long expired keys are kept"},
+	{key: "key_lead_time", env: "KEY_LEAD_TIME", flag: "key-lead-time", def: defaultKeyLeadTime, live: true, usage: "how long new keys are published before they sign"},
+	{key: "key_cleanup_interval", env: "KEY_CLEANUP_INTERVAL", flag: "key-cleanup-interval", def: defaultKeyCleanup, live: true, usage: "how often expired keys are purged"},
+	{key: "key_purge_dry_run", env: "KEY_PURGE_DRY_RUN", flag: "key-purge-dry-run", def: "false", live: true, usage: "log purges without deleting"},
+	{key: "jwt_lifetime", env: "JWT_LIFETIME", flag: "jwt-lifetime", def: defaultJWTLifetime, live: true, usage: "lifetime of issued tokens"},
+	{key: "issuer", env: "ISSUER", flag: "issuer", def: defaultIssuer, live: true, usage: "iss claim of issued tokens"},
+	{key: "signing_alg", env: "SIGNING_ALG", flag: "signing-alg", def: defaultSigningAlg, usage: "algorithm for newly generated keys"},
+	{key: "rsa_key_size", env: "RSA_KEY_SIZE", flag: "rsa-key-size", def: strconv.Itoa(keys.DefaultRSAKeySize), usage: "modulus size for newly generated RSA keys"},
+	{key: "storage_backend", env: "STORAGE_BACKEND", flag: "storage", def: db.BackendSQLite, usage: "storage backend: sqlite or memory"},
+	{key: "db_path", env: "DB_PATH", flag: "db-path", usage: "SQLite database file, or :memory: for an ephemeral store"},
+	{key: "data_dir", env: "DATA_DIR", flag: "data-dir", usage: "directory holding the default database file"},
+	{key: "not_my_key", env: "NOT_MY_KEY", secret: true},
+	{key: "not_my_key_previous", env: "NOT_MY_KEY_PREVIOUS", secret: true},
+	{key: "kek_provider", env: "KEK_PROVIDER", flag: "kek-provider", def: KEKProviderEnv, usage: "key-encryption key source: env, file or kms"},
+	{key: "kek_file", env: "KEK_FILE", flag: "kek-file", usage: "key file for the file KEK provider"},
+	{key: "kek_previous_files", env: "KEK_PREVIOUS_FILES", flag: "kek-previous-files", usage: "comma-separated retired key files"},
+	{key: "kek_url", env: "KEK_URL", flag: "kek-url", usage: "KMS base URL for the kms KEK provider"},
+	{key: "kek_key_id", env: "KEK_KEY_ID", flag: "kek-key-id", usage: "key name at the KMS"},
+	{key: "kek_token", env: "KEK_TOKEN", secret: true},
+	{key: "sealed", env: "SEALED", flag: "sealed", def: "false", usage: "start sealed and wait for /admin/unseal"},
+	{key: "unseal_token", env: "UNSEAL_TOKEN", secret: true},
+	{key: "unseal_threshold", env: "UNSEAL_THRESHOLD", flag: "unseal-threshold", def: "1", usage: "key shares needed to unseal"},
+}
+
+// NewConfig loads the config from CONFIG_FILE (if set) and the environment
 func NewConfig() (*Config, error) {
-	// setup the defaults
-	issuer := defaultIssuer
+	return LoadConfig(ConfigSources{})
+}
 
-	keyLifetime, err := time.ParseDuration(defaultKeyLifetime)
-	if err != nil {
-		return nil, fmt.Errorf("invalid defaultKeyLifetime: %w", err)
-	}
+// LoadConfig builds the config from defaults, the config file, env vars and flags,
+// each layer overriding the one before. Every problem is reported at once in a
+// *ValidationError.
+func LoadConfig(sources ConfigSources) (*Config, error) {
+	p := &configParser{}
 
-	keyRetain, err := time.ParseDuration(defaultKeyRetain)
-	if err != nil {
-		return nil, fmt.Errorf("invalid defaultKeyRetain: %w", err)
+	values, err := sources.resolve()
+	var fileErrs *ValidationError
+	if errors.As(err, &fileErrs) {
+		// unknown keys in the file don't stop the rest being checked
+		p.errs = append(p.errs, fileErrs.Errors...)
+	} else if err != nil {
+		return nil, err
 	}
+	p.values = values
 
-	jwtLifetime, err := time.ParseDuration(defaultJWTLifetime)
-	if err != nil {
-		return nil, fmt.Errorf("invalid defaultJWTLifetime: %w", err)
+	config := &Config{
+		KeyLifetime:      p.duration("key_lifetime"),
+		KeyRetainPeriod:  p.duration("key_retain"),
+		KeyLeadTime:      p.duration("key_lead_time"),
+		KeyCleanup:       p.duration("key_cleanup_interval"),
+		KeyPurgeDryRun:   p.boolean("key_purge_dry_run"),
+		JWTLifetime:      p.duration("jwt_lifetime"),
+		Issuer:           p.str("issuer"),
+		SigningAlgorithm: p.str("signing_alg"),
+		RSAKeySize:       p.integer("rsa_key_size"),
+		StorageBackend:   p.str("storage_backend"),
+		DatabasePath:     db.ResolvePath(p.str("db_path"), p.str("data_dir")),
+		EncryptionKey:    p.str("not_my_key"),
+
+		ListenAddr:      p.str("listen_addr"),
+		ReadTimeout:     p.duration("read_timeout"),
+		WriteTimeout:    p.duration("write_timeout"),
+		IdleTimeout:     p.duration("idle_timeout"),
+		ShutdownTimeout: p.duration("shutdown_timeout"),
+		RateLimit:       p.integer("rate_limit"),
+		AuthRateLimit:   p.integer("auth_rate_limit"),
+		CORSOrigins:     p.list("cors_origins"),
+		LogLevel:        p.logLevel("log_level"),
+		AdminToken:      p.str("admin_token"),
+
+		TLSCertFile:     p.str("tls_cert_file"),
+		TLSKeyFile:      p.str("tls_key_file"),
+		TLSMinVersion:   p.tlsVersion("tls_min_version"),
+		TLSCipherSuites: p.cipherSuites("tls_cipher_suites"),
+		TLSClientCAFile: p.str("tls_client_ca_file"),
+		TLSClientAuth:   p.clientAuth("tls_client_auth"),
+		TLSRedirectAddr: p.str("tls_redirect_addr"),
+
+		PreviousEncryptionKeys: p.list("not_my_key_previous"),
+
+		KEKProvider:      p.str("kek_provider"),
+		KEKFile:          p.str("kek_file"),
+		KEKPreviousFiles: p.list("kek_previous_files"),
+		KEKURL:           p.str("kek_url"),
+		KEKKeyID:         p.str("kek_key_id"),
+		KEKToken:         p.str("kek_token"),
+
+		Sealed:          p.boolean("sealed"),
+		UnsealToken:     p.str("unseal_token"),
+		UnsealThreshold: p.integer("unseal_threshold"),
+
+		sources: sources,
+		values:  values,
 	}
 
-	// override w/ env vars if set and valid
-	overrides := map[string]struct {
-		envKey string
-		target *time.Duration
+	// value checks - only for settings that parsed
+	p.check("issuer", ValidateIssuer)
+	p.check("signing_alg", keys.ValidateAlgorithm)
+	p.check("rsa_key_size", func(string) error { return keys.ValidateRSAKeySize(config.RSAKeySize) })
+	p.check("storage_backend", ValidateStorageBackend)
+	p.check("cors_origins", func(string) error {
+		for _, origin := range config.CORSOrigins {
+			if err := ValidateCORSOrigin(origin); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	p.check("kek_provider", func(provider string) error {
+		switch provider {
+		case KEKProviderEnv, KEKProviderFile, KEKProviderKMS:
+			return nil
+		default:
+			return fmt.Errorf("want %s, %s or %s", KEKProviderEnv, KEKProviderFile, KEKProviderKMS)
+		}
+	})
+	for _, setting := range []struct {
+		key   string
+		value int64
 	}{
-		"keyLifetime": {"KEY_LIFETIME", &keyLifetime},
-		"keyRetain":   {"KEY_RETAIN", &keyRetain},
-		"jwtLifetime": {"JWT_LIFETIME", &jwtLifetime},
-	}
-
-	// duration overrides
-	for name, override := range overrides {
-		if envVal := os.Getenv(override.envKey); envVal != "" {
-			if parsed, parseErr := time.ParseDuration(envVal); parseErr == nil {
-				*override.target = parsed
-			} else {
-				return nil, fmt.Errorf("invalid %s (%s): %w", override.envKey, name, parseErr)
+		{"key_lifetime", int64(config.KeyLifetime)},
+		{"jwt_lifetime", int64(config.JWTLifetime)},
+		{"key_cleanup_interval", int64(config.KeyCleanup)},
+		{"read_timeout", int64(config.ReadTimeout)},
+		{"write_timeout", int64(config.WriteTimeout)},
+		{"idle_timeout", int64(config.IdleTimeout)},
+		{"shutdown_timeout", int64(config.ShutdownTimeout)},
+		{"rate_limit", int64(config.RateLimit)},
+		{"auth_rate_limit", int64(config.AuthRateLimit)},
+	} {
+		p.check(setting.key, positive(setting.value))
+	}
+	for _, key := range []string{"key_retain", "key_lead_time"} {
+		p.check(key, func(value string) error {
+			if d, _ := time.ParseDuration(value); d < 0 {
+				return errors.New("must not be negative")
+			}
+			return nil
+		})
+	}
+	p.check("unseal_threshold", func(string) error {
+		if config.UnsealThreshold < 1 || config.UnsealThreshold > 255 {
+			return errors.New("want 1 to 255")
+		}
+		return nil
+	})
+
+	// a token signed just before its key expires must still verify until it does
+	if p.parsed("jwt_lifetime", "key_lifetime", "key_retain") && config.JWTLifetime > config.KeyLifetime+config.KeyRetainPeriod {
+		p.conflict("jwt_lifetime", "JWT_LIFETIME %s is longer than KEY_LIFETIME + KEY_RETAIN (%s) - tokens would outlive their signing key",
+			config.JWTLifetime, config.KeyLifetime+config.KeyRetainPeriod)
+	}
+
+	// TLS - a certificate needs its key, and the other TLS settings need both
+	switch {
+	case config.TLSCertFile != "" && config.TLSKeyFile == "":
+		p.missing("tls_key_file", "TLS_KEY_FILE is required when TLS_CERT_FILE is set")
+	case config.TLSKeyFile != "" && config.TLSCertFile == "":
+		p.missing("tls_cert_file", "TLS_CERT_FILE is required when TLS_KEY_FILE is set")
+	case config.TLSCertFile == "":
+		for _, key := range []string{"tls_client_ca_file", "tls_redirect_addr"} {
+			if p.str(key) != "" {
+				p.conflict(key, "%s requires TLS_CERT_FILE", strings.ToUpper(key))
+			}
+		}
+	}
+	if len(config.TLSCipherSuites) > 0 && config.TLSMinVersion == tls.VersionTLS13 {
+		p.conflict("tls_cipher_suites", "TLS_CIPHER_SUITES has no effect with TLS_MIN_VERSION 1.3 - TLS 1.3 suites aren't configurable")
+	}
+	if config.TLSRedirectAddr != "" && config.TLSRedirectAddr == config.ListenAddr {
+		p.conflict("tls_redirect_addr", "TLS_REDIRECT_ADDR must differ from LISTEN_ADDR")
+	}
+
+	// provider requirements
+	switch config.KEKProvider {
+	case KEKProviderEnv:
+		// with another KEK provider NOT_MY_KEY only decrypts keys written before the switch
+		if config.EncryptionKey == "" && !config.Sealed {
+			p.missing("not_my_key", "NOT_MY_KEY is required for database encryption when KEK_PROVIDER is %s", KEKProviderEnv)
+		}
+	case KEKProviderFile:
+		if config.KEKFile == "" {
+			p.missing("kek_file", "KEK_FILE is required when KEK_PROVIDER is %s", KEKProviderFile)
+		}
+	case KEKProviderKMS:
+		if config.KEKURL == "" {
+			p.missing("kek_url", "KEK_URL is required when KEK_PROVIDER is %s", KEKProviderKMS)
+		}
+		if config.KEKKeyID == "" {
+			p.missing("kek_key_id", "KEK_KEY_ID is required when KEK_PROVIDER is %s", KEKProviderKMS)
+		}
+	}
+
+	// sealed startup - the master secret is supplied at runtime
+	if config.Sealed {
+		if config.UnsealToken == "" {
+			p.missing("unseal_token", "UNSEAL_TOKEN is required when SEALED is set")
+		}
+		if config.KEKProvider != KEKProviderEnv {
+			p.conflict("sealed", "SEALED requires KEK_PROVIDER %s", KEKProviderEnv)
+		}
+		if config.StorageBackend != db.BackendSQLite {
+			p.conflict("sealed", "SEALED requires STORAGE_BACKEND %s", db.BackendSQLite)
+		}
+		if config.EncryptionKey != "" {
+			p.conflict("not_my_key", "NOT_MY_KEY must not be set when SEALED is set")
+		}
+	}
+
+	if len(p.errs) > 0 {
+		return nil, &ValidationError{Errors: p.errs}
+	}
+	return config, nil
+}
+
+// configParser converts resolved settings, collecting every error instead of
+// stopping at the first
+type configParser struct {
+	values map[string]resolvedValue
+	errs   []*FieldError
+	failed map[string]bool // settings that didn't parse - skipped by check
+}
+
+func (p *configParser) report(key string, kind, err error) {
+	v := p.values[key]
+	value := v.value
+	if setting, _ := lookupSetting(key); setting.secret && value != "" {
+		value = redacted
+	}
+	p.errs = append(p.errs, &FieldError{Key: key, Value: value, Source: v.source, Kind: kind, Err: err})
+}
+
+func (p *configParser) invalid(key string, err error) {
+	p.report(key, ErrInvalidSetting, err)
+	if p.failed == nil {
+		p.failed = make(map[string]bool)
+	}
+	p.failed[key] = true
+}
+
+// required setting left unset
+func (p *configParser) missing(key, format string, args ...interface{}) {
+	kind := ErrMissingSetting
+	if setting, _ := lookupSetting(key); setting.secret {
+		kind = ErrMissingSecret
+	}
+	p.report(key, kind, fmt.Errorf(format, args...))
+}
+
+func (p *configParser) conflict(key, format string, args ...interface{}) {
+	p.report(key, ErrConflictingSettings, fmt.Errorf(format, args...))
+}
+
+// whether every key parsed - rules across settings skip ones already reported
+func (p *configParser) parsed(keys ...string) bool {
+	for _, key := range keys {
+		if p.failed[key] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *configParser) str(key string) string {
+	return p.values[key].value
+}
+
+func (p *configParser) duration(key string) time.Duration {
+	value := p.str(key)
+	if value == "" {
+		return 0
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil && !p.failed[key] {
+		p.invalid(key, err)
+	}
+	return parsed
+}
+
+func (p *configParser) integer(key string) int {
+	value := p.str(key)
+	if value == "" {
+		return 0
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil && !p.failed[key] {
+		p.invalid(key, err)
+	}
+	return parsed
+}
+
+func (p *configParser) boolean(key string) bool {
+	value := p.str(key)
+	if value == "" {
+		return false
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil && !p.failed[key] {
+		p.invalid(key, err)
+	}
+	return parsed
+}
+
+// slog level name: debug, info, warn or error
+func (p *configParser) logLevel(key string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(p.str(key))); err != nil && !p.failed[key] {
+		p.invalid(key, errors.New("want debug, info, warn or error"))
+	}
+	return level
+}
+
+// TLS version: 1.2 or 1.3
+func (p *configParser) tlsVersion(key string) uint16 {
+	switch p.str(key) {
+	case "1.2":
+		return tls.VersionTLS12
+	case "1.3":
+		return tls.VersionTLS13
+	default:
+		p.invalid(key, errors.New("want 1.2 or 1.3"))
+		return 0
+	}
+}
+
+// TLS 1.2 cipher suite names, as Go spells them - only suites without known weaknesses
+func (p *configParser) cipherSuites(key string) []uint16 {
+	var ids []uint16
+	for _, name := range p.list(key) {
+		id, err := cipherSuiteID(name)
+		if err != nil {
+			p.invalid(key, err)
+			return nil
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func cipherSuiteID(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name != name {
+			continue
+		}
+		for _, version := range suite.SupportedVersions {
+			if version == tls.VersionTLS12 {
+				return suite.ID, nil
 			}
 		}
+		return 0, fmt.Errorf("%s is a TLS 1.3 suite, which isn't configurable", name)
 	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return 0, fmt.Errorf("%s is insecure", name)
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %s", name)
+}
+
+// client certificate policy when a CA bundle is set
+func (p *configParser) clientAuth(key string) tls.ClientAuthType {
+	switch p.str(key) {
+	case "require":
+		return tls.RequireAndVerifyClientCert
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven
+	default:
+		p.invalid(key, errors.New("want require or verify-if-given"))
+		return tls.NoClientCert
+	}
+}
+
+// comma-separated list, blanks dropped
+func (p *configParser) list(key string) []string {
+	return splitList(p.str(key))
+}
+
+// run validate on a setting that parsed
+func (p *configParser) check(key string, validate func(value string) error) {
+	if p.failed[key] {
+		return
+	}
+	if err := validate(p.str(key)); err != nil {
+		p.invalid(key, err)
+	}
+}
+
+// validator for durations and counts that must be above zero
+func positive(value int64) func(string) error {
+	return func(string) error {
+		if value <= 0 {
+			return errors.New("must be positive")
+		}
+		return nil
+	}
+}
 
-	// string override
-	if envIssuer := os.Getenv("ISSUER"); envIssuer != "" {
-		issuer = envIssuer
+// comma-separated list, blanks dropped
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ValidateIssuer accepts a plain name like jwks-server or an absolute URI. Per
+// RFC 7519 an iss containing ':' must be a URI; http(s) issuers also need a host
+// and, as OpenID Connect requires, no query or fragment.
+func ValidateIssuer(issuer string) error {
+	if strings.ContainsAny(issuer, " \t\r\n") {
+		return errors.New("must not contain whitespace")
+	}
+	if !strings.Contains(issuer, ":") {
+		return nil
+	}
+
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return errors.Unwrap(err) // *url.Error repeats the issuer
 	}
+	if u.Scheme == "" {
+		return errors.New("want a plain name or an absolute URL")
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		switch {
+		case u.Host == "":
+			return errors.New("URL has no host")
+		case u.User != nil:
+			return errors.New("URL must not contain credentials")
+		case u.RawQuery != "" || u.ForceQuery || u.Fragment != "":
+			return errors.New("URL must not have a query or fragment")
+		}
+	}
+	return nil
+}
 
-	// Load encryption key from environment
-	encryptionKey := os.Getenv("NOT_MY_KEY")
-	if encryptionKey == "" {
-		log.Fatal("NOT_MY_KEY environment variable is required for database encryption")
+// ValidateCORSOrigin accepts "*" or a browser origin - scheme, host and optional
+// port, nothing else
+func ValidateCORSOrigin(origin string) error {
+	if origin == "*" {
+		return nil
 	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("origin %q is not * or scheme://host[:port]", origin)
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("origin %q must not have a path, query or credentials", origin)
+	}
+	return nil
+}
 
-	return &Config{
-		KeyLifetime:     keyLifetime,
-		KeyRetainPeriod: keyRetain,
-		JWTLifetime:     jwtLifetime,
-		Issuer:          issuer,
-		EncryptionKey:   encryptionKey,
-	}, nil
+// ValidateStorageBackend accepts the storage backends the server can open
+func ValidateStorageBackend(backend string) error {
+	switch backend {
+	case db.BackendSQLite, db.BackendMemory:
+		return nil
+	default:
+		return fmt.Errorf("unsupported storage backend %q (want %s or %s)", backend, db.BackendSQLite, db.BackendMemory)
+	}
 }
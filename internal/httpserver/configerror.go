@@ -0,0 +1,75 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// kinds of config problem - match with errors.Is on a *ValidationError or *FieldError
+var (
+	ErrInvalidSetting      = errors.New("invalid setting")      // value doesn't parse or is out of range
+	ErrMissingSetting      = errors.New("missing setting")      // required by another setting but unset
+	ErrMissingSecret       = errors.New("missing secret")       // NOT_MY_KEY, UNSEAL_TOKEN and friends
+	ErrConflictingSettings = errors.New("conflicting settings") // each fine alone, nonsensical together
+	ErrUnknownSetting      = errors.New("unknown setting")      // config file key or flag with no setting
+)
+
+// redacted stands in for secret values in output
+const redacted = "[REDACTED]"
+
+// FieldError is one problem with one setting. Rules spanning several settings
+// are reported against the setting named first in the message.
+type FieldError struct {
+	Key    string // config file key, e.g. jwt_lifetime
+	Value  string // offending value - redacted for secrets, empty when missing
+	Source string // layer the value came from: "default", "env NAME", "file path" or "flag -name"
+	Kind   error  // ErrInvalidSetting, ErrMissingSetting, ErrMissingSecret, ErrConflictingSettings or ErrUnknownSetting
+	Err    error  // what's wrong
+}
+
+func (e *FieldError) Error() string {
+	if e.Kind == ErrInvalidSetting {
+		return fmt.Sprintf("invalid %s %q (%s): %v", e.Key, e.Value, e.Source, e.Err)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap exposes both the kind and the cause to errors.Is and errors.As
+func (e *FieldError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// ValidationError is every problem found loading the config, so operators can
+// fix them all in one go instead of one restart at a time
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+// one problem per line
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Field returns the problems reported against key
+func (e *ValidationError) Field(key string) []*FieldError {
+	var found []*FieldError
+	for _, err := range e.Errors {
+		if err.Key == key {
+			found = append(found, err)
+		}
+	}
+	return found
+}
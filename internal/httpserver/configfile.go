@@ -0,0 +1,247 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"csce-3550_jwks-srv/internal/db"
+)
+
+// ConfigSources are the layers on top of the defaults: the config file, then the
+// environment (read by LoadConfig), then command-line flags
+type ConfigSources struct {
+	File  string            // config file - CONFIG_FILE when empty, none if that's unset too
+	Flags map[string]string // setting key -> value for flags given on the command line
+}
+
+// resolvedValue is a setting's winning value and the layer it came from
+type resolvedValue struct {
+	value  string
+	source string // "default", "env NAME", "file path" or "flag -name"
+}
+
+// layer the sources over the defaults - empty values count as unset in every layer
+func (s ConfigSources) resolve() (map[string]resolvedValue, error) {
+	values := make(map[string]resolvedValue, len(settings))
+	for _, setting := range settings {
+		values[setting.key] = resolvedValue{value: setting.def, source: "default"}
+	}
+
+	// a *ValidationError from the file still leaves its valid values usable
+	var fileErr error
+	path := s.File
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		fileValues, err := readConfigFile(path)
+		var invalid *ValidationError
+		if err != nil && !errors.As(err, &invalid) {
+			return nil, err
+		}
+		fileErr = err
+		for key, value := range fileValues {
+			if value != "" {
+				values[key] = resolvedValue{value: value, source: "file " + path}
+			}
+		}
+	}
+
+	for _, setting := range settings {
+		if value := os.Getenv(setting.env); value != "" {
+			values[setting.key] = resolvedValue{value: value, source: "env " + setting.env}
+		}
+	}
+
+	for key, value := range s.Flags {
+		setting, ok := lookupSetting(key)
+		if !ok || setting.flag == "" {
+			return nil, fmt.Errorf("%w: no config flag for %q", ErrUnknownSetting, key)
+		}
+		if value != "" {
+			values[key] = resolvedValue{value: value, source: "flag -" + setting.flag}
+		}
+	}
+
+	return values, fileErr
+}
+
+// DatabasePath resolves just db_path and data_dir - for tools like migrate that
+// need the database but not a valid server config
+func (s ConfigSources) DatabasePath() (string, error) {
+	values, err := s.resolve()
+	if err != nil {
+		return "", err
+	}
+	return db.ResolvePath(values["db_path"].value, values["data_dir"].value), nil
+}
+
+func lookupSetting(key string) (setting, bool) {
+	for _, setting := range settings {
+		if setting.key == key {
+			return setting, true
+		}
+	}
+	return setting{}, false
+}
+
+// readConfigFile decodes a YAML, JSON or TOML file, picked by extension, into
+// setting values. Unknown keys and nested values are reported together in a
+// *ValidationError, returned alongside the values that were fine.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s (want .yaml, .yml, .json or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// sorted so errors come out in a stable order
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make(map[string]string, len(raw))
+	var errs []*FieldError
+	for _, key := range keys {
+		source := "file " + path
+		setting, ok := lookupSetting(key)
+		if !ok {
+			errs = append(errs, &FieldError{Key: key, Source: source, Kind: ErrUnknownSetting,
+				Err: fmt.Errorf("unknown setting %q in %s", key, path)})
+			continue
+		}
+		value, err := fileValue(raw[key])
+		if err != nil {
+			shown := fmt.Sprint(raw[key])
+			if setting.secret {
+				shown = redacted
+			}
+			errs = append(errs, &FieldError{Key: key, Value: shown, Source: source, Kind: ErrInvalidSetting, Err: err})
+			continue
+		}
+		values[key] = value
+	}
+	if len(errs) > 0 {
+		return values, &ValidationError{Errors: errs}
+	}
+	return values, nil
+}
+
+// scalar file value as the string an env var would hold - lists join with commas
+func fileValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int64, uint64, float64, json.Number:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				return "", errors.New("nested lists are not supported")
+			}
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+// ConfigFlags holds the command-line flags registered by RegisterConfigFlags
+type ConfigFlags struct {
+	fs     *flag.FlagSet
+	file   *string
+	values map[string]*string // setting key -> flag value
+}
+
+// RegisterConfigFlags adds -config plus a flag for every non-secret setting to fs
+func RegisterConfigFlags(fs *flag.FlagSet) *ConfigFlags {
+	flags := &ConfigFlags{
+		fs:     fs,
+		file:   fs.String("config", "", "config file (.yaml, .yml, .json or .toml), overrides CONFIG_FILE"),
+		values: make(map[string]*string),
+	}
+	for _, setting := range settings {
+		if setting.flag == "" {
+			continue
+		}
+		usage := setting.usage + " (env " + setting.env + ")"
+		flags.values[setting.key] = fs.String(setting.flag, "", usage)
+	}
+	return flags
+}
+
+// Sources returns the config file and the flags actually given - call after fs.Parse
+func (f *ConfigFlags) Sources() ConfigSources {
+	sources := ConfigSources{File: *f.file, Flags: make(map[string]string)}
+	f.fs.Visit(func(fl *flag.Flag) {
+		for key, value := range f.values {
+			if setting, _ := lookupSetting(key); setting.flag == fl.Name {
+				sources.Flags[key] = *value
+			}
+		}
+	})
+	return sources
+}
+
+// PrintConfig validates the layered config and writes every setting as YAML, with
+// the layer it came from as a comment. Secrets are redacted.
+func PrintConfig(sources ConfigSources, out io.Writer) error {
+	if _, err := LoadConfig(sources); err != nil {
+		return err
+	}
+
+	values, err := sources.resolve()
+	if err != nil {
+		return err
+	}
+
+	for _, setting := range settings {
+		value := values[setting.key]
+		shown := value.value
+		if setting.secret && shown != "" {
+			shown = redacted
+		}
+		fmt.Fprintf(out, "%s: %s # %s\n", setting.key, strconv.Quote(shown), value.source)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clear every setting's env var so only the layers under test apply
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, setting := range settings {
+		t.Setenv(setting.env, "")
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFileFormats(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+listen_addr: ":9090"
+key_lifetime: 20m
+rate_limit: 25
+key_purge_dry_run: true
+not_my_key: file-secret
+kek_previous_files: [/etc/kek.1, /etc/kek.2]
+`,
+		"config.json": `{
+  "listen_addr": ":9090",
+  "key_lifetime": "20m",
+  "rate_limit": 25,
+  "key_purge_dry_run": true,
+  "not_my_key": "file-secret",
+  "kek_previous_files": ["/etc/kek.1", "/etc/kek.2"]
+}`,
+		"config.toml": `
+listen_addr = ":9090"
+key_lifetime = "20m"
+rate_limit = 25
+key_purge_dry_run = true
+not_my_key = "file-secret"
+kek_previous_files = ["/etc/kek.1", "/etc/kek.2"]
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearConfigEnv(t)
+			path := writeConfigFile(t, name, content)
+
+			config, err := LoadConfig(ConfigSources{File: path})
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if config.ListenAddr != ":9090" || config.KeyLifetime != 20*time.Minute || config.RateLimit != 25 {
+				t.Errorf("Unexpected config %+v", config)
+			}
+			if !config.KeyPurgeDryRun || config.EncryptionKey != "file-secret" {
+				t.Errorf("Expected dry run and file secret, got %v/%q", config.KeyPurgeDryRun, config.EncryptionKey)
+			}
+			if strings.Join(config.KEKPreviousFiles, ",") != "/etc/kek.1,/etc/kek.2" {
+				t.Errorf("Unexpected KEK previous files %v", config.KEKPreviousFiles)
+			}
+			// untouched settings keep their defaults
+			if config.JWTLifetime != 5*time.Minute || config.AuthRateLimit != defaultAuthRateLimit {
+				t.Errorf("Expected defaults, got %v/%d", config.JWTLifetime, config.AuthRateLimit)
+			}
+		})
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, "config.yaml", `
+issuer: from-file
+key_lifetime: 20m
+jwt_lifetime: 2m
+not_my_key: file-secret
+`)
+
+	t.Setenv("ISSUER", "from-env")
+	t.Setenv("JWT_LIFETIME", "3m")
+	t.Setenv("CONFIG_FILE", path)
+
+	// CONFIG_FILE is used when no file is given
+	config, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+	if config.Issuer != "from-env" || config.KeyLifetime != 20*time.Minute || config.JWTLifetime != 3*time.Minute {
+		t.Errorf("Expected env over file, got %q/%v/%v", config.Issuer, config.KeyLifetime, config.JWTLifetime)
+	}
+
+	// flags beat env; empty flags don't count
+	config, err = LoadConfig(ConfigSources{Flags: map[string]string{"issuer": "from-flag", "jwt_lifetime": ""}})
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.Issuer != "from-flag" || config.JWTLifetime != 3*time.Minute {
+		t.Errorf("Expected flag over env, got %q/%v", config.Issuer, config.JWTLifetime)
+	}
+
+	if _, err := LoadConfig(ConfigSources{Flags: map[string]string{"not_my_key": "x"}}); err == nil {
+		t.Error("Expected secrets to be rejected as flags")
+	}
+}
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("NOT_MY_KEY", "test-encryption-key-123")
+	t.Setenv("KEY_LIFETIME", "forever")
+	t.Setenv("RSA_KEY_SIZE", "1024")
+	t.Setenv("SIGNING_ALG", "HS256")
+	t.Setenv("RATE_LIMIT", "0")
+	t.Setenv("KEK_PROVIDER", "file")
+	path := writeConfigFile(t, "config.yaml", "issuer: https://auth.example.com\nlisten: \":80\"\n")
+
+	_, err := LoadConfig(ConfigSources{File: path, Flags: map[string]string{"read_timeout": "soon"}})
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("Expected *ValidationError, got %T: %v", err, err)
+	}
+	if len(validation.Errors) != 7 {
+		t.Errorf("Expected 7 errors, got %d:\n%v", len(validation.Errors), err)
+	}
+	for _, kind := range []error{ErrInvalidSetting, ErrMissingSetting, ErrUnknownSetting} {
+		if !errors.Is(err, kind) {
+			t.Errorf("Expected %v among the errors", kind)
+		}
+	}
+
+	for _, want := range []string{
+		`invalid key_lifetime "forever" (env KEY_LIFETIME)`,
+		`invalid rsa_key_size "1024" (env RSA_KEY_SIZE)`,
+		`invalid signing_alg "HS256" (env SIGNING_ALG)`,
+		`invalid rate_limit "0" (env RATE_LIMIT)`,
+		`invalid read_timeout "soon" (flag -read-timeout)`,
+		"KEK_FILE is required",
+		`unknown setting "listen"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"unknown keys", "config.yaml", "issuer: x\nlisten: :80\nport: 80\n", `unknown setting "listen"`},
+		{"nested value", "config.json", `{"issuer": {"name": "x"}}`, "invalid issuer"},
+		{"syntax error", "config.toml", "issuer = ", "failed to parse config file"},
+		{"unsupported extension", "config.ini", "issuer=x", "unsupported config file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readConfigFile(writeConfigFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// every unknown key is reported, not just the first, and known keys still come back
+	values, err := readConfigFile(writeConfigFile(t, "config.yaml", "issuer: x\nlisten: :80\nport: 80\n"))
+	if err == nil || !strings.Contains(err.Error(), `"listen"`) || !strings.Contains(err.Error(), `"port"`) {
+		t.Errorf("Expected both unknown keys reported, got %v", err)
+	}
+	if values["issuer"] != "x" {
+		t.Errorf("Expected known values alongside the errors, got %v", values)
+	}
+
+	// secrets never show up in errors
+	_, err = readConfigFile(writeConfigFile(t, "config.yaml", "not_my_key: {nested: hunter2}\n"))
+	if err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected redacted secret error, got %v", err)
+	}
+
+	if _, err := readConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected error for a missing config file")
+	}
+}
+
+func TestRegisterConfigFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterConfigFlags(fs)
+
+	if err := fs.Parse([]string{"-config", "/etc/jwks-srv.yaml", "-storage", "memory", "-key-lifetime", "30m", "rekey"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	sources := flags.Sources()
+	if sources.File != "/etc/jwks-srv.yaml" {
+		t.Errorf("Expected config file from -config, got %q", sources.File)
+	}
+	if len(sources.Flags) != 2 || sources.Flags["storage_backend"] != "memory" || sources.Flags["key_lifetime"] != "30m" {
+		t.Errorf("Expected only the given flags, got %v", sources.Flags)
+	}
+	if fs.Arg(0) != "rekey" {
+		t.Errorf("Expected subcommand to remain, got %v", fs.Args())
+	}
+
+	for _, secret := range []string{"not-my-key", "kek-token", "unseal-token"} {
+		if fs.Lookup(secret) != nil {
+			t.Errorf("Secret %s should not be a flag", secret)
+		}
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, "config.yaml", "issuer: from-file\n")
+	t.Setenv("NOT_MY_KEY", "super-secret-value")
+
+	var out bytes.Buffer
+	sources := ConfigSources{File: path, Flags: map[string]string{"key_lifetime": "30m"}}
+	if err := PrintConfig(sources, &out); err != nil {
+		t.Fatalf("PrintConfig() error = %v", err)
+	}
+
+	printed := out.String()
+	if strings.Contains(printed, "super-secret-value") {
+		t.Error("PrintConfig() leaked NOT_MY_KEY")
+	}
+	for _, want := range []string{
+		`not_my_key: "[REDACTED]" # env NOT_MY_KEY`,
+		`issuer: "from-file" # file ` + path,
+		`key_lifetime: "30m" # flag -key-lifetime`,
+		`jwt_lifetime: "5m" # default`,
+		`kek_token: "" # default`,
+	} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, printed)
+		}
+	}
+
+	t.Setenv("KEY_LIFETIME", "bad")
+	if err := PrintConfig(ConfigSources{}, &out); err == nil {
+		t.Error("Expected PrintConfig() to report validation errors")
+	}
+}
+
+func TestNewSrvUsesConfig(t *testing.T) {
+	server := NewSealedSrv(&Config{ListenAddr: ":9443", ReadTimeout: time.Second}, nil, nil)
+
+	if server.httpServer.Addr != ":9443" || server.httpServer.ReadTimeout != time.Second {
+		t.Errorf("Expected configured listener, got %q/%v", server.httpServer.Addr, server.httpServer.ReadTimeout)
+	}
+	// zero values fall back to the defaults
+	if server.httpServer.WriteTimeout != defaultWriteTimeout || server.rateLimit() != defaultRateLimit {
+		t.Errorf("Expected defaults, got %v/%d", server.httpServer.WriteTimeout, server.rateLimit())
+	}
+}
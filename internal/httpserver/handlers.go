@@ -2,10 +2,16 @@ package httpserver
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"csce-3550_jwks-srv/internal/db"
 	"csce-3550_jwks-srv/internal/jwt"
 )
 
@@ -16,8 +22,14 @@ func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get JWKS
-	jwks, err := s.manager.GetJWKS()
+	manager := s.manager.Load()
+	if manager == nil {
+		s.handleSealedJWKS(w)
+		return
+	}
+
+	// pre-serialized by the key manager, rebuilt only when keys change
+	body, err := manager.JWKSJSON()
 	if err != nil {
 		http.Error(w, "Failed to get JWKS", http.StatusInternalServerError)
 		return
@@ -26,17 +38,13 @@ func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
 	// set headers
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-
-	// encode response
-	if err := json.NewEncoder(w).Encode(jwks); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return
-	}
+	w.Write(body)
 }
 
 // AuthRequest represents the request body for authentication
 type AuthRequest struct {
 	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 // auth endpoint handler - POST /auth
@@ -49,48 +57,67 @@ func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
 	// extract request IP address
 	requestIP := s.getRequestIP(r)
 
-	// parse request body to get username (optional)
-	var authReq AuthRequest
-	username := ""
+	// credentials come from basic auth or the JSON body
+	username, password, err := parseCredentials(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-	// only try to decode if there's a body
-	if r.Body != nil && r.ContentLength > 0 {
-		if err := json.NewDecoder(r.Body).Decode(&authReq); err == nil {
-			username = authReq.Username
-		}
+	if username == "" || password == "" {
+		s.logAuthRequest(requestIP, "")
+		s.unauthorized(w)
+		return
 	}
-	// if parsing fails or no username provided, we still proceed but log with empty username
 
-	// log authentication request
-	if err := s.manager.LogAuthRequest(requestIP, username); err != nil {
-		// log the error but don't fail the request
-		// in production, you might want to log this error
+	user, err := s.manager.Load().AuthenticateUser(username, password)
+	if err != nil {
+		s.logAuthRequest(requestIP, "")
+		if errors.Is(err, db.ErrInvalidCredentials) {
+			s.unauthorized(w)
+			return
+		}
+		http.Error(w, "Failed to verify credentials", http.StatusInternalServerError)
+		return
 	}
 
+	// log authentication request against the verified user
+	s.logAuthRequest(requestIP, user.Username)
+
 	// check for expired query param
 	expired := r.URL.Query().Get("expired") != ""
 
 	// get signing key
-	signingKey := s.manager.GetSigningKey(expired)
+	signingKey := s.manager.Load().GetSigningKey(expired)
 	if signingKey == nil {
 		http.Error(w, "No signing key available", http.StatusInternalServerError)
 		return
 	}
 
+	// one snapshot so a reload can't mix lifetime and issuer
+	config := s.config.Load()
+
 	// determine expiry - if expired=true, force expiry in the past
-	expiry := s.config.JWTLifetime
+	expiry := config.JWTLifetime
 	if expired {
 		// ensure the token is already expired when returned
 		expiry = -1 * time.Minute
 	}
 
+	// claims for the verified user
+	claims, err := jwt.NewClaims(config.Issuer).
+		Subject(strconv.FormatInt(user.ID, 10)).
+		Audience(jwt.DefaultAudience).
+		Claim("preferred_username", user.Username).
+		ExpiresIn(expiry).
+		Build()
+	if err != nil {
+		http.Error(w, "Failed to create JWT", http.StatusInternalServerError)
+		return
+	}
+
 	// create JWT
-	token, err := jwt.CreateJWT(
-		signingKey.PrivateKey,
-		signingKey.ID,
-		s.config.Issuer,
-		expiry,
-	)
+	token, err := jwt.Sign(signingKey.PrivateKey, signingKey.Algorithm, signingKey.ID, claims)
 	if err != nil {
 		http.Error(w, "Failed to create JWT", http.StatusInternalServerError)
 		return
@@ -110,6 +137,36 @@ func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseCredentials reads username/password from basic auth, falling back to a JSON body
+func parseCredentials(r *http.Request) (string, string, error) {
+	if username, password, ok := r.BasicAuth(); ok {
+		return username, password, nil
+	}
+
+	// only try to decode if there's a body
+	var authReq AuthRequest
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&authReq); err != nil && err != io.EOF {
+			return "", "", err
+		}
+	}
+
+	return authReq.Username, authReq.Password, nil
+}
+
+// unauthorized rejects a request and advertises basic auth as per RFC 7617
+func (s *Server) unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, s.config.Load().Issuer))
+	http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+}
+
+// logAuthRequest records the request without failing it on logging errors
+func (s *Server) logAuthRequest(requestIP, username string) {
+	if err := s.manager.Load().LogAuthRequest(requestIP, username); err != nil {
+		log.Printf("failed to log auth request: %v", err)
+	}
+}
+
 // getRequestIP extracts the client IP address from the request
 func (s *Server) getRequestIP(r *http.Request) string {
 	// check X-Forwarded-For header first (for proxies/load balancers)
@@ -174,7 +231,7 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// create user and get generated password
-	password, err := s.manager.CreateUser(req.Username, req.Email)
+	password, err := s.manager.Load().CreateUser(req.Username, req.Email)
 	if err != nil {
 		// check for duplicate username/email errors
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
@@ -208,9 +265,9 @@ func (s *Server) applyMiddleware(handler http.HandlerFunc) http.Handler {
 	// add middleware stack
 	h = RecoveryMiddleware(h)
 	h = SecurityHeadersMiddleware(h)
-	h = CORSMiddleware(h)
-	h = RateLimitMiddleware(h)
-	h = LoggingMiddleware(h)
+	h = corsMiddleware(s.corsOrigins)(h)
+	h = rateLimitMiddleware(s.rateLimit, &s.logLevel)(h)
+	h = loggingMiddleware(&s.logLevel)(h)
 
 	return h
 }
@@ -223,9 +280,9 @@ func (s *Server) applyAuthMiddleware(handler http.HandlerFunc) http.Handler {
 	// add middleware stack with auth-specific rate limiter
 	h = RecoveryMiddleware(h)
 	h = SecurityHeadersMiddleware(h)
-	h = CORSMiddleware(h)
-	h = AuthRateLimitMiddleware(h) // 10 requests per second
-	h = LoggingMiddleware(h)
+	h = corsMiddleware(s.corsOrigins)(h)
+	h = authRateLimitMiddleware(s.authLimiter, s.authRateLimit)(h) // 10 requests per second by default
+	h = loggingMiddleware(&s.logLevel)(h)
 
 	return h
 }
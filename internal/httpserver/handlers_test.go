@@ -1,6 +1,10 @@
 package httpserver
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -29,11 +33,11 @@ func TestNewSrv(t *testing.T) {
 		t.Fatal("NewSrv returned nil")
 	}
 
-	if server.config != config {
+	if server.Config() != config {
 		t.Error("Server config not set correctly")
 	}
 
-	if server.manager != manager {
+	if server.Manager() != manager {
 		t.Error("Server manager not set correctly")
 	}
 
@@ -117,7 +121,13 @@ func TestHandleJWKSMethodNotAllowed(t *testing.T) {
 	}
 }
 
-func TestHandleAuth(t *testing.T) {
+// newAuthTestServer starts a manager on an isolated database and registers a user
+func newAuthTestServer(t *testing.T) (*Server, string, string) {
+	t.Helper()
+
+	// keep the database out of the package dir so other tests' keys don't collide
+	t.Chdir(t.TempDir())
+
 	encryptionKey := "test-encryption-key-32-bytes-long" // Match the environment variable
 	config := &Config{
 		KeyLifetime:     10 * time.Minute,
@@ -134,62 +144,133 @@ func TestHandleAuth(t *testing.T) {
 	if err := manager.Start(); err != nil {
 		t.Fatalf("Manager.Start() error = %v", err)
 	}
-	defer manager.Stop()
-
-	// Wait longer for key generation
-	time.Sleep(3 * time.Second)
-
-	server := NewSrv(manager, config)
+	t.Cleanup(manager.Stop)
 
-	// test POST method
-	req, err := http.NewRequest("POST", "/auth", nil)
+	username := fmt.Sprintf("authuser-%d", time.Now().UnixNano())
+	password, err := manager.CreateUser(username, username+"@example.com")
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("CreateUser error = %v", err)
 	}
 
-	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(server.handleAuth)
-	handler.ServeHTTP(rr, req)
-
-	if status := rr.Code; status != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v (body: %s)",
-			status, http.StatusOK, rr.Body.String())
-	}
-
-	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
-		t.Errorf("handler returned wrong content type: got %v want %v",
-			contentType, "application/json")
-	}
+	return NewSrv(manager, config), username, password
+}
 
-	body := rr.Body.String()
-	if !strings.Contains(body, "token") {
-		t.Error("Response does not contain 'token' field")
+func TestHandleAuth(t *testing.T) {
+	server, username, password := newAuthTestServer(t)
+
+	basicReq := httptest.NewRequest("POST", "/auth", nil)
+	basicReq.SetBasicAuth(username, password)
+
+	body, _ := json.Marshal(AuthRequest{Username: username, Password: password})
+	jsonReq := httptest.NewRequest("POST", "/auth", bytes.NewReader(body))
+	jsonReq.Header.Set("Content-Type", "application/json")
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"json body", jsonReq},
+		{"basic auth", basicReq},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(server.handleAuth)
+			handler.ServeHTTP(rr, tt.req)
+
+			if status := rr.Code; status != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %v want %v (body: %s)",
+					status, http.StatusOK, rr.Body.String())
+			}
+
+			if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("handler returned wrong content type: got %v want %v",
+					contentType, "application/json")
+			}
+
+			var resp map[string]string
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			claims := decodeTokenClaims(t, resp["token"])
+			if sub, _ := claims["sub"].(string); sub == "" || strings.Trim(sub, "0123456789") != "" {
+				t.Errorf("Expected numeric user id as sub, got %v", claims["sub"])
+			}
+			if claims["preferred_username"] != username {
+				t.Errorf("Expected preferred_username %q, got %v", username, claims["preferred_username"])
+			}
+			if claims["jti"] == nil || claims["jti"] == "" {
+				t.Error("Expected jti claim")
+			}
+		})
 	}
 }
 
-func TestHandleAuthWithExpired(t *testing.T) {
-	encryptionKey := "test-encryption-key-32-bytes-long" // Match the environment variable
-	config := &Config{
-		KeyLifetime:     10 * time.Minute,
-		KeyRetainPeriod: time.Hour,
-		JWTLifetime:     5 * time.Minute,
-		Issuer:          "test-issuer",
-		EncryptionKey:   encryptionKey,
+// decodeTokenClaims returns the unverified payload of a compact JWT
+func decodeTokenClaims(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected 3 JWT parts, got %d", len(parts))
 	}
 
-	manager, err := keys.NewManager(config.KeyLifetime, config.KeyRetainPeriod, config.EncryptionKey)
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		t.Fatalf("NewManager error = %v", err)
+		t.Fatalf("Failed to decode payload: %v", err)
 	}
-	if err := manager.Start(); err != nil {
-		t.Fatalf("Manager.Start() error = %v", err)
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("Failed to unmarshal payload: %v", err)
 	}
-	defer manager.Stop()
 
-	// Wait longer for key generation
-	time.Sleep(3 * time.Second)
+	return claims
+}
 
-	server := NewSrv(manager, config)
+func TestHandleAuthRejectsBadCredentials(t *testing.T) {
+	server, username, password := newAuthTestServer(t)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"no credentials", "", http.StatusUnauthorized},
+		{"username only", fmt.Sprintf(`{"username":%q}`, username), http.StatusUnauthorized},
+		{"wrong password", fmt.Sprintf(`{"username":%q,"password":"wrong"}`, username), http.StatusUnauthorized},
+		{"unknown user", fmt.Sprintf(`{"username":"nobody","password":%q}`, password), http.StatusUnauthorized},
+		{"malformed body", `{"username":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/auth", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			server.handleAuth(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+
+			if tt.expectedStatus == http.StatusUnauthorized {
+				challenge := rr.Header().Get("WWW-Authenticate")
+				if !strings.HasPrefix(challenge, `Basic realm="test-issuer"`) {
+					t.Errorf("unexpected WWW-Authenticate header: %q", challenge)
+				}
+			}
+
+			if strings.Contains(rr.Body.String(), "token") {
+				t.Error("Response should not contain a token")
+			}
+		})
+	}
+}
+
+func TestHandleAuthWithExpired(t *testing.T) {
+	server, username, password := newAuthTestServer(t)
 
 	// Wait for the 10-second key to expire
 	t.Log("Waiting for 10-second key to expire...")
@@ -199,6 +280,7 @@ func TestHandleAuthWithExpired(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.SetBasicAuth(username, password)
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(server.handleAuth)
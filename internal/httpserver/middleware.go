@@ -2,26 +2,52 @@ package httpserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"runtime/debug"
 	"strings"
 	"sync"
 	"time"
+
+	"csce-3550_jwks-srv/internal/jwt"
 )
 
 // req logging middleware
 func LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		wrapped := &responseWriter{ResponseWriter: w, statusCode: 200}
+	return loggingMiddleware(new(slog.LevelVar))(next)
+}
 
-		next.ServeHTTP(wrapped, r)
+// request logging at or above level - successes log at info, client errors at
+// warn and server errors at error. level is read per request so it can change live.
+func loggingMiddleware(level *slog.LevelVar) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: 200}
 
-		duration := time.Since(start)
-		log.Printf("%s %s %d %v", r.Method, r.URL.Path, wrapped.statusCode, duration)
-	})
+			next.ServeHTTP(wrapped, r)
+
+			if statusLevel(wrapped.statusCode) < level.Level() {
+				return
+			}
+			duration := time.Since(start)
+			log.Printf("%s %s %d %v", r.Method, r.URL.Path, wrapped.statusCode, duration)
+		})
+	}
+}
+
+func statusLevel(code int) slog.Level {
+	switch {
+	case code >= 500:
+		return slog.LevelError
+	case code >= 400:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
 }
 
 // status code wrapper
@@ -37,24 +63,53 @@ func (rw *responseWriter) WriteHeader(code int) {
 
 // CORS middleware - handles cross-origin requests
 func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	return corsMiddleware(func() []string { return []string{"*"} })(next)
+}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// CORS for the origins returns - "*" allows any, otherwise a listed Origin is
+// echoed back and others get no CORS headers
+func corsMiddleware(origins func() []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed := allowedOrigin(origins(), r.Header.Get("Origin"))
+			if allowed != "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			}
+			if allowed != "*" {
+				// the answer depends on the Origin header
+				w.Header().Add("Vary", "Origin")
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Access-Control-Allow-Origin value for origin, empty if it's not allowed
+func allowedOrigin(origins []string, origin string) string {
+	for _, allowed := range origins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
 }
 
 // rate limiter structure
 type rateLimiter struct {
 	visitors map[string]*visitor
 	mu       sync.RWMutex
+	logLevel *slog.LevelVar // per-request lines log at debug - nil logs them all
 }
 
 type visitor struct {
@@ -79,33 +134,50 @@ var authRateLimiter = &rateLimiter{
 
 // rate limiting middleware - prevents abuse (general use)
 func RateLimitMiddleware(next http.Handler) http.Handler {
-	limiter := &rateLimiter{
-		visitors: make(map[string]*visitor),
-	}
+	return rateLimitMiddleware(func() int { return defaultRateLimit }, nil)(next)
+}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-		if !limiter.allow(ip, 10, time.Minute) {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-			return
+// general rate limiting at perSecond() requests per client, one limiter per route
+func rateLimitMiddleware(perSecond func() int, level *slog.LevelVar) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limiter := &rateLimiter{
+			visitors: make(map[string]*visitor),
+			logLevel: level,
 		}
 
-		next.ServeHTTP(w, r)
-	})
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := r.RemoteAddr
+			if !limiter.allow(ip, perSecond(), time.Minute) {
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // AuthRateLimitMiddleware - specific rate limiter for /auth endpoint (10 req/sec)
 func AuthRateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getClientIP(r)
-		// For 10 req/sec: 1 token refills every 100ms (time.Second / 10)
-		if !authRateLimiter.allow(ip, 10, time.Second/10) {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
-		}
+	return authRateLimitMiddleware(authRateLimiter, func() int { return defaultAuthRateLimit })(next)
+}
 
-		next.ServeHTTP(w, r)
-	})
+// auth rate limiting at perSecond() requests per client IP - limiter is shared by
+// every route it wraps so credential guessing can't spread across endpoints
+func authRateLimitMiddleware(limiter *rateLimiter, perSecond func() int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := getClientIP(r)
+			// one window of perSecond requests per second
+			limit := perSecond()
+			if !limiter.allow(ip, limit, time.Second/time.Duration(limit)) {
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // getClientIP extracts the client IP address from the request
@@ -155,31 +227,42 @@ func (rl *rateLimiter) allow(ip string, capacity int, refillRate time.Duration)
 	}
 
 	vis.lastSeen = time.Now()
-	return vis.limiter.consume()
+	verbose := rl.logLevel == nil || rl.logLevel.Level() <= slog.LevelDebug
+	return vis.limiter.consume(capacity, refillRate, verbose)
 }
 
-func (tb *tokenBucket) consume() bool {
+// take a request from the bucket - capacity and refillRate are re-applied each time
+// so a reloaded limit reaches clients already being tracked
+func (tb *tokenBucket) consume(capacity int, refillRate time.Duration, verbose bool) bool {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
+	tb.capacity = capacity
+	tb.refillRate = refillRate
 	now := time.Now()
 
 	// Check if we need to reset the window (1 second has passed)
 	if now.Sub(tb.windowStart) >= time.Second {
 		tb.windowStart = now
 		tb.requestCount = 0
-		log.Printf("[Rate Limit] Window reset, counter set to 0")
+		if verbose {
+			log.Printf("[Rate Limit] Window reset, counter set to 0")
+		}
 	}
 
 	// Check if we've exceeded the limit in the current window
 	if tb.requestCount >= tb.capacity {
-		log.Printf("[Rate Limit] Request limit reached (%d/%d), request blocked", tb.requestCount, tb.capacity)
+		if verbose {
+			log.Printf("[Rate Limit] Request limit reached (%d/%d), request blocked", tb.requestCount, tb.capacity)
+		}
 		return false
 	}
 
 	// Allow the request
 	tb.requestCount++
-	log.Printf("[Rate Limit] Request allowed (%d/%d)", tb.requestCount, tb.capacity)
+	if verbose {
+		log.Printf("[Rate Limit] Request allowed (%d/%d)", tb.requestCount, tb.capacity)
+	}
 	return true
 }
 
@@ -254,3 +337,127 @@ func TimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// RequireJWTOptions configures RequireJWT
+type RequireJWTOptions struct {
+	jwt.VerifyOptions          // issuer, audience and clock skew checks
+	Realm             string   // realm advertised in WWW-Authenticate
+	RequiredScopes    []string // every scope must be granted by the token
+}
+
+// claims context key - unexported type so other packages can't collide
+type claimsContextKey struct{}
+
+// ClaimsFromContext returns the claims RequireJWT stored on the request
+func ClaimsFromContext(ctx context.Context) (*jwt.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*jwt.Claims)
+	return claims, ok
+}
+
+// bearer token middleware - for resource servers verifying tokens we issue (RFC 6750)
+func RequireJWT(keyFunc jwt.KeyFunc, opts RequireJWTOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				// no credentials - challenge without an error code (RFC 6750 3.1)
+				bearerChallenge(w, opts.Realm, http.StatusUnauthorized, "", "", "")
+				return
+			}
+
+			scheme, token, found := strings.Cut(authHeader, " ")
+			token = strings.TrimSpace(token)
+			if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+				bearerChallenge(w, opts.Realm, http.StatusBadRequest, "invalid_request", "Malformed Authorization header", "")
+				return
+			}
+
+			claims, err := jwt.VerifyJWT(token, keyFunc, opts.VerifyOptions)
+			if err != nil {
+				bearerChallenge(w, opts.Realm, http.StatusUnauthorized, "invalid_token", tokenErrorDescription(err), "")
+				return
+			}
+
+			if missing := missingScopes(claims, opts.RequiredScopes); len(missing) > 0 {
+				bearerChallenge(w, opts.Realm, http.StatusForbidden, "insufficient_scope",
+					"The token lacks a required scope", strings.Join(opts.RequiredScopes, " "))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bearerChallenge writes a WWW-Authenticate: Bearer response
+func bearerChallenge(w http.ResponseWriter, realm string, status int, code, description, scope string) {
+	params := []string{}
+	if realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", realm))
+	}
+	if code != "" {
+		params = append(params, fmt.Sprintf("error=%q", code))
+	}
+	if description != "" {
+		params = append(params, fmt.Sprintf("error_description=%q", description))
+	}
+	if scope != "" {
+		params = append(params, fmt.Sprintf("scope=%q", scope))
+	}
+
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// tokenErrorDescription maps verification errors to fixed client-safe text
+func tokenErrorDescription(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "The access token expired"
+	case errors.Is(err, jwt.ErrTokenNotYetValid), errors.Is(err, jwt.ErrTokenIssuedInFuture):
+		return "The access token is not valid yet"
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		return "The access token has an unexpected issuer"
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		return "The access token is not intended for this resource"
+	case errors.Is(err, jwt.ErrMalformedToken):
+		return "The access token is malformed"
+	default:
+		return "The access token is invalid"
+	}
+}
+
+// missingScopes returns required scopes not granted via "scope" (space separated) or "scp" (array)
+func missingScopes(claims *jwt.Claims, required []string) []string {
+	if len(required) == 0 {
+		return nil
+	}
+
+	granted := make(map[string]bool)
+	if scope, ok := claims.Custom["scope"].(string); ok {
+		for _, s := range strings.Fields(scope) {
+			granted[s] = true
+		}
+	}
+	if scp, ok := claims.Custom["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if str, ok := s.(string); ok {
+				granted[str] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, s := range required {
+		if !granted[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
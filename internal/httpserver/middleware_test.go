@@ -1,11 +1,21 @@
 package httpserver
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"csce-3550_jwks-srv/internal/jwt"
+	"csce-3550_jwks-srv/internal/keys"
 )
 
 func TestLoggingMiddleware(t *testing.T) {
@@ -78,6 +88,74 @@ func TestCORSOptionsRequest(t *testing.T) {
 	}
 }
 
+func TestCORSMiddlewareOrigins(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	origins := []string{"https://app.example.com", "http://localhost:3000/"}
+	middleware := corsMiddleware(func() []string { return origins })(handler)
+
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{"https://app.example.com", "https://app.example.com"},
+		{"http://localhost:3000", "http://localhost:3000"},
+		{"https://evil.example.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("Origin %q: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.want)
+		}
+		if rr.Header().Get("Vary") != "Origin" {
+			t.Errorf("Origin %q: expected Vary: Origin", tt.origin)
+		}
+	}
+}
+
+func TestLoggingMiddlewareLevel(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	status := http.StatusOK
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+	var level slog.LevelVar
+	middleware := loggingMiddleware(&level)(handler)
+
+	tests := []struct {
+		level  slog.Level
+		status int
+		logged bool
+	}{
+		{slog.LevelInfo, http.StatusOK, true},
+		{slog.LevelWarn, http.StatusOK, false},
+		{slog.LevelWarn, http.StatusNotFound, true},
+		{slog.LevelError, http.StatusNotFound, false},
+		{slog.LevelError, http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		level.Set(tt.level)
+		status = tt.status
+		middleware.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", nil))
+
+		if logged := strings.Contains(out.String(), "GET /test"); logged != tt.logged {
+			t.Errorf("Level %v, status %d: logged = %v, want %v", tt.level, tt.status, logged, tt.logged)
+		}
+	}
+}
+
 func TestContentTypeMiddleware(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -257,3 +335,122 @@ func TestRateLimitMiddleware(t *testing.T) {
 		t.Errorf("First request should pass, got status %v", status)
 	}
 }
+
+func TestRateLimitMiddlewareLiveLimit(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	limit := 1
+	middleware := rateLimitMiddleware(func() int { return limit }, new(slog.LevelVar))(handler)
+
+	serve := func() int {
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/test", nil))
+		return rr.Code
+	}
+
+	if serve() != http.StatusOK || serve() != http.StatusTooManyRequests {
+		t.Fatal("Expected the second request over a limit of 1 to be refused")
+	}
+
+	// a raised limit reaches a client that's already tracked
+	limit = 3
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("Expected request under the raised limit to pass, got %d", code)
+	}
+}
+
+func TestRequireJWT(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	keyFunc := func(kid string) (crypto.PublicKey, error) {
+		if kid != "kid-1" {
+			return nil, keys.ErrKeyNotFound
+		}
+		return &privKey.PublicKey, nil
+	}
+
+	mint := func(b *jwt.ClaimsBuilder) string {
+		claims, err := b.Build()
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		token, err := jwt.Sign(privKey, "RS256", "kid-1", claims)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return token
+	}
+
+	valid := mint(jwt.NewClaims("test-issuer").Subject("7").Audience("api").ExpiresIn(time.Minute).Claim("scope", "read write"))
+	expired := mint(jwt.NewClaims("test-issuer").Audience("api").ExpiresIn(-time.Minute).Claim("scope", "read write"))
+	otherAud := mint(jwt.NewClaims("test-issuer").Audience("other").ExpiresIn(time.Minute).Claim("scope", "read write"))
+	readOnly := mint(jwt.NewClaims("test-issuer").Audience("api").ExpiresIn(time.Minute).Claim("scp", []string{"read"}))
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok {
+			t.Error("Claims not found in context")
+			return
+		}
+		w.Write([]byte(claims.Subject))
+	})
+
+	middleware := RequireJWT(keyFunc, RequireJWTOptions{
+		VerifyOptions:  jwt.VerifyOptions{Issuer: "test-issuer", Audience: []string{"api"}},
+		Realm:          "api",
+		RequiredScopes: []string{"read", "write"},
+	})(handler)
+
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+		expectedHeader string
+	}{
+		{"valid token", "Bearer " + valid, http.StatusOK, ""},
+		{"lowercase scheme", "bearer " + valid, http.StatusOK, ""},
+		{"missing header", "", http.StatusUnauthorized, `Bearer realm="api"`},
+		{"basic scheme", "Basic dXNlcjpwYXNz", http.StatusBadRequest, `Bearer realm="api", error="invalid_request"`},
+		{"empty token", "Bearer ", http.StatusBadRequest, `Bearer realm="api", error="invalid_request"`},
+		{"garbage token", "Bearer abc", http.StatusUnauthorized, `Bearer realm="api", error="invalid_token"`},
+		{"expired token", "Bearer " + expired, http.StatusUnauthorized, `Bearer realm="api", error="invalid_token", error_description="The access token expired"`},
+		{"wrong audience", "Bearer " + otherAud, http.StatusUnauthorized, `Bearer realm="api", error="invalid_token"`},
+		{"insufficient scope", "Bearer " + readOnly, http.StatusForbidden, `Bearer realm="api", error="insufficient_scope"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/resource", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rr := httptest.NewRecorder()
+			middleware.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+
+			challenge := rr.Header().Get("WWW-Authenticate")
+			if !strings.HasPrefix(challenge, tt.expectedHeader) {
+				t.Errorf("Expected WWW-Authenticate to start with %q, got %q", tt.expectedHeader, challenge)
+			}
+
+			if tt.expectedStatus == http.StatusOK && rr.Body.String() != "7" {
+				t.Errorf("Expected subject from context, got %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestClaimsFromContextMissing(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, ok := ClaimsFromContext(req.Context()); ok {
+		t.Error("Expected no claims on a plain request")
+	}
+}
@@ -0,0 +1,202 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"csce-3550_jwks-srv/internal/keys"
+)
+
+var (
+	// ErrRestartRequired rejects a reload that changes a setting only read at startup
+	ErrRestartRequired = errors.New("restart required")
+
+	// ErrConfigNotReloadable is returned for configs not built by LoadConfig
+	ErrConfigNotReloadable = errors.New("config was not loaded from a config file or the environment")
+)
+
+// ConfigChange is a setting whose value differs between two configs
+type ConfigChange struct {
+	Key     string `json:"key"`
+	Old     string `json:"old"` // secrets are redacted
+	New     string `json:"new"`
+	Restart bool   `json:"restart_required"` // can't be applied to a running server
+}
+
+func (c ConfigChange) String() string {
+	change := fmt.Sprintf("%s: %q -> %q", c.Key, c.Old, c.New)
+	if c.Restart {
+		change += " (restart required)"
+	}
+	return change
+}
+
+// ReloadResponse is returned by POST /admin/reload
+type ReloadResponse struct {
+	Applied bool           `json:"applied"`
+	Changes []ConfigChange `json:"changes"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// DiffConfig lists the settings whose values differ between two configs from
+// LoadConfig, in `config print` order
+func DiffConfig(old, new *Config) []ConfigChange {
+	var changes []ConfigChange
+	for _, setting := range settings {
+		before, after := old.values[setting.key].value, new.values[setting.key].value
+		if before == after {
+			continue
+		}
+		if setting.secret {
+			before, after = redactedValue(before), redactedValue(after)
+		}
+		restart := !setting.live || (setting.toggle && (before == "" || after == ""))
+		changes = append(changes, ConfigChange{Key: setting.key, Old: before, New: after, Restart: restart})
+	}
+	return changes
+}
+
+func redactedValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redacted
+}
+
+// ReloadConfig reads the config file and environment again and applies the result.
+// It's all or nothing: if any changed setting needs a restart, or the new config
+// doesn't validate, nothing changes - so the running config is always one that
+// validated as a whole. The changes are returned and logged either way.
+func (s *Server) ReloadConfig() ([]ConfigChange, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	current := s.config.Load()
+	if current.values == nil {
+		return nil, ErrConfigNotReloadable
+	}
+
+	next, err := LoadConfig(current.sources)
+	if err != nil {
+		log.Printf("Config reload rejected: %v", err)
+		return nil, err
+	}
+
+	changes := DiffConfig(current, next)
+	var restart []string
+	for _, change := range changes {
+		log.Printf("Config reload: %s", change)
+		if change.Restart {
+			restart = append(restart, change.Key)
+		}
+	}
+	if len(restart) > 0 {
+		err := fmt.Errorf("%w: %s changed", ErrRestartRequired, strings.Join(restart, ", "))
+		log.Printf("Config reload rejected: %v", err)
+		return changes, err
+	}
+	if len(changes) == 0 {
+		log.Printf("Config reload: no changes")
+		return nil, nil
+	}
+
+	// a renamed certificate must load before anything changes
+	if s.certs != nil {
+		if err := s.certs.setFiles(next.TLSCertFile, next.TLSKeyFile); err != nil {
+			log.Printf("Config reload rejected: %v", err)
+			return changes, &ValidationError{Errors: []*FieldError{{
+				Key:    "tls_cert_file",
+				Value:  next.TLSCertFile,
+				Source: next.values["tls_cert_file"].source,
+				Kind:   ErrInvalidSetting,
+				Err:    err,
+			}}}
+		}
+	}
+
+	// config first - a server unsealing right now configures its manager from it
+	s.config.Store(next)
+	s.logLevel.Set(next.LogLevel)
+	if manager := s.manager.Load(); manager != nil {
+		if err := ConfigureManager(manager, next); err != nil {
+			return changes, fmt.Errorf("failed to apply key policy: %w", err)
+		}
+	}
+
+	log.Printf("Config reload applied %d change(s)", len(changes))
+	return changes, nil
+}
+
+// ConfigureManager applies the config's key policy - algorithm, lifetimes, rotation
+// timing and cleanup - to manager, before Start or while it runs
+func ConfigureManager(manager *keys.Manager, config *Config) error {
+	// algorithm policy for newly generated keys
+	if err := manager.SetAlgorithm(config.SigningAlgorithm); err != nil {
+		return err
+	}
+	if err := manager.SetRSAKeySize(config.RSAKeySize); err != nil {
+		return err
+	}
+
+	// rotate every key lifetime, purge keys past KEY_RETAIN
+	if err := manager.SetLifetimes(config.KeyLifetime, config.KeyRetainPeriod); err != nil {
+		return err
+	}
+
+	// pre-publish new keys for the lead time, keep replaced keys until their tokens expire
+	if err := manager.SetRotationTiming(config.KeyLeadTime, config.JWTLifetime); err != nil {
+		return err
+	}
+
+	// purge on the configured cadence
+	return manager.SetCleanupPolicy(config.KeyCleanup, config.KeyPurgeDryRun)
+}
+
+// reload endpoint handler - POST /admin/reload re-reads the config and reports
+// what changed. Only exists when ADMIN_TOKEN is set.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	token := s.config.Load().AdminToken
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !validBearerToken(r, token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	changes, err := s.ReloadConfig()
+	response := ReloadResponse{Applied: err == nil && len(changes) > 0, Changes: changes}
+	if response.Changes == nil {
+		response.Changes = []ConfigChange{}
+	}
+
+	code := http.StatusOK
+	if err != nil {
+		response.Error = err.Error()
+		var invalid *ValidationError
+		switch {
+		case errors.As(err, &invalid):
+			code = http.StatusUnprocessableEntity
+		case errors.Is(err, ErrRestartRequired):
+			code = http.StatusConflict
+		default:
+			code = http.StatusInternalServerError
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,233 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"csce-3550_jwks-srv/internal/db"
+	"csce-3550_jwks-srv/internal/keys"
+)
+
+const testAdminToken = "test-admin-token"
+
+const reloadBaseConfig = `
+issuer: first-issuer
+rate_limit: 5
+key_lifetime: 10m
+admin_token: test-admin-token
+`
+
+// server on a memory store, loaded from a config file the test rewrites
+func newReloadSrv(t *testing.T, content string) (*Server, string) {
+	t.Helper()
+	clearConfigEnv(t)
+	t.Setenv("NOT_MY_KEY", "test-encryption-key-123")
+	path := writeConfigFile(t, "config.yaml", content)
+
+	config, err := LoadConfig(ConfigSources{File: path})
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	manager := keys.NewManagerWithStore(db.NewMemoryStore(), config.KeyLifetime, config.KeyRetainPeriod)
+	return NewSrv(manager, config), path
+}
+
+func rewriteConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to rewrite config file: %v", err)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("NOT_MY_KEY", "old-secret")
+	old, err := LoadConfig(ConfigSources{})
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	t.Setenv("NOT_MY_KEY", "new-secret")
+	t.Setenv("JWT_LIFETIME", "2m")
+	new, err := LoadConfig(ConfigSources{Flags: map[string]string{"listen_addr": ":9090"}})
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	changes := DiffConfig(old, new)
+	want := []ConfigChange{
+		{Key: "listen_addr", Old: ":8080", New: ":9090", Restart: true},
+		{Key: "jwt_lifetime", Old: "5m", New: "2m"},
+		{Key: "not_my_key", Old: redacted, New: redacted, Restart: true},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %v", len(want), changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("Change %d = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+
+	if len(DiffConfig(old, old)) != 0 {
+		t.Error("Expected no changes comparing a config with itself")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	server, path := newReloadSrv(t, reloadBaseConfig)
+
+	if changes, err := server.ReloadConfig(); err != nil || len(changes) != 0 {
+		t.Fatalf("Expected an unchanged reload, got %v/%v", changes, err)
+	}
+
+	rewriteConfigFile(t, path, `
+issuer: https://auth.example.com
+rate_limit: 7
+key_lifetime: 30m
+jwt_lifetime: 2m
+log_level: warn
+cors_origins: [https://app.example.com]
+admin_token: test-admin-token
+`)
+	changes, err := server.ReloadConfig()
+	if err != nil {
+		t.Fatalf("ReloadConfig() error = %v", err)
+	}
+	if len(changes) != 6 {
+		t.Errorf("Expected 6 changes, got %v", changes)
+	}
+
+	config := server.Config()
+	if config.Issuer != "https://auth.example.com" || config.RateLimit != 7 || config.KeyLifetime != 30*time.Minute {
+		t.Errorf("Reload not applied: %+v", config)
+	}
+	if server.rateLimit() != 7 || server.logLevel.Level() != slog.LevelWarn {
+		t.Errorf("Expected live rate limit 7 and warn logging, got %d/%v", server.rateLimit(), server.logLevel.Level())
+	}
+	if origins := server.corsOrigins(); len(origins) != 1 || origins[0] != "https://app.example.com" {
+		t.Errorf("Expected reloaded CORS origins, got %v", origins)
+	}
+
+	// restart-only settings reject the whole reload
+	rewriteConfigFile(t, path, `
+issuer: third-issuer
+listen_addr: ":9090"
+admin_token: test-admin-token
+`)
+	changes, err = server.ReloadConfig()
+	if !errors.Is(err, ErrRestartRequired) {
+		t.Fatalf("Expected ErrRestartRequired, got %v", err)
+	}
+	restart := 0
+	for _, change := range changes {
+		if change.Restart {
+			restart++
+			if change.Key != "listen_addr" {
+				t.Errorf("Unexpected restart-only change %+v", change)
+			}
+		}
+	}
+	if restart != 1 {
+		t.Errorf("Expected listen_addr to need a restart, got %v", changes)
+	}
+	if server.Config().Issuer != "https://auth.example.com" {
+		t.Errorf("Rejected reload changed the issuer to %q", server.Config().Issuer)
+	}
+
+	// so do invalid files
+	rewriteConfigFile(t, path, "issuer: [\n")
+	if _, err := server.ReloadConfig(); err == nil {
+		t.Error("Expected error for an unparseable config file")
+	}
+	rewriteConfigFile(t, path, "jwt_lifetime: 100h\nadmin_token: test-admin-token\n")
+	var invalid *ValidationError
+	if _, err := server.ReloadConfig(); !errors.As(err, &invalid) {
+		t.Errorf("Expected *ValidationError, got %v", err)
+	}
+	if server.Config().JWTLifetime != 2*time.Minute {
+		t.Errorf("Invalid reload changed JWT lifetime to %v", server.Config().JWTLifetime)
+	}
+}
+
+func TestReloadConfigHandBuilt(t *testing.T) {
+	manager := keys.NewManagerWithStore(db.NewMemoryStore(), time.Hour, time.Hour)
+	server := NewSrv(manager, &Config{Issuer: "test-issuer"})
+
+	if _, err := server.ReloadConfig(); !errors.Is(err, ErrConfigNotReloadable) {
+		t.Errorf("Expected ErrConfigNotReloadable, got %v", err)
+	}
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	server, path := newReloadSrv(t, reloadBaseConfig)
+
+	tests := []struct {
+		name     string
+		method   string
+		token    string
+		expected int
+	}{
+		{"without token", http.MethodPost, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "nope", http.StatusUnauthorized},
+		{"wrong method", http.MethodGet, testAdminToken, http.StatusMethodNotAllowed},
+		{"unchanged", http.MethodPost, testAdminToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := sealRequest(t, server, tt.method, "/admin/reload", tt.token, "")
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	reload := func(content string, expected int) ReloadResponse {
+		t.Helper()
+		rewriteConfigFile(t, path, content)
+		w := sealRequest(t, server, http.MethodPost, "/admin/reload", testAdminToken, "")
+		if w.Code != expected {
+			t.Fatalf("Expected status %d, got %d: %s", expected, w.Code, w.Body.String())
+		}
+		var response ReloadResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode reload response: %v", err)
+		}
+		return response
+	}
+
+	response := reload("issuer: second-issuer\nrate_limit: 5\nadmin_token: test-admin-token\n", http.StatusOK)
+	if !response.Applied || len(response.Changes) != 1 || server.Config().Issuer != "second-issuer" {
+		t.Errorf("Expected the issuer change applied, got %+v", response)
+	}
+
+	response = reload("issuer: second-issuer\nrate_limit: 5\ndb_path: /tmp/other.db\nadmin_token: test-admin-token\n", http.StatusConflict)
+	if response.Applied || response.Error == "" {
+		t.Errorf("Expected rejected reload, got %+v", response)
+	}
+
+	response = reload("issuer: second-issuer\nrate_limit: 0\nadmin_token: test-admin-token\n", http.StatusUnprocessableEntity)
+	if response.Applied || response.Error == "" {
+		t.Errorf("Expected invalid reload, got %+v", response)
+	}
+
+	// the new token takes over once applied
+	reload("issuer: second-issuer\nrate_limit: 5\nadmin_token: rotated-token\n", http.StatusOK)
+	if w := sealRequest(t, server, http.MethodPost, "/admin/reload", testAdminToken, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old admin token to be rejected, got %d", w.Code)
+	}
+}
+
+func TestReloadEndpointDisabled(t *testing.T) {
+	manager := keys.NewManagerWithStore(db.NewMemoryStore(), time.Hour, time.Hour)
+	server := NewSrv(manager, &Config{})
+
+	if w := sealRequest(t, server, http.MethodPost, "/admin/reload", testAdminToken, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without ADMIN_TOKEN, got %d", w.Code)
+	}
+}
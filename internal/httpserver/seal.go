@@ -0,0 +1,233 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"csce-3550_jwks-srv/internal/crypto"
+	"csce-3550_jwks-srv/internal/db"
+	"csce-3550_jwks-srv/internal/keys"
+)
+
+// UnsealFunc opens the key store with the recovered master secret and returns a
+// started key manager. db.ErrWrongMasterKey rejects the secret.
+type UnsealFunc func(secret string) (*keys.Manager, error)
+
+// sealState tracks a server started without its master secret
+type sealState struct {
+	mu         sync.Mutex // serialises unseal attempts
+	unseal     UnsealFunc
+	shares     [][]byte // Shamir shares submitted so far
+	cachedJWKS []byte   // JWKS saved before the last shutdown, nil if none
+}
+
+// UnsealRequest is the body of POST /admin/unseal - Key when UNSEAL_THRESHOLD is 1,
+// otherwise one base64 Shamir share per request
+type UnsealRequest struct {
+	Key   string `json:"key,omitempty"`
+	Share string `json:"share,omitempty"`
+}
+
+// SealStatus is returned by /admin/unseal
+type SealStatus struct {
+	Sealed    bool `json:"sealed"`
+	Progress  int  `json:"progress"`  // shares submitted towards the threshold
+	Threshold int  `json:"threshold"` // shares needed
+}
+
+// NewSealedSrv creates a server that has no master secret yet. Until it's unsealed
+// it answers /health, serves cachedJWKS (if any) and refuses /auth and /register.
+// unseal runs once the secret, or UnsealThreshold shares of it, arrive.
+func NewSealedSrv(config *Config, cachedJWKS []byte, unseal UnsealFunc) *Server {
+	srv := newSrv(config)
+	srv.seal = &sealState{
+		unseal:     unseal,
+		cachedJWKS: cachedJWKS,
+	}
+	return srv
+}
+
+// Sealed reports whether the server is still waiting for its master secret
+func (s *Server) Sealed() bool {
+	return s.manager.Load() == nil
+}
+
+// Manager returns the key manager, nil while sealed
+func (s *Server) Manager() *keys.Manager {
+	return s.manager.Load()
+}
+
+// refuse handlers that need private keys or the store while sealed
+func (s *Server) requireUnsealed(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Sealed() {
+			http.Error(w, "Server is sealed", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// read-only JWKS from before the restart - lets verifiers keep working while sealed
+func (s *Server) handleSealedJWKS(w http.ResponseWriter) {
+	if s.seal == nil || s.seal.cachedJWKS == nil {
+		http.Error(w, "Server is sealed", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(s.seal.cachedJWKS)
+}
+
+// health endpoint handler - GET /health, 200 whether or not sealed
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := "ok"
+	if s.Sealed() {
+		status = "sealed"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"sealed": s.Sealed(),
+	})
+}
+
+// unseal endpoint handler - GET /admin/unseal reports progress, POST submits the
+// secret or a share. Only exists on servers started sealed.
+func (s *Server) handleUnseal(w http.ResponseWriter, r *http.Request) {
+	if s.seal == nil || s.config.Load().UnsealToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.validUnsealToken(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="unseal"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.seal.mu.Lock()
+		s.writeSealStatus(w, http.StatusOK)
+		s.seal.mu.Unlock()
+	case http.MethodPost:
+		s.submitUnseal(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) validUnsealToken(r *http.Request) bool {
+	return validBearerToken(r, s.config.Load().UnsealToken)
+}
+
+// bearer token check in constant time
+func validBearerToken(r *http.Request, want string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// take the secret or one share - unseal once the threshold is reached
+func (s *Server) submitUnseal(w http.ResponseWriter, r *http.Request) {
+	s.seal.mu.Lock()
+	defer s.seal.mu.Unlock()
+
+	if !s.Sealed() {
+		http.Error(w, "Server is already unsealed", http.StatusConflict)
+		return
+	}
+
+	var req UnsealRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var secret string
+	if s.config.Load().UnsealThreshold <= 1 {
+		if req.Key == "" {
+			http.Error(w, "key is required", http.StatusBadRequest)
+			return
+		}
+		secret = req.Key
+	} else {
+		share, err := base64.StdEncoding.DecodeString(req.Share)
+		if err != nil || len(share) < 2 {
+			http.Error(w, "share must be a base64 secret share", http.StatusBadRequest)
+			return
+		}
+		for _, submitted := range s.seal.shares {
+			if bytes.Equal(submitted, share) {
+				http.Error(w, "Share already submitted", http.StatusBadRequest)
+				return
+			}
+		}
+
+		s.seal.shares = append(s.seal.shares, share)
+		if len(s.seal.shares) < s.config.Load().UnsealThreshold {
+			s.writeSealStatus(w, http.StatusAccepted)
+			return
+		}
+
+		// a failed combine or unseal starts over - one bad share would poison any retry
+		combined, err := crypto.CombineShares(s.seal.shares)
+		s.seal.shares = nil
+		if err != nil {
+			log.Printf("unseal failed: %v", err)
+			http.Error(w, "Unseal failed: shares do not combine", http.StatusBadRequest)
+			return
+		}
+		secret = string(combined)
+	}
+
+	manager, err := s.seal.unseal(secret)
+	if err != nil {
+		log.Printf("unseal failed: %v", err)
+		if errors.Is(err, db.ErrWrongMasterKey) {
+			http.Error(w, "Unseal failed: wrong master key", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Unseal failed", http.StatusInternalServerError)
+		return
+	}
+
+	// the config may have been reloaded since unseal's copy was taken
+	if err := ConfigureManager(manager, s.config.Load()); err != nil {
+		log.Printf("unseal: failed to apply key policy: %v", err)
+	}
+	s.manager.Store(manager)
+	log.Printf("server unsealed")
+	s.writeSealStatus(w, http.StatusOK)
+}
+
+// current progress - callers hold seal.mu
+func (s *Server) writeSealStatus(w http.ResponseWriter, code int) {
+	status := SealStatus{
+		Sealed:    s.Sealed(),
+		Progress:  len(s.seal.shares),
+		Threshold: s.config.Load().UnsealThreshold,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,212 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"csce-3550_jwks-srv/internal/crypto"
+	"csce-3550_jwks-srv/internal/db"
+	"csce-3550_jwks-srv/internal/keys"
+)
+
+const testUnsealToken = "test-unseal-token"
+
+// sealed server whose unseal accepts only secret, backed by a memory store
+func newTestSealedSrv(t *testing.T, secret string, threshold int, cachedJWKS []byte) *Server {
+	t.Helper()
+	config := &Config{
+		KeyLifetime:     time.Hour,
+		KeyRetainPeriod: time.Hour,
+		JWTLifetime:     5 * time.Minute,
+		Issuer:          "test-issuer",
+		Sealed:          true,
+		UnsealToken:     testUnsealToken,
+		UnsealThreshold: threshold,
+	}
+
+	server := NewSealedSrv(config, cachedJWKS, func(got string) (*keys.Manager, error) {
+		if got != secret {
+			return nil, db.ErrWrongMasterKey
+		}
+		manager := keys.NewManagerWithStore(db.NewMemoryStore(), config.KeyLifetime, config.KeyRetainPeriod)
+		if err := manager.Start(); err != nil {
+			return nil, err
+		}
+		t.Cleanup(manager.Stop)
+		return manager, nil
+	})
+	return server
+}
+
+// request from a per-test client address so the auth rate limiter stays out of the way
+func sealRequest(t *testing.T, server *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Real-IP", "seal-"+t.Name())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, req)
+	return w
+}
+
+func decodeSealStatus(t *testing.T, w *httptest.ResponseRecorder) SealStatus {
+	t.Helper()
+	var status SealStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode seal status: %v", err)
+	}
+	return status
+}
+
+func TestSealedServer(t *testing.T) {
+	cached := []byte(`{"keys":[{"kid":"cached"}]}` + "\n")
+	server := newTestSealedSrv(t, "master-secret", 1, cached)
+
+	if !server.Sealed() || server.Manager() != nil {
+		t.Fatal("Expected server to start sealed")
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		body     string
+		expected int
+	}{
+		{"health while sealed", http.MethodGet, "/health", "", "", http.StatusOK},
+		{"cached JWKS", http.MethodGet, "/jwks", "", "", http.StatusOK},
+		{"auth refused", http.MethodPost, "/auth", "", "", http.StatusServiceUnavailable},
+		{"register refused", http.MethodPost, "/register", "", `{"username":"u","email":"e"}`, http.StatusServiceUnavailable},
+		{"unseal without token", http.MethodPost, "/admin/unseal", "", `{"key":"master-secret"}`, http.StatusUnauthorized},
+		{"unseal with wrong token", http.MethodPost, "/admin/unseal", "nope", `{"key":"master-secret"}`, http.StatusUnauthorized},
+		{"unseal without key", http.MethodPost, "/admin/unseal", testUnsealToken, `{}`, http.StatusBadRequest},
+		{"unseal with wrong key", http.MethodPost, "/admin/unseal", testUnsealToken, `{"key":"wrong"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := sealRequest(t, server, tt.method, tt.path, tt.token, tt.body)
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if w := sealRequest(t, server, http.MethodGet, "/jwks", "", ""); w.Body.String() != string(cached) {
+		t.Errorf("Expected cached JWKS while sealed, got %s", w.Body.String())
+	}
+	if w := sealRequest(t, server, http.MethodGet, "/health", "", ""); !strings.Contains(w.Body.String(), `"sealed":true`) {
+		t.Errorf("Expected health to report sealed, got %s", w.Body.String())
+	}
+
+	w := sealRequest(t, server, http.MethodPost, "/admin/unseal", testUnsealToken, `{"key":"master-secret"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected unseal to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if status := decodeSealStatus(t, w); status.Sealed {
+		t.Error("Expected unsealed status")
+	}
+	if server.Sealed() {
+		t.Fatal("Expected server to be unsealed")
+	}
+
+	// live JWKS replaces the cached one
+	w = sealRequest(t, server, http.MethodGet, "/jwks", "", "")
+	if w.Code != http.StatusOK || w.Body.String() == string(cached) {
+		t.Errorf("Expected live JWKS after unseal, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := sealRequest(t, server, http.MethodPost, "/auth", "", ""); w.Code == http.StatusServiceUnavailable {
+		t.Error("Expected /auth to be served after unseal")
+	}
+	if w := sealRequest(t, server, http.MethodPost, "/admin/unseal", testUnsealToken, `{"key":"master-secret"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 unsealing twice, got %d", w.Code)
+	}
+}
+
+func TestSealedServerWithoutCachedJWKS(t *testing.T) {
+	server := newTestSealedSrv(t, "master-secret", 1, nil)
+
+	if w := sealRequest(t, server, http.MethodGet, "/jwks", "", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a cached JWKS, got %d", w.Code)
+	}
+}
+
+func TestUnsealWithShares(t *testing.T) {
+	secret := "master-secret"
+	server := newTestSealedSrv(t, secret, 3, nil)
+
+	shares, err := crypto.SplitSecret([]byte(secret), 5, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret() error = %v", err)
+	}
+	submit := func(share []byte) *httptest.ResponseRecorder {
+		body := `{"share":"` + base64.StdEncoding.EncodeToString(share) + `"}`
+		return sealRequest(t, server, http.MethodPost, "/admin/unseal", testUnsealToken, body)
+	}
+
+	// shares from another split combine to the wrong secret - progress resets
+	others, err := crypto.SplitSecret([]byte("other-secret!"), 3, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret() error = %v", err)
+	}
+	for _, share := range others[:2] {
+		if w := submit(share); w.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202 below threshold, got %d", w.Code)
+		}
+	}
+	if w := submit(others[2]); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for wrong shares, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := submit(shares[4])
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 below threshold, got %d", w.Code)
+	}
+	if status := decodeSealStatus(t, w); !status.Sealed || status.Progress != 1 || status.Threshold != 3 {
+		t.Errorf("Expected progress 1/3 after reset, got %+v", status)
+	}
+
+	if w := submit(shares[4]); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a duplicate share, got %d", w.Code)
+	}
+	if w := sealRequest(t, server, http.MethodPost, "/admin/unseal", testUnsealToken, `{"share":"not base64!"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed share, got %d", w.Code)
+	}
+
+	if w := submit(shares[1]); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 below threshold, got %d", w.Code)
+	}
+
+	// progress is visible to operators
+	w = sealRequest(t, server, http.MethodGet, "/admin/unseal", testUnsealToken, "")
+	if status := decodeSealStatus(t, w); status.Progress != 2 {
+		t.Errorf("Expected progress 2, got %+v", status)
+	}
+
+	if w := submit(shares[2]); w.Code != http.StatusOK {
+		t.Fatalf("Expected unseal at threshold, got %d: %s", w.Code, w.Body.String())
+	}
+	if server.Sealed() {
+		t.Error("Expected server to be unsealed")
+	}
+}
+
+func TestUnsealEndpointRequiresSealedServer(t *testing.T) {
+	manager := keys.NewManagerWithStore(db.NewMemoryStore(), time.Hour, time.Hour)
+	server := NewSrv(manager, &Config{UnsealToken: testUnsealToken})
+
+	if w := sealRequest(t, server, http.MethodPost, "/admin/unseal", testUnsealToken, `{"key":"x"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 on a server started unsealed, got %d", w.Code)
+	}
+	if w := sealRequest(t, server, http.MethodGet, "/health", "", ""); !strings.Contains(w.Body.String(), `"status":"ok"`) {
+		t.Errorf("Expected health ok, got %s", w.Body.String())
+	}
+}
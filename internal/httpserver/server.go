@@ -2,52 +2,131 @@ package httpserver
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"csce-3550_jwks-srv/internal/keys"
 )
 
 // SRV wrapper
 type Server struct {
-	httpServer *http.Server
-	config     *Config
-	manager    *keys.Manager
+	httpServer  *http.Server
+	config      atomic.Pointer[Config]       // swapped whole by ReloadConfig
+	manager     atomic.Pointer[keys.Manager] // nil while sealed
+	seal        *sealState                   // nil unless started sealed
+	authLimiter *rateLimiter                 // shared by /auth and the admin endpoints
+	logLevel    slog.LevelVar
+	reloadMu    sync.Mutex    // one reload at a time
+	certs       *certReloader // nil unless serving HTTPS
+	redirect    *http.Server  // plain HTTP to HTTPS redirects, nil if not configured
 }
 
 // srv creations
 func NewSrv(manager *keys.Manager, config *Config) *Server {
-	srv := &Server{
-		config:  config,
-		manager: manager,
+	srv := newSrv(config)
+	srv.manager.Store(manager)
+	return srv
+}
+
+// server without a key manager - NewSrv sets one, NewSealedSrv waits for unseal
+func newSrv(config *Config) *Server {
+	srv := &Server{}
+	srv.authLimiter = &rateLimiter{
+		visitors: make(map[string]*visitor),
+		logLevel: &srv.logLevel,
 	}
+	srv.config.Store(config)
+	srv.logLevel.Set(config.LogLevel)
 
 	mux := http.NewServeMux()
 
 	// route regs w/ middleware
 	mux.Handle("/jwks", srv.applyMiddleware(srv.handleJWKS))
 	mux.Handle("/.well-known/jwks.json", srv.applyMiddleware(srv.handleJWKS))
-	mux.Handle("/auth", srv.applyAuthMiddleware(srv.handleAuth)) // special rate limiting for auth
-	mux.Handle("/register", srv.applyMiddleware(srv.handleRegister))
+	mux.Handle("/auth", srv.applyAuthMiddleware(srv.requireUnsealed(srv.handleAuth))) // special rate limiting for auth
+	mux.Handle("/register", srv.applyMiddleware(srv.requireUnsealed(srv.handleRegister)))
+	mux.Handle("/health", srv.applyMiddleware(srv.handleHealth))
+	mux.Handle("/admin/unseal", srv.applyAuthMiddleware(srv.handleUnseal)) // auth rate limit slows token guessing
+	mux.Handle("/admin/reload", srv.applyAuthMiddleware(srv.handleReload))
 
 	srv.httpServer = &http.Server{
+		Addr:         orDefault(config.ListenAddr, defaultListenAddr),
 		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  orDefault(config.ReadTimeout, defaultReadTimeout),
+		WriteTimeout: orDefault(config.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:  orDefault(config.IdleTimeout, defaultIdleTimeout),
+	}
+
+	if config.TLSCertFile != "" {
+		srv.certs = newCertReloader(config.TLSCertFile, config.TLSKeyFile)
+		if config.TLSRedirectAddr != "" {
+			srv.redirect = &http.Server{
+				Addr:         config.TLSRedirectAddr,
+				ReadTimeout:  srv.httpServer.ReadTimeout,
+				WriteTimeout: srv.httpServer.WriteTimeout,
+				IdleTimeout:  srv.httpServer.IdleTimeout,
+			}
+		}
 	}
 
 	return srv
 }
 
-// waiter for srv
+// waiter for srv - empty addr uses the configured listen address
 func (s *Server) Waiter(addr string) error {
-	s.httpServer.Addr = addr
-	return s.httpServer.ListenAndServe()
+	if addr != "" {
+		s.httpServer.Addr = addr
+	}
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve answers on ln - HTTPS when a TLS certificate is configured, along with the
+// redirect listener if there is one
+func (s *Server) Serve(ln net.Listener) error {
+	if s.certs == nil {
+		return s.httpServer.Serve(ln)
+	}
+
+	tlsConfig, err := s.newTLSConfig()
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	s.httpServer.TLSConfig = tlsConfig
+
+	if s.redirect != nil {
+		s.redirect.Handler = redirectHandler(ln.Addr().String())
+		go func() {
+			if err := s.redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("HTTPS redirect listener error: %v", err)
+			}
+		}()
+	}
+	return s.httpServer.ServeTLS(ln, "", "")
+}
+
+// TLS reports whether the server answers over HTTPS
+func (s *Server) TLS() bool {
+	return s.certs != nil
 }
 
 // graceful death
 func (s *Server) Death(ctx context.Context) error {
+	if s.redirect != nil {
+		if err := s.redirect.Shutdown(ctx); err != nil {
+			return fmt.Errorf("redirect listener: %w", err)
+		}
+	}
 	return s.httpServer.Shutdown(ctx)
 }
 
@@ -55,3 +134,35 @@ func (s *Server) Death(ctx context.Context) error {
 func (s *Server) Handler() http.Handler {
 	return s.httpServer.Handler
 }
+
+// Config returns the config in effect, including changes applied by ReloadConfig
+func (s *Server) Config() *Config {
+	return s.config.Load()
+}
+
+// configured rate limits - zero in hand-built configs means the default.
+// Read per request so reloads apply to the next one.
+func (s *Server) rateLimit() int {
+	return orDefault(s.config.Load().RateLimit, defaultRateLimit)
+}
+
+func (s *Server) authRateLimit() int {
+	return orDefault(s.config.Load().AuthRateLimit, defaultAuthRateLimit)
+}
+
+// allowed CORS origins - any when unset
+func (s *Server) corsOrigins() []string {
+	if origins := s.config.Load().CORSOrigins; len(origins) > 0 {
+		return origins
+	}
+	return []string{"*"}
+}
+
+// value unless it's the zero value
+func orDefault[T comparable](value, def T) T {
+	var zero T
+	if value == zero {
+		return def
+	}
+	return value
+}
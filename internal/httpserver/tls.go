@@ -0,0 +1,158 @@
+package httpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often handshakes look for a renewed certificate on disk
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate in certFile/keyFile and loads it again when
+// either file changes - renewals are picked up without a restart. A pair that
+// doesn't load (say the cert is written before its key) keeps the old one serving.
+type certReloader struct {
+	mu        sync.Mutex
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+	interval  time.Duration
+}
+
+func newCertReloader(certFile, keyFile string) *certReloader {
+	return &certReloader{certFile: certFile, keyFile: keyFile, interval: certCheckInterval}
+}
+
+// load reads the pair now - used at startup
+func (r *certReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked(r.certFile, r.keyFile)
+}
+
+// setFiles switches to another pair, keeping the current one if it doesn't load
+func (r *certReloader) setFiles(certFile, keyFile string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if certFile == r.certFile && keyFile == r.keyFile {
+		return nil
+	}
+	return r.loadLocked(certFile, keyFile)
+}
+
+func (r *certReloader) loadLocked(certFile, keyFile string) error {
+	certMod, keyMod, err := modTimes(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.certFile, r.keyFile = certFile, keyFile
+	r.cert = &cert
+	r.certMod, r.keyMod = certMod, keyMod
+	r.lastCheck = time.Now()
+	return nil
+}
+
+// GetCertificate is the tls.Config hook - checks the files at most once an interval
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= r.interval {
+		r.lastCheck = time.Now()
+		certMod, keyMod, err := modTimes(r.certFile, r.keyFile)
+		if err == nil && (!certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)) {
+			if err := r.loadLocked(r.certFile, r.keyFile); err != nil {
+				log.Printf("TLS certificate reload failed, still serving the previous one: %v", err)
+			} else {
+				log.Printf("TLS certificate reloaded from %s", r.certFile)
+			}
+		}
+	}
+
+	if r.cert == nil {
+		return nil, errors.New("no TLS certificate loaded")
+	}
+	return r.cert, nil
+}
+
+func modTimes(certFile, keyFile string) (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to read TLS key: %w", err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// TLS policy from the config - loads the certificate and client CA bundle
+func (s *Server) newTLSConfig() (*tls.Config, error) {
+	config := s.config.Load()
+	if err := s.certs.load(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     orDefault(config.TLSMinVersion, defaultTLSMinVersion),
+		CipherSuites:   config.TLSCipherSuites,
+		GetCertificate: s.certs.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if config.TLSClientCAFile != "" {
+		bundle, err := os.ReadFile(config.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates in client CA bundle %s", config.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = orDefault(config.TLSClientAuth, tls.RequireAndVerifyClientCert)
+	}
+	return tlsConfig, nil
+}
+
+// redirectHandler sends every plain HTTP request to the same URL over HTTPS on
+// the port of httpsAddr. 308 keeps the method and body.
+func redirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "" {
+			http.Error(w, "Host header required", http.StatusBadRequest)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]") // no port - brackets go back on below
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,375 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"csce-3550_jwks-srv/internal/db"
+	"csce-3550_jwks-srv/internal/keys"
+)
+
+// certificate and key written as PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+var testSerial int64
+
+// self-signed when parent is nil, otherwise signed by parent. Leaves are valid
+// for 127.0.0.1 and usable by servers and clients.
+func newTestCert(t *testing.T, dir, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	testSerial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	tc.write(t, der, keyDER)
+	return tc
+}
+
+func (tc *testCert) write(t *testing.T, certDER, keyDER []byte) {
+	t.Helper()
+	if err := os.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+// copy other's files over tc's and push the mod times forward so a reload sees them
+func (tc *testCert) replaceWith(t *testing.T, other *testCert) {
+	t.Helper()
+	for src, dst := range map[string]string{other.certFile: tc.certFile, other.keyFile: tc.keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", src, err)
+		}
+		if err := os.WriteFile(dst, data, 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", dst, err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(dst, later, later); err != nil {
+			t.Fatalf("Failed to touch %s: %v", dst, err)
+		}
+	}
+}
+
+// start server on a random local port, returning its https base URL
+func serveTLS(t *testing.T, server *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ln) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Death(ctx)
+		<-done
+	})
+	return "https://" + ln.Addr().String()
+}
+
+// client trusting roots, presenting certs - a fresh connection per request
+func tlsClient(roots []*x509.Certificate, certs ...*testCert) *http.Client {
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	config := &tls.Config{RootCAs: pool}
+	if len(certs) > 0 {
+		// always present it - Certificates would be skipped when the server's CAs don't match
+		cert := &tls.Certificate{Certificate: [][]byte{certs[0].cert.Raw}, PrivateKey: certs[0].key}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert, nil
+		}
+	}
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true},
+	}
+}
+
+func newTLSSrv(config *Config) *Server {
+	manager := keys.NewManagerWithStore(db.NewMemoryStore(), time.Hour, time.Hour)
+	return NewSrv(manager, config)
+}
+
+// serial of the certificate the server presented
+func servedSerial(t *testing.T, client *http.Client, url string) int64 {
+	t.Helper()
+	resp, err := client.Get(url + "/health")
+	if err != nil {
+		t.Fatalf("GET /health error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert := newTestCert(t, dir, "server", nil, false)
+
+	server := newTLSSrv(&Config{TLSCertFile: cert.certFile, TLSKeyFile: cert.keyFile, TLSMinVersion: tls.VersionTLS13})
+	if !server.TLS() {
+		t.Fatal("Expected a TLS server")
+	}
+	url := serveTLS(t, server)
+
+	client := tlsClient([]*x509.Certificate{cert.cert})
+	resp, err := client.Get(url + "/health")
+	if err != nil {
+		t.Fatalf("GET /health error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("Expected a TLS 1.3 connection, got %+v", resp.TLS)
+	}
+	if resp.Header.Get("Strict-Transport-Security") == "" {
+		t.Error("Expected HSTS over HTTPS")
+	}
+
+	// clients capped below the minimum version are refused
+	old := tlsClient([]*x509.Certificate{cert.cert})
+	old.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+	if _, err := old.Get(url + "/health"); err == nil {
+		t.Error("Expected a TLS 1.2 client to be refused")
+	}
+
+	// plain HTTP isn't answered
+	if resp, err := http.Get("http" + url[len("https"):] + "/health"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Error("Expected plain HTTP to be refused on the HTTPS port")
+		}
+	}
+}
+
+func TestServeTLSBadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	server := newTLSSrv(&Config{TLSCertFile: filepath.Join(dir, "missing.crt"), TLSKeyFile: filepath.Join(dir, "missing.key")})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	if err := server.Serve(ln); err == nil || errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Expected Serve to fail without a certificate, got %v", err)
+	}
+}
+
+func TestCertificateHotReload(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, dir, "server", nil, false)
+	second := newTestCert(t, dir, "renewed", nil, false)
+
+	server := newTLSSrv(&Config{TLSCertFile: first.certFile, TLSKeyFile: first.keyFile})
+	server.certs.interval = 0 // look on every handshake
+	url := serveTLS(t, server)
+
+	client := tlsClient([]*x509.Certificate{first.cert, second.cert})
+	if serial := servedSerial(t, client, url); serial != first.cert.SerialNumber.Int64() {
+		t.Fatalf("Expected the first certificate, got serial %d", serial)
+	}
+
+	first.replaceWith(t, second)
+	if serial := servedSerial(t, client, url); serial != second.cert.SerialNumber.Int64() {
+		t.Fatalf("Expected the renewed certificate, got serial %d", serial)
+	}
+
+	// a half-written renewal keeps the last good certificate serving
+	if err := os.WriteFile(first.certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	later := time.Now().Add(2 * time.Minute)
+	os.Chtimes(first.certFile, later, later)
+	if serial := servedSerial(t, client, url); serial != second.cert.SerialNumber.Int64() {
+		t.Errorf("Expected the renewed certificate to keep serving, got serial %d", serial)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil, true)
+	serverCert := newTestCert(t, dir, "server", ca, false)
+	clientCert := newTestCert(t, dir, "client", ca, false)
+	stranger := newTestCert(t, dir, "stranger", nil, false)
+
+	tests := []struct {
+		name   string
+		auth   tls.ClientAuthType
+		client *testCert
+		wantOK bool
+	}{
+		{"require with client cert", tls.RequireAndVerifyClientCert, clientCert, true},
+		{"require without client cert", tls.RequireAndVerifyClientCert, nil, false},
+		{"require with untrusted cert", tls.RequireAndVerifyClientCert, stranger, false},
+		{"verify-if-given without client cert", tls.VerifyClientCertIfGiven, nil, true},
+		{"verify-if-given with untrusted cert", tls.VerifyClientCertIfGiven, stranger, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTLSSrv(&Config{
+				TLSCertFile:     serverCert.certFile,
+				TLSKeyFile:      serverCert.keyFile,
+				TLSClientCAFile: ca.certFile,
+				TLSClientAuth:   tt.auth,
+			})
+			url := serveTLS(t, server)
+
+			var certs []*testCert
+			if tt.client != nil {
+				certs = append(certs, tt.client)
+			}
+			resp, err := tlsClient([]*x509.Certificate{ca.cert}, certs...).Get(url + "/health")
+			if err == nil {
+				resp.Body.Close()
+			}
+			if ok := err == nil && resp.StatusCode == http.StatusOK; ok != tt.wantOK {
+				t.Errorf("Expected success %v, got error %v", tt.wantOK, err)
+			}
+		})
+	}
+}
+
+func TestReloadTLSCertificatePaths(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, dir, "server", nil, false)
+	second := newTestCert(t, dir, "renewed", nil, false)
+
+	tlsFiles := func(cert *testCert) string {
+		return "tls_cert_file: " + cert.certFile + "\ntls_key_file: " + cert.keyFile + "\n"
+	}
+	server, path := newReloadSrv(t, tlsFiles(first))
+	url := serveTLS(t, server)
+	client := tlsClient([]*x509.Certificate{first.cert, second.cert})
+
+	rewriteConfigFile(t, path, tlsFiles(second))
+	if _, err := server.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig() error = %v", err)
+	}
+	if serial := servedSerial(t, client, url); serial != second.cert.SerialNumber.Int64() {
+		t.Errorf("Expected the new certificate after reload, got serial %d", serial)
+	}
+
+	// a pair that doesn't load rejects the reload
+	rewriteConfigFile(t, path, "tls_cert_file: "+filepath.Join(dir, "missing.crt")+"\ntls_key_file: "+second.keyFile+"\n")
+	var invalid *ValidationError
+	if _, err := server.ReloadConfig(); !errors.As(err, &invalid) {
+		t.Errorf("Expected *ValidationError, got %v", err)
+	}
+	if server.Config().TLSCertFile != second.certFile {
+		t.Errorf("Rejected reload changed the certificate to %q", server.Config().TLSCertFile)
+	}
+
+	// turning TLS off needs a restart
+	rewriteConfigFile(t, path, "")
+	if _, err := server.ReloadConfig(); !errors.Is(err, ErrRestartRequired) {
+		t.Errorf("Expected ErrRestartRequired, got %v", err)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsAddr string
+		host      string
+		target    string
+		want      string
+	}{
+		{"custom port", ":8443", "example.com", "/jwks?x=1", "https://example.com:8443/jwks?x=1"},
+		{"default port", ":443", "example.com:8080", "/auth", "https://example.com/auth"},
+		{"ipv6", "[::]:8443", "[::1]:8080", "/", "https://[::1]:8443/"},
+		{"ipv6 default port", ":443", "[::1]", "/", "https://[::1]/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			redirectHandler(tt.httpsAddr).ServeHTTP(w, req)
+
+			if w.Code != http.StatusPermanentRedirect {
+				t.Errorf("Expected 308, got %d", w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.want {
+				t.Errorf("Location = %q, want %q", location, tt.want)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = ""
+	w := httptest.NewRecorder()
+	redirectHandler(":443").ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a Host, got %d", w.Code)
+	}
+}
@@ -0,0 +1,239 @@
+package jwksclient
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"csce-3550_jwks-srv/internal/keys"
+)
+
+const (
+	defaultTTL                = 5 * time.Minute
+	defaultMinRefreshInterval = 30 * time.Second
+	defaultFetchTimeout       = 10 * time.Second
+)
+
+// ErrNoKeySet indicates no JWKS has been fetched successfully yet
+var ErrNoKeySet = errors.New("no JWKS available")
+
+// Options tunes caching and refresh behaviour - zero values use the defaults
+type Options struct {
+	HTTPClient         *http.Client  // defaults to a client with a 10s timeout
+	DefaultTTL         time.Duration // cache lifetime when the response has no usable Cache-Control
+	MinRefreshInterval time.Duration // floor between fetches, caps kid-miss refetch rate
+}
+
+// Client fetches and caches a remote JWKS
+type Client struct {
+	url                string
+	httpClient         *http.Client
+	defaultTTL         time.Duration
+	minRefreshInterval time.Duration
+
+	mu        sync.RWMutex
+	jwks      *keys.JWKS
+	expiresAt time.Time
+	lastFetch time.Time
+
+	refreshMu sync.Mutex // serialises fetches so concurrent misses share one request
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+	now       func() time.Time
+}
+
+// NewClient creates a client for the JWKS document at jwksURL
+func NewClient(jwksURL string, opts Options) *Client {
+	client := &Client{
+		url:                jwksURL,
+		httpClient:         opts.HTTPClient,
+		defaultTTL:         opts.DefaultTTL,
+		minRefreshInterval: opts.MinRefreshInterval,
+		stopCh:             make(chan struct{}),
+		now:                time.Now,
+	}
+
+	if client.httpClient == nil {
+		client.httpClient = &http.Client{Timeout: defaultFetchTimeout}
+	}
+	if client.defaultTTL <= 0 {
+		client.defaultTTL = defaultTTL
+	}
+	if client.minRefreshInterval <= 0 {
+		client.minRefreshInterval = defaultMinRefreshInterval
+	}
+
+	return client
+}
+
+// start background refresh - fetches once up front so callers see errors early
+func (c *Client) Start(ctx context.Context) error {
+	if err := c.Refresh(ctx); err != nil {
+		return err
+	}
+
+	go c.refreshLoop()
+	return nil
+}
+
+// stop background refresh
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
+
+// background refresh loop - wakes when the cached set expires
+func (c *Client) refreshLoop() {
+	for {
+		c.mu.RLock()
+		wait := c.expiresAt.Sub(c.now())
+		c.mu.RUnlock()
+
+		if wait < c.minRefreshInterval {
+			wait = c.minRefreshInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			ctx, cancel := context.WithTimeout(context.Background(), defaultFetchTimeout)
+			// keep serving the stale set on failure, next tick retries
+			_ = c.Refresh(ctx)
+			cancel()
+		case <-c.stopCh:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// KeyFunc resolves kid to a public key - plugs into jwt.VerifyJWT.
+// A stale cache or unknown kid triggers a refetch, at most once per MinRefreshInterval.
+func (c *Client) KeyFunc(kid string) (crypto.PublicKey, error) {
+	c.mu.RLock()
+	jwks, fresh := c.jwks, c.now().Before(c.expiresAt)
+	c.mu.RUnlock()
+
+	if jwks != nil {
+		pubKey, err := jwks.PublicKey(kid)
+		if err == nil && fresh {
+			return pubKey, nil
+		}
+		if err != nil && !errors.Is(err, keys.ErrKeyNotFound) {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultFetchTimeout)
+	defer cancel()
+
+	if err := c.refreshIfAllowed(ctx); err != nil && jwks == nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	jwks = c.jwks
+	c.mu.RUnlock()
+
+	if jwks == nil {
+		return nil, ErrNoKeySet
+	}
+	return jwks.PublicKey(kid)
+}
+
+// JWKS returns the cached key set, nil before the first successful fetch
+func (c *Client) JWKS() *keys.JWKS {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.jwks
+}
+
+// Refresh fetches the JWKS now regardless of cache state
+func (c *Client) Refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return c.fetch(ctx)
+}
+
+// refetch unless another fetch happened inside the rate limit window
+func (c *Client) refreshIfAllowed(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mu.RLock()
+	lastFetch := c.lastFetch
+	c.mu.RUnlock()
+
+	if !lastFetch.IsZero() && c.now().Sub(lastFetch) < c.minRefreshInterval {
+		return nil
+	}
+	return c.fetch(ctx)
+}
+
+// fetch and cache the document - callers hold refreshMu
+func (c *Client) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build JWKS request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// record the attempt up front so failures are rate limited too
+	c.mu.Lock()
+	c.lastFetch = c.now()
+	c.mu.Unlock()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var jwks keys.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	ttl := cacheTTL(resp.Header.Get("Cache-Control"), c.defaultTTL)
+
+	c.mu.Lock()
+	c.jwks = &jwks
+	c.expiresAt = c.now().Add(ttl)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// cacheTTL derives the cache lifetime from Cache-Control.
+// no-store/no-cache mean revalidate on every miss; max-age wins otherwise.
+func cacheTTL(header string, fallback time.Duration) time.Duration {
+	if header == "" {
+		return fallback
+	}
+
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(directive, "max-age="), `"`))
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return fallback
+}
@@ -0,0 +1,235 @@
+package jwksclient
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"csce-3550_jwks-srv/internal/keys"
+)
+
+// jwksServer serves a mutable key set and counts requests
+type jwksServer struct {
+	mu           sync.Mutex
+	keys         []*keys.Key
+	cacheControl string
+	hits         atomic.Int32
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.hits.Add(1)
+
+	s.mu.Lock()
+	jwks := keys.JWKS{Keys: make([]map[string]interface{}, 0, len(s.keys))}
+	for _, key := range s.keys {
+		jwks.Keys = append(jwks.Keys, key.ToJWK())
+	}
+	cacheControl := s.cacheControl
+	s.mu.Unlock()
+
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jwks)
+}
+
+func (s *jwksServer) add(key *keys.Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, key)
+}
+
+func generateKey(t *testing.T, id string) *keys.Key {
+	t.Helper()
+	key, err := keys.GenerateRSAKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateRSAKeyPair() error = %v", err)
+	}
+	key.ID = id
+	return key
+}
+
+func TestClientKeyFunc(t *testing.T) {
+	key := generateKey(t, "kid-1")
+	backend := &jwksServer{keys: []*keys.Key{key}, cacheControl: "public, max-age=300"}
+	ts := httptest.NewServer(backend)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, Options{})
+
+	pubKey, err := client.KeyFunc("kid-1")
+	if err != nil {
+		t.Fatalf("KeyFunc() error = %v", err)
+	}
+	if !key.PublicKey.(*rsa.PublicKey).Equal(pubKey) {
+		t.Error("KeyFunc() returned wrong public key")
+	}
+
+	// second lookup is served from cache
+	if _, err := client.KeyFunc("kid-1"); err != nil {
+		t.Fatalf("KeyFunc() error = %v", err)
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("Expected 1 fetch, got %d", hits)
+	}
+}
+
+func TestClientRefetchesOnUnknownKid(t *testing.T) {
+	backend := &jwksServer{keys: []*keys.Key{generateKey(t, "kid-1")}, cacheControl: "max-age=3600"}
+	ts := httptest.NewServer(backend)
+	defer ts.Close()
+
+	now := time.Now()
+	client := NewClient(ts.URL, Options{MinRefreshInterval: time.Minute})
+	client.now = func() time.Time { return now }
+
+	if err := client.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	// rotated key shows up after a refetch
+	rotated := generateKey(t, "kid-2")
+	backend.add(rotated)
+
+	// inside the rate limit window the miss is not refetched
+	now = now.Add(10 * time.Second)
+	if _, err := client.KeyFunc("kid-2"); !errors.Is(err, keys.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound inside rate limit window, got %v", err)
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("Expected kid miss to be rate limited, got %d fetches", hits)
+	}
+
+	// once the window passes the miss triggers a refetch
+	now = now.Add(time.Minute)
+	pubKey, err := client.KeyFunc("kid-2")
+	if err != nil {
+		t.Fatalf("KeyFunc() after window error = %v", err)
+	}
+	if !rotated.PublicKey.(*rsa.PublicKey).Equal(pubKey) {
+		t.Error("KeyFunc() returned wrong public key")
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("Expected 2 fetches, got %d", hits)
+	}
+}
+
+func TestClientRefreshesExpiredCache(t *testing.T) {
+	backend := &jwksServer{keys: []*keys.Key{generateKey(t, "kid-1")}, cacheControl: "max-age=60"}
+	ts := httptest.NewServer(backend)
+	defer ts.Close()
+
+	now := time.Now()
+	client := NewClient(ts.URL, Options{MinRefreshInterval: time.Second})
+	client.now = func() time.Time { return now }
+
+	if _, err := client.KeyFunc("kid-1"); err != nil {
+		t.Fatalf("KeyFunc() error = %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := client.KeyFunc("kid-1"); err != nil {
+		t.Fatalf("KeyFunc() error = %v", err)
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("Expected stale cache to refetch, got %d fetches", hits)
+	}
+}
+
+func TestClientServesStaleOnFetchError(t *testing.T) {
+	key := generateKey(t, "kid-1")
+	backend := &jwksServer{keys: []*keys.Key{key}, cacheControl: "max-age=1"}
+	failing := atomic.Bool{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+	client := NewClient(ts.URL, Options{MinRefreshInterval: time.Second})
+	client.now = func() time.Time { return now }
+
+	if err := client.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	failing.Store(true)
+	now = now.Add(time.Hour)
+
+	pubKey, err := client.KeyFunc("kid-1")
+	if err != nil {
+		t.Fatalf("KeyFunc() should fall back to stale set, got %v", err)
+	}
+	if !key.PublicKey.(*rsa.PublicKey).Equal(pubKey) {
+		t.Error("KeyFunc() returned wrong public key")
+	}
+}
+
+func TestClientNoKeySet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, Options{})
+	if _, err := client.KeyFunc("kid-1"); err == nil {
+		t.Error("Expected error when JWKS cannot be fetched")
+	}
+
+	if err := client.Start(context.Background()); err == nil {
+		t.Error("Expected Start() to surface the initial fetch error")
+	}
+}
+
+func TestClientBackgroundRefresh(t *testing.T) {
+	backend := &jwksServer{keys: []*keys.Key{generateKey(t, "kid-1")}, cacheControl: "no-cache"}
+	ts := httptest.NewServer(backend)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, Options{MinRefreshInterval: 20 * time.Millisecond})
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	client.Stop()
+	client.Stop() // stopping twice should not panic
+
+	if hits := backend.hits.Load(); hits < 3 {
+		t.Errorf("Expected background refreshes, got %d fetches", hits)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	fallback := 5 * time.Minute
+	tests := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", fallback},
+		{"public, max-age=120", 2 * time.Minute},
+		{"max-age=\"30\"", 30 * time.Second},
+		{"no-store", 0},
+		{"no-cache, max-age=60", 0},
+		{"max-age=abc", fallback},
+		{"private", fallback},
+	}
+
+	for _, tt := range tests {
+		if got := cacheTTL(tt.header, fallback); got != tt.expected {
+			t.Errorf("cacheTTL(%q) = %v, want %v", tt.header, got, tt.expected)
+		}
+	}
+}
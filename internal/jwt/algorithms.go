@@ -0,0 +1,158 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha512" // SHA-384/512 for RS*/PS* digests
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+// signing method for one JWS alg
+type signingMethod struct {
+	hash   crypto.Hash
+	sign   func(key crypto.Signer, digest []byte) ([]byte, error)
+	verify func(pub crypto.PublicKey, digest, signature []byte) error
+}
+
+// supported JWS algorithms - alg is always checked against the key type
+var signingMethods = map[string]signingMethod{
+	"RS256": {hash: crypto.SHA256, sign: signPKCS1v15(crypto.SHA256), verify: verifyPKCS1v15(crypto.SHA256)},
+	"RS384": {hash: crypto.SHA384, sign: signPKCS1v15(crypto.SHA384), verify: verifyPKCS1v15(crypto.SHA384)},
+	"RS512": {hash: crypto.SHA512, sign: signPKCS1v15(crypto.SHA512), verify: verifyPKCS1v15(crypto.SHA512)},
+	"PS256": {hash: crypto.SHA256, sign: signPSS(crypto.SHA256), verify: verifyPSS(crypto.SHA256)},
+	"PS384": {hash: crypto.SHA384, sign: signPSS(crypto.SHA384), verify: verifyPSS(crypto.SHA384)},
+	"PS512": {hash: crypto.SHA512, sign: signPSS(crypto.SHA512), verify: verifyPSS(crypto.SHA512)},
+	"ES256": {hash: crypto.SHA256, sign: signECDSA(elliptic.P256(), crypto.SHA256), verify: verifyECDSA(elliptic.P256())},
+	"EdDSA": {sign: signEd25519, verify: verifyEd25519},
+}
+
+// lookup signing method for alg
+func methodFor(alg string) (signingMethod, error) {
+	method, ok := signingMethods[alg]
+	if !ok {
+		return signingMethod{}, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+	return method, nil
+}
+
+// hash the signing input w/ the method's digest - EdDSA signs the raw input
+func (m signingMethod) digest(data []byte) []byte {
+	if m.hash == 0 {
+		return data
+	}
+	h := m.hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// RSASSA-PKCS1-v1_5
+func signPKCS1v15(hash crypto.Hash) func(crypto.Signer, []byte) ([]byte, error) {
+	return func(key crypto.Signer, digest []byte) ([]byte, error) {
+		if _, ok := key.Public().(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("RSA algorithm requires an RSA key, got %T", key.Public())
+		}
+		return key.Sign(rand.Reader, digest, hash)
+	}
+}
+
+func verifyPKCS1v15(hash crypto.Hash) func(crypto.PublicKey, []byte, []byte) error {
+	return func(pub crypto.PublicKey, digest, signature []byte) error {
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("RSA algorithm requires an RSA key, got %T", pub)
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+	}
+}
+
+// RSASSA-PSS w/ MGF1 and a salt as long as the digest - RFC 7518 3.5
+func signPSS(hash crypto.Hash) func(crypto.Signer, []byte) ([]byte, error) {
+	return func(key crypto.Signer, digest []byte) ([]byte, error) {
+		if _, ok := key.Public().(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("RSA algorithm requires an RSA key, got %T", key.Public())
+		}
+		return key.Sign(rand.Reader, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash})
+	}
+}
+
+func verifyPSS(hash crypto.Hash) func(crypto.PublicKey, []byte, []byte) error {
+	return func(pub crypto.PublicKey, digest, signature []byte) error {
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("RSA algorithm requires an RSA key, got %T", pub)
+		}
+		return rsa.VerifyPSS(rsaKey, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	}
+}
+
+// ECDSA w/ the fixed width R||S encoding from RFC 7518 3.4 - crypto.Signer returns ASN.1
+func signECDSA(curve elliptic.Curve, hash crypto.Hash) func(crypto.Signer, []byte) ([]byte, error) {
+	return func(key crypto.Signer, digest []byte) ([]byte, error) {
+		ecKey, ok := key.Public().(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != curve {
+			return nil, fmt.Errorf("ECDSA algorithm requires a %s key", curve.Params().Name)
+		}
+
+		der, err := key.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &sig); err != nil {
+			return nil, fmt.Errorf("failed to decode ECDSA signature: %w", err)
+		}
+
+		size := (curve.Params().BitSize + 7) / 8
+		raw := make([]byte, 2*size)
+		sig.R.FillBytes(raw[:size])
+		sig.S.FillBytes(raw[size:])
+		return raw, nil
+	}
+}
+
+func verifyECDSA(curve elliptic.Curve) func(crypto.PublicKey, []byte, []byte) error {
+	return func(pub crypto.PublicKey, digest, signature []byte) error {
+		ecKey, ok := pub.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != curve {
+			return fmt.Errorf("ECDSA algorithm requires a %s key", curve.Params().Name)
+		}
+
+		size := (curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("ECDSA signature must be %d bytes", 2*size)
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("ECDSA verification failed")
+		}
+		return nil
+	}
+}
+
+// Ed25519 per RFC 8037 3.1 - the signer hashes internally so the message is passed whole
+func signEd25519(key crypto.Signer, message []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); !ok {
+		return nil, fmt.Errorf("EdDSA algorithm requires an Ed25519 key, got %T", key.Public())
+	}
+	return key.Sign(rand.Reader, message, crypto.Hash(0))
+}
+
+func verifyEd25519(pub crypto.PublicKey, message, signature []byte) error {
+	edKey, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("EdDSA algorithm requires an Ed25519 key, got %T", pub)
+	}
+	if len(edKey) != ed25519.PublicKeySize || !ed25519.Verify(edKey, message, signature) {
+		return fmt.Errorf("Ed25519 verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package jwt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultAudience is used when a token is minted without an explicit audience
+const DefaultAudience = "jwks-client"
+
+// registered claim names - custom claims may not shadow these
+var registeredClaims = map[string]bool{
+	"iss": true,
+	"sub": true,
+	"aud": true,
+	"exp": true,
+	"nbf": true,
+	"iat": true,
+	"jti": true,
+}
+
+// JWT claims set - registered claims plus arbitrary custom ones
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt int64
+	NotBefore int64
+	IssuedAt  int64
+	ID        string
+	Custom    map[string]interface{}
+}
+
+// MarshalJSON flattens registered and custom claims into one object.
+// A single audience is encoded as a string, several as an array (RFC 7519 4.1.3).
+func (c Claims) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(c.Custom)+7)
+	for name, value := range c.Custom {
+		out[name] = value
+	}
+
+	if c.Issuer != "" {
+		out["iss"] = c.Issuer
+	}
+	if c.Subject != "" {
+		out["sub"] = c.Subject
+	}
+	switch len(c.Audience) {
+	case 0:
+	case 1:
+		out["aud"] = c.Audience[0]
+	default:
+		out["aud"] = c.Audience
+	}
+	if c.ExpiresAt != 0 {
+		out["exp"] = c.ExpiresAt
+	}
+	if c.NotBefore != 0 {
+		out["nbf"] = c.NotBefore
+	}
+	if c.IssuedAt != 0 {
+		out["iat"] = c.IssuedAt
+	}
+	if c.ID != "" {
+		out["jti"] = c.ID
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON splits a payload into registered and custom claims.
+// aud may be a string or an array; NumericDate values may be fractional.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var raw map[string]interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+
+	var parsed Claims
+	parsed.Custom = make(map[string]interface{})
+
+	for name, value := range raw {
+		var err error
+		switch name {
+		case "iss":
+			parsed.Issuer, err = claimString(name, value)
+		case "sub":
+			parsed.Subject, err = claimString(name, value)
+		case "jti":
+			parsed.ID, err = claimString(name, value)
+		case "aud":
+			parsed.Audience, err = claimAudience(value)
+		case "exp":
+			parsed.ExpiresAt, err = claimNumericDate(name, value)
+		case "nbf":
+			parsed.NotBefore, err = claimNumericDate(name, value)
+		case "iat":
+			parsed.IssuedAt, err = claimNumericDate(name, value)
+		default:
+			parsed.Custom[name] = value
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	*c = parsed
+	return nil
+}
+
+// helper - string valued registered claim
+func claimString(name string, value interface{}) (string, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("claim %q must be a string", name)
+	}
+	return str, nil
+}
+
+// helper - aud as a single string or an array of strings
+func claimAudience(value interface{}) ([]string, error) {
+	switch aud := value.(type) {
+	case string:
+		return []string{aud}, nil
+	case []interface{}:
+		out := make([]string, 0, len(aud))
+		for _, entry := range aud {
+			str, ok := entry.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim \"aud\" must contain only strings")
+			}
+			out = append(out, str)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("claim \"aud\" must be a string or array of strings")
+	}
+}
+
+// helper - NumericDate truncated to whole seconds
+func claimNumericDate(name string, value interface{}) (int64, error) {
+	num, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("claim %q must be a number", name)
+	}
+	if i, err := num.Int64(); err == nil {
+		return i, nil
+	}
+	f, err := num.Float64()
+	if err != nil {
+		return 0, fmt.Errorf("claim %q is not a valid NumericDate: %w", name, err)
+	}
+	return int64(f), nil
+}
+
+// ClaimsBuilder assembles a Claims set for signing
+type ClaimsBuilder struct {
+	claims    Claims
+	lifetime  time.Duration
+	notBefore time.Time
+	now       func() time.Time
+	err       error
+}
+
+// NewClaims starts a claims set for the given issuer
+func NewClaims(issuer string) *ClaimsBuilder {
+	return &ClaimsBuilder{
+		claims: Claims{
+			Issuer: issuer,
+			Custom: make(map[string]interface{}),
+		},
+		now: time.Now,
+	}
+}
+
+// Subject sets the sub claim
+func (b *ClaimsBuilder) Subject(sub string) *ClaimsBuilder {
+	b.claims.Subject = sub
+	return b
+}
+
+// Audience sets the aud claim
+func (b *ClaimsBuilder) Audience(aud ...string) *ClaimsBuilder {
+	b.claims.Audience = append([]string(nil), aud...)
+	return b
+}
+
+// ID sets the jti claim - a random UUID is used if never set
+func (b *ClaimsBuilder) ID(jti string) *ClaimsBuilder {
+	b.claims.ID = jti
+	return b
+}
+
+// ExpiresIn sets exp relative to the issue time - negative values mint already expired tokens
+func (b *ClaimsBuilder) ExpiresIn(lifetime time.Duration) *ClaimsBuilder {
+	b.lifetime = lifetime
+	return b
+}
+
+// NotBefore sets the nbf claim
+func (b *ClaimsBuilder) NotBefore(t time.Time) *ClaimsBuilder {
+	b.notBefore = t
+	return b
+}
+
+// Claim adds a custom claim - registered names are rejected at Build time
+func (b *ClaimsBuilder) Claim(name string, value interface{}) *ClaimsBuilder {
+	if registeredClaims[name] {
+		b.err = fmt.Errorf("claim %q is registered and must be set through its builder method", name)
+		return b
+	}
+	b.claims.Custom[name] = value
+	return b
+}
+
+// Build stamps iat/exp/nbf and returns the finished claims
+func (b *ClaimsBuilder) Build() (*Claims, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	now := b.now()
+	claims := b.claims
+	claims.Audience = append([]string(nil), b.claims.Audience...)
+	claims.Custom = make(map[string]interface{}, len(b.claims.Custom))
+	for name, value := range b.claims.Custom {
+		claims.Custom[name] = value
+	}
+
+	claims.IssuedAt = now.Unix()
+	if b.lifetime != 0 {
+		claims.ExpiresAt = now.Add(b.lifetime).Unix()
+	}
+	if !b.notBefore.IsZero() {
+		claims.NotBefore = b.notBefore.Unix()
+	}
+	if claims.ID == "" {
+		claims.ID = uuid.NewString()
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,122 @@
+package jwt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestClaimsBuilder(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	builder := NewClaims("test-issuer").
+		Subject("42").
+		Audience("svc-a").
+		ID("fixed-jti").
+		ExpiresIn(5*time.Minute).
+		NotBefore(now).
+		Claim("scope", "read write")
+	builder.now = func() time.Time { return now }
+
+	claims, err := builder.Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if claims.Subject != "42" || claims.Issuer != "test-issuer" || claims.ID != "fixed-jti" {
+		t.Errorf("Unexpected registered claims: %+v", claims)
+	}
+
+	if claims.IssuedAt != now.Unix() {
+		t.Errorf("Expected iat %d, got %d", now.Unix(), claims.IssuedAt)
+	}
+
+	if claims.ExpiresAt != now.Add(5*time.Minute).Unix() {
+		t.Errorf("Expected exp %d, got %d", now.Add(5*time.Minute).Unix(), claims.ExpiresAt)
+	}
+
+	if claims.NotBefore != now.Unix() {
+		t.Errorf("Expected nbf %d, got %d", now.Unix(), claims.NotBefore)
+	}
+
+	if claims.Custom["scope"] != "read write" {
+		t.Errorf("Expected custom scope claim, got %v", claims.Custom["scope"])
+	}
+}
+
+func TestClaimsBuilderGeneratesID(t *testing.T) {
+	first, err := NewClaims("iss").Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	second, err := NewClaims("iss").Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if first.ID == "" || first.ID == second.ID {
+		t.Errorf("Expected unique generated jti values, got %q and %q", first.ID, second.ID)
+	}
+}
+
+func TestClaimsBuilderRejectsRegisteredCustomClaim(t *testing.T) {
+	for _, name := range []string{"sub", "exp", "iss", "aud", "jti"} {
+		_, err := NewClaims("iss").Claim(name, "x").Build()
+		if err == nil {
+			t.Errorf("Expected error when setting %q as a custom claim", name)
+		}
+	}
+}
+
+func TestClaimsMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience []string
+		expected interface{}
+	}{
+		{"single audience as string", []string{"a"}, "a"},
+		{"multiple audiences as array", []string{"a", "b"}, []interface{}{"a", "b"}},
+		{"no audience omitted", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := Claims{Issuer: "iss", Audience: tt.audience, Custom: map[string]interface{}{"role": "admin"}}
+
+			data, err := json.Marshal(claims)
+			if err != nil {
+				t.Fatalf("Marshal error = %v", err)
+			}
+
+			var decoded map[string]interface{}
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal error = %v", err)
+			}
+
+			aud, present := decoded["aud"]
+			if tt.expected == nil {
+				if present {
+					t.Errorf("Expected aud to be omitted, got %v", aud)
+				}
+			} else if !jsonEqual(aud, tt.expected) {
+				t.Errorf("Expected aud %v, got %v", tt.expected, aud)
+			}
+
+			if decoded["role"] != "admin" {
+				t.Errorf("Expected custom claim role=admin, got %v", decoded["role"])
+			}
+
+			if _, present := decoded["sub"]; present {
+				t.Error("Expected empty sub to be omitted")
+			}
+		})
+	}
+}
+
+// jsonEqual compares two decoded JSON values
+func jsonEqual(a, b interface{}) bool {
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	return string(aj) == string(bj)
+}
@@ -2,9 +2,7 @@ package jwt
 
 import (
 	"crypto"
-	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -17,42 +15,40 @@ type Header struct {
 	Kid string `json:"kid"`
 }
 
-// JWT payload
-type Payload struct {
-	Iss string `json:"iss"`
-	Sub string `json:"sub"`
-	Aud string `json:"aud"`
-	Exp int64  `json:"exp"`
-	Iat int64  `json:"iat"`
+// create JWT w/ RSA key - default audience, no subject
+func CreateJWT(privKey *rsa.PrivateKey, kid, issuer string, expiry time.Duration) (string, error) {
+	claims, err := NewClaims(issuer).
+		Audience(DefaultAudience).
+		ExpiresIn(expiry).
+		Build()
+	if err != nil {
+		return "", fmt.Errorf("claims error: %w", err)
+	}
+
+	return Sign(privKey, "RS256", kid, claims)
 }
 
-// create JWT w/ RSA key
-func CreateJWT(privKey *rsa.PrivateKey, kid, issuer string, expiry time.Duration) (string, error) {
-	now := time.Now()
+// sign a claims set w/ the key and JWS alg
+func Sign(privKey crypto.Signer, alg, kid string, claims *Claims) (string, error) {
+	method, err := methodFor(alg)
+	if err != nil {
+		return "", err
+	}
 
 	// header
 	header := Header{
-		Alg: "RS256",
+		Alg: alg,
 		Typ: "JWT",
 		Kid: kid,
 	}
 
-	// payload
-	payload := Payload{
-		Iss: issuer,
-		Sub: "user123", // mock user
-		Aud: "jwks-client",
-		Iat: now.Unix(),
-		Exp: now.Add(expiry).Unix(),
-	}
-
 	// encode header and payload
 	headerBytes, err := json.Marshal(header)
 	if err != nil {
 		return "", fmt.Errorf("header marshal error: %w", err)
 	}
 
-	payloadBytes, err := json.Marshal(payload)
+	payloadBytes, err := json.Marshal(claims)
 	if err != nil {
 		return "", fmt.Errorf("payload marshal error: %w", err)
 	}
@@ -62,7 +58,7 @@ func CreateJWT(privKey *rsa.PrivateKey, kid, issuer string, expiry time.Duration
 
 	// sign
 	message := headerB64 + "." + payloadB64
-	signature, err := signRS256([]byte(message), privKey)
+	signature, err := method.sign(privKey, method.digest([]byte(message)))
 	if err != nil {
 		return "", fmt.Errorf("signing error: %w", err)
 	}
@@ -72,12 +68,6 @@ func CreateJWT(privKey *rsa.PrivateKey, kid, issuer string, expiry time.Duration
 	return message + "." + signatureB64, nil
 }
 
-// sign w/ RS256
-func signRS256(data []byte, privKey *rsa.PrivateKey) ([]byte, error) {
-	hash := sha256.Sum256(data)
-	return rsa.SignPKCS1v15(rand.Reader, privKey, crypto.SHA256, hash[:])
-}
-
 // base64url encode
 func encodeBase64URL(data []byte) string {
 	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
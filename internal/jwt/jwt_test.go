@@ -48,14 +48,19 @@ func TestSignRS256(t *testing.T) {
 		t.Fatalf("Failed to generate test key: %v", err)
 	}
 
+	method, err := methodFor("RS256")
+	if err != nil {
+		t.Fatalf("methodFor() error = %v", err)
+	}
+
 	data := []byte("test message")
-	signature, err := signRS256(data, privKey)
+	signature, err := method.sign(privKey, method.digest(data))
 	if err != nil {
-		t.Fatalf("signRS256() error = %v", err)
+		t.Fatalf("RS256 sign error = %v", err)
 	}
 
 	if len(signature) == 0 {
-		t.Error("signRS256() returned empty signature")
+		t.Error("RS256 sign returned empty signature")
 	}
 
 	// signature should be 256 bytes for 2048-bit key
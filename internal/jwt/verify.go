@@ -0,0 +1,186 @@
+package jwt
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMalformedToken indicates the token is not a well-formed compact JWS
+	ErrMalformedToken = errors.New("malformed token")
+
+	// ErrUnsupportedAlgorithm indicates the header alg is not one we verify
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+
+	// ErrUnknownKey indicates no verification key was found for the header kid
+	ErrUnknownKey = errors.New("unknown signing key")
+
+	// ErrInvalidSignature indicates the signature does not match the signing input
+	ErrInvalidSignature = errors.New("invalid token signature")
+
+	// ErrTokenExpired indicates exp is in the past
+	ErrTokenExpired = errors.New("token is expired")
+
+	// ErrTokenNotYetValid indicates nbf is in the future
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+
+	// ErrTokenIssuedInFuture indicates iat is in the future
+	ErrTokenIssuedInFuture = errors.New("token issued in the future")
+
+	// ErrMissingExpiry indicates the token has no exp claim
+	ErrMissingExpiry = errors.New("token has no expiry")
+
+	// ErrInvalidIssuer indicates iss does not match the expected issuer
+	ErrInvalidIssuer = errors.New("invalid token issuer")
+
+	// ErrInvalidAudience indicates aud does not contain an accepted audience
+	ErrInvalidAudience = errors.New("invalid token audience")
+)
+
+// KeyFunc resolves the verification key for a kid - keys.JWKS.PublicKey satisfies it
+type KeyFunc func(kid string) (crypto.PublicKey, error)
+
+// VerifyOptions controls claim validation
+type VerifyOptions struct {
+	Issuer    string           // required iss, skipped when empty
+	Audience  []string         // accepted aud values, any match passes, skipped when empty
+	ClockSkew time.Duration    // leeway applied to exp, nbf and iat
+	Now       func() time.Time // clock override for tests, defaults to time.Now
+}
+
+// Token is a decoded but not yet verified JWT
+type Token struct {
+	Header    Header
+	Claims    Claims
+	Signature []byte
+	signed    string
+}
+
+// ParseJWT decodes a compact JWT without verifying it
+func ParseJWT(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts, got %d", ErrMalformedToken, len(parts))
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrMalformedToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformedToken, err)
+	}
+
+	parsed := &Token{
+		Signature: signature,
+		signed:    parts[0] + "." + parts[1],
+	}
+
+	if err := json.Unmarshal(headerBytes, &parsed.Header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+
+	if err := json.Unmarshal(payloadBytes, &parsed.Claims); err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrMalformedToken, err)
+	}
+
+	return parsed, nil
+}
+
+// VerifyJWT checks the signature against keyFunc and validates the claims
+func VerifyJWT(token string, keyFunc KeyFunc, opts VerifyOptions) (*Claims, error) {
+	parsed, err := ParseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parsed.verifySignature(keyFunc); err != nil {
+		return nil, err
+	}
+
+	if err := parsed.Claims.Validate(opts); err != nil {
+		return nil, err
+	}
+
+	return &parsed.Claims, nil
+}
+
+// verify signature w/ the key named in the header
+func (t *Token) verifySignature(keyFunc KeyFunc) error {
+	method, err := methodFor(t.Header.Alg)
+	if err != nil {
+		return err
+	}
+
+	pubKey, err := keyFunc(t.Header.Kid)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnknownKey, err)
+	}
+	if pubKey == nil {
+		return fmt.Errorf("%w: kid %q", ErrUnknownKey, t.Header.Kid)
+	}
+
+	// the key type must match alg, so an RSA key can't be used to check an ES256 header
+	if err := method.verify(pubKey, method.digest([]byte(t.signed)), t.Signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
+
+// Validate checks the time based claims plus iss and aud
+func (c *Claims) Validate(opts VerifyOptions) error {
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	current := now()
+	skew := opts.ClockSkew
+
+	if c.ExpiresAt == 0 {
+		return ErrMissingExpiry
+	}
+	if !current.Before(time.Unix(c.ExpiresAt, 0).Add(skew)) {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && current.Add(skew).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if c.IssuedAt != 0 && current.Add(skew).Before(time.Unix(c.IssuedAt, 0)) {
+		return ErrTokenIssuedInFuture
+	}
+
+	if opts.Issuer != "" && c.Issuer != opts.Issuer {
+		return fmt.Errorf("%w: %q", ErrInvalidIssuer, c.Issuer)
+	}
+
+	if len(opts.Audience) > 0 && !c.hasAudience(opts.Audience) {
+		return fmt.Errorf("%w: %v", ErrInvalidAudience, c.Audience)
+	}
+
+	return nil
+}
+
+// helper - any accepted audience present in aud
+func (c *Claims) hasAudience(accepted []string) bool {
+	for _, want := range accepted {
+		for _, have := range c.Audience {
+			if want == have {
+				return true
+			}
+		}
+	}
+	return false
+}
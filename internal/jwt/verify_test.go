@@ -0,0 +1,318 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"csce-3550_jwks-srv/internal/keys"
+)
+
+// staticKeyFunc serves a single public key under one kid
+func staticKeyFunc(kid string, pub *rsa.PublicKey) KeyFunc {
+	return func(requested string) (crypto.PublicKey, error) {
+		if requested != kid {
+			return nil, keys.ErrKeyNotFound
+		}
+		return pub, nil
+	}
+}
+
+func TestVerifyJWTRoundTrip(t *testing.T) {
+	signingKey, err := keys.GenerateRSAKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	claims, err := NewClaims("test-issuer").
+		Subject("7").
+		Audience("svc-a", "svc-b").
+		ExpiresIn(5*time.Minute).
+		Claim("scope", "read").
+		Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	token, err := Sign(signingKey.PrivateKey, signingKey.Algorithm, signingKey.ID, claims)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	// verify against the JWKS the server would publish
+	jwks := &keys.JWKS{Keys: []map[string]interface{}{signingKey.ToJWK()}}
+
+	verified, err := VerifyJWT(token, jwks.PublicKey, VerifyOptions{
+		Issuer:   "test-issuer",
+		Audience: []string{"svc-b"},
+	})
+	if err != nil {
+		t.Fatalf("VerifyJWT() error = %v", err)
+	}
+
+	if verified.Subject != "7" || verified.ID != claims.ID {
+		t.Errorf("Unexpected verified claims: %+v", verified)
+	}
+
+	if verified.Custom["scope"] != "read" {
+		t.Errorf("Expected custom scope claim, got %v", verified.Custom["scope"])
+	}
+}
+
+func TestVerifyJWTErrors(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	build := func(b *ClaimsBuilder) string {
+		b.now = func() time.Time { return now }
+		claims, err := b.Build()
+		if err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		token, err := Sign(privKey, "RS256", "kid-1", claims)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return token
+	}
+
+	valid := build(NewClaims("iss").Audience("aud").ExpiresIn(time.Minute))
+	keyFunc := staticKeyFunc("kid-1", &privKey.PublicKey)
+	opts := VerifyOptions{Issuer: "iss", Audience: []string{"aud"}, Now: func() time.Time { return now }}
+
+	tests := []struct {
+		name     string
+		token    string
+		keyFunc  KeyFunc
+		opts     VerifyOptions
+		expected error
+	}{
+		{"valid", valid, keyFunc, opts, nil},
+		{"malformed", "not.a.jwt.at.all", keyFunc, opts, ErrMalformedToken},
+		{"bad base64", "@@@.@@@.@@@", keyFunc, opts, ErrMalformedToken},
+		{"unknown kid", valid, staticKeyFunc("other", &privKey.PublicKey), opts, ErrUnknownKey},
+		{"wrong key", valid, staticKeyFunc("kid-1", &otherKey.PublicKey), opts, ErrInvalidSignature},
+		{"tampered payload", tamperPayload(t, valid), keyFunc, opts, ErrInvalidSignature},
+		{"alg none", noneAlg(valid), keyFunc, opts, ErrUnsupportedAlgorithm},
+		{"expired", build(NewClaims("iss").Audience("aud").ExpiresIn(-time.Minute)), keyFunc, opts, ErrTokenExpired},
+		{"expired within skew", build(NewClaims("iss").Audience("aud").ExpiresIn(-time.Second)), keyFunc,
+			VerifyOptions{Issuer: "iss", ClockSkew: time.Minute, Now: opts.Now}, nil},
+		{"no expiry", build(NewClaims("iss").Audience("aud")), keyFunc, opts, ErrMissingExpiry},
+		{"not yet valid", build(NewClaims("iss").Audience("aud").ExpiresIn(time.Hour).NotBefore(now.Add(time.Minute))),
+			keyFunc, opts, ErrTokenNotYetValid},
+		{"wrong issuer", build(NewClaims("other").Audience("aud").ExpiresIn(time.Minute)), keyFunc, opts, ErrInvalidIssuer},
+		{"wrong audience", build(NewClaims("iss").Audience("nope").ExpiresIn(time.Minute)), keyFunc, opts, ErrInvalidAudience},
+		{"issued in future", valid, keyFunc,
+			VerifyOptions{Now: func() time.Time { return now.Add(-time.Hour) }}, ErrTokenIssuedInFuture},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyJWT(tt.token, tt.keyFunc, tt.opts)
+			if tt.expected == nil {
+				if err != nil {
+					t.Errorf("VerifyJWT() unexpected error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("VerifyJWT() error = %v, want %v", err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseJWTAudienceForms(t *testing.T) {
+	tests := []struct {
+		payload  string
+		expected []string
+	}{
+		{`{"aud":"a"}`, []string{"a"}},
+		{`{"aud":["a","b"]}`, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		token := encodeBase64URL([]byte(`{"alg":"RS256","kid":"k"}`)) + "." + encodeBase64URL([]byte(tt.payload)) + ".c2ln"
+		parsed, err := ParseJWT(token)
+		if err != nil {
+			t.Fatalf("ParseJWT(%s) error = %v", tt.payload, err)
+		}
+		if strings.Join(parsed.Claims.Audience, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("ParseJWT(%s) aud = %v, want %v", tt.payload, parsed.Claims.Audience, tt.expected)
+		}
+	}
+
+	bad := encodeBase64URL([]byte(`{"alg":"RS256"}`)) + "." + encodeBase64URL([]byte(`{"aud":42}`)) + ".c2ln"
+	if _, err := ParseJWT(bad); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("Expected ErrMalformedToken for numeric aud, got %v", err)
+	}
+}
+
+// tamperPayload swaps the payload for a different claims set, keeping the signature
+func tamperPayload(t *testing.T, token string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	parts[1] = encodeBase64URL([]byte(`{"iss":"iss","aud":"aud","sub":"admin","exp":9999999999}`))
+	return strings.Join(parts, ".")
+}
+
+// noneAlg rewrites the header to alg none
+func noneAlg(token string) string {
+	parts := strings.Split(token, ".")
+	parts[0] = encodeBase64URL([]byte(`{"alg":"none","typ":"JWT","kid":"kid-1"}`))
+	return strings.Join(parts, ".")
+}
+
+func TestVerifyJWTES256(t *testing.T) {
+	signingKey, err := keys.GenerateECKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	claims, err := NewClaims("iss").Audience("aud").ExpiresIn(time.Minute).Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	token, err := Sign(signingKey.PrivateKey, "ES256", signingKey.ID, claims)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	parsed, err := ParseJWT(token)
+	if err != nil {
+		t.Fatalf("ParseJWT() error = %v", err)
+	}
+
+	if parsed.Header.Alg != "ES256" {
+		t.Errorf("Expected ES256 header, got %s", parsed.Header.Alg)
+	}
+
+	// raw R||S is 64 bytes, not the ASN.1 DER form
+	if len(parsed.Signature) != 64 {
+		t.Errorf("Expected 64 byte signature, got %d", len(parsed.Signature))
+	}
+
+	jwks := &keys.JWKS{Keys: []map[string]interface{}{signingKey.ToJWK()}}
+	if _, err := VerifyJWT(token, jwks.PublicKey, VerifyOptions{Issuer: "iss"}); err != nil {
+		t.Fatalf("VerifyJWT() error = %v", err)
+	}
+}
+
+func TestAlgorithmKeyMismatch(t *testing.T) {
+	ecKey, err := keys.GenerateECKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	rsaKey, err := keys.GenerateRSAKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	claims, err := NewClaims("iss").ExpiresIn(time.Minute).Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	// signing refuses a key that doesn't match alg
+	if _, err := Sign(ecKey.PrivateKey, "RS256", "kid", claims); err == nil {
+		t.Error("Expected error signing RS256 with an EC key")
+	}
+
+	// an ES256 token can't be checked against an RSA key under the same kid
+	token, err := Sign(ecKey.PrivateKey, "ES256", "kid", claims)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	keyFunc := func(string) (crypto.PublicKey, error) { return rsaKey.PublicKey, nil }
+	if _, err := VerifyJWT(token, keyFunc, VerifyOptions{}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestVerifyJWTEdDSA(t *testing.T) {
+	signingKey, err := keys.GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	claims, err := NewClaims("iss").Audience("aud").ExpiresIn(time.Minute).Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	token, err := Sign(signingKey.PrivateKey, signingKey.Algorithm, signingKey.ID, claims)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	parsed, err := ParseJWT(token)
+	if err != nil {
+		t.Fatalf("ParseJWT() error = %v", err)
+	}
+
+	if parsed.Header.Alg != "EdDSA" || len(parsed.Signature) != 64 {
+		t.Errorf("Expected EdDSA header and 64 byte signature, got %s/%d", parsed.Header.Alg, len(parsed.Signature))
+	}
+
+	jwks := &keys.JWKS{Keys: []map[string]interface{}{signingKey.ToJWK()}}
+	if _, err := VerifyJWT(token, jwks.PublicKey, VerifyOptions{Issuer: "iss"}); err != nil {
+		t.Fatalf("VerifyJWT() error = %v", err)
+	}
+
+	if _, err := VerifyJWT(tamperPayload(t, token), jwks.PublicKey, VerifyOptions{}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for tampered token, got %v", err)
+	}
+}
+
+func TestVerifyJWTRSAAlgorithms(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	claims, err := NewClaims("iss").ExpiresIn(time.Minute).Build()
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	keyFunc := staticKeyFunc("kid-1", &privKey.PublicKey)
+
+	for _, alg := range []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"} {
+		t.Run(alg, func(t *testing.T) {
+			token, err := Sign(privKey, alg, "kid-1", claims)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+
+			if _, err := VerifyJWT(token, keyFunc, VerifyOptions{}); err != nil {
+				t.Fatalf("VerifyJWT() error = %v", err)
+			}
+
+			// relabelling the header with another RSA alg must not verify
+			other := "RS256"
+			if alg == "RS256" {
+				other = "PS256"
+			}
+			parts := strings.Split(token, ".")
+			parts[0] = encodeBase64URL([]byte(`{"alg":"` + other + `","typ":"JWT","kid":"kid-1"}`))
+			if _, err := VerifyJWT(strings.Join(parts, "."), keyFunc, VerifyOptions{}); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature for %s relabelled as %s, got %v", alg, other, err)
+			}
+		})
+	}
+}
@@ -1,29 +1,93 @@
 package keys
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
 	"time"
 )
 
+// gen key pair for a signing algorithm - RSA keys use the default size
+func GenerateKeyPair(alg string) (*Key, error) {
+	return GenerateKeyPairWithSize(alg, DefaultRSAKeySize)
+}
+
+// gen key pair for a signing algorithm - rsaBits only applies to RS*/PS*
+func GenerateKeyPairWithSize(alg string, rsaBits int) (*Key, error) {
+	switch {
+	case alg == "":
+		return GenerateRSAKeyPairWithSize(AlgorithmRS256, rsaBits)
+	case rsaAlgorithms[alg]:
+		return GenerateRSAKeyPairWithSize(alg, rsaBits)
+	case alg == AlgorithmES256:
+		return GenerateECKeyPair()
+	case alg == AlgorithmEdDSA:
+		return GenerateEd25519KeyPair()
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
 // gen RSA key pair w/ metadata
 func GenerateRSAKeyPair() (*Key, error) {
-	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	return GenerateRSAKeyPairWithSize(AlgorithmRS256, DefaultRSAKeySize)
+}
+
+// gen RSA key pair of the given size for an RS*/PS* algorithm
+func GenerateRSAKeyPairWithSize(alg string, bits int) (*Key, error) {
+	if !rsaAlgorithms[alg] {
+		return nil, fmt.Errorf("algorithm %s does not use RSA keys", alg)
+	}
+	if err := ValidateRSAKeySize(bits); err != nil {
+		return nil, err
+	}
+
+	privKey, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate RSA key: %w", err)
 	}
 
+	return newGeneratedKey(privKey, alg), nil
+}
+
+// gen EC P-256 key pair w/ metadata
+func GenerateECKeyPair() (*Key, error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate EC key: %w", err)
+	}
+
+	return newGeneratedKey(privKey, AlgorithmES256), nil
+}
+
+// gen Ed25519 key pair w/ metadata
+func GenerateEd25519KeyPair() (*Key, error) {
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+	}
+
+	return newGeneratedKey(privKey, AlgorithmEdDSA), nil
+}
+
+// wrap a fresh private key w/ default metadata
+func newGeneratedKey(privKey crypto.Signer, alg string) *Key {
 	now := time.Now()
-	keyID := generateKID()
 
 	return &Key{
-		ID:         keyID,
+		ID:         generateKID(),
+		Algorithm:  alg,
+		Usage:      "sig",
 		CreatedAt:  now,
+		NotBefore:  now,
 		ExpiresAt:  now.Add(10 * time.Minute), // default 10min expiry
 		PrivateKey: privKey,
-		PublicKey:  &privKey.PublicKey,
-	}, nil
+		PublicKey:  privKey.Public(),
+	}
 }
 
 // gen unique key ID
@@ -1,21 +1,153 @@
 package keys
 
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// ErrKeyNotFound indicates the JWKS has no key with the requested kid
+var ErrKeyNotFound = fmt.Errorf("key not found in JWKS")
+
 // JWKS response format
 type JWKS struct {
 	Keys []map[string]interface{} `json:"keys"`
 }
 
-// get JWKS format - only valid keys
+// get JWKS format - only valid keys. Shared with the keyring cache, so callers must not modify it.
 func (m *Manager) GetJWKS() (*JWKS, error) {
-	validKeys := m.GetValidKeys()
+	return m.loadKeyring().jwks, nil
+}
+
+// JWKSJSON returns the pre-serialized JWKS response body - shared, callers must not modify it
+func (m *Manager) JWKSJSON() ([]byte, error) {
+	return m.loadKeyring().jwksJSON, nil
+}
+
+// PublicKey looks up a signing key by kid - usable directly as a jwt.KeyFunc
+func (j *JWKS) PublicKey(kid string) (crypto.PublicKey, error) {
+	for _, jwk := range j.Keys {
+		if id, _ := jwk["kid"].(string); id == kid {
+			return ParsePublicJWK(jwk)
+		}
+	}
+	return nil, fmt.Errorf("%w: kid %q", ErrKeyNotFound, kid)
+}
+
+// ParsePublicJWK converts a JWK back into a public key based on its kty
+func ParsePublicJWK(jwk map[string]interface{}) (crypto.PublicKey, error) {
+	switch jwk["kty"] {
+	case "RSA":
+		return ParseRSAPublicJWK(jwk)
+	case "EC":
+		return ParseECPublicJWK(jwk)
+	case "OKP":
+		return ParseOKPPublicJWK(jwk)
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", jwk["kty"])
+	}
+}
+
+// ParseRSAPublicJWK converts an RSA JWK back into a public key
+func ParseRSAPublicJWK(jwk map[string]interface{}) (*rsa.PublicKey, error) {
+	if kty, _ := jwk["kty"].(string); kty != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %v", jwk["kty"])
+	}
+
+	n, err := decodeJWKInt(jwk, "n")
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := decodeJWKInt(jwk, "e")
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid RSA exponent")
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+// ParseECPublicJWK converts a P-256 JWK back into a public key
+func ParseECPublicJWK(jwk map[string]interface{}) (*ecdsa.PublicKey, error) {
+	if kty, _ := jwk["kty"].(string); kty != "EC" {
+		return nil, fmt.Errorf("unsupported key type %v", jwk["kty"])
+	}
+
+	if crv, _ := jwk["crv"].(string); crv != "P-256" {
+		return nil, fmt.Errorf("unsupported EC curve %v", jwk["crv"])
+	}
+
+	x, err := decodeJWKInt(jwk, "x")
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := decodeJWKInt(jwk, "y")
+	if err != nil {
+		return nil, err
+	}
+
+	// reject points off the curve - invalid curve attacks
+	if x.BitLen() > 256 || y.BitLen() > 256 {
+		return nil, fmt.Errorf("EC coordinates too large for P-256")
+	}
+	point := append([]byte{4}, x.FillBytes(make([]byte, 32))...)
+	point = append(point, y.FillBytes(make([]byte, 32))...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("EC point is not on curve P-256: %w", err)
+	}
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+// ParseOKPPublicJWK converts an Ed25519 JWK back into a public key
+func ParseOKPPublicJWK(jwk map[string]interface{}) (ed25519.PublicKey, error) {
+	if kty, _ := jwk["kty"].(string); kty != "OKP" {
+		return nil, fmt.Errorf("unsupported key type %v", jwk["kty"])
+	}
+
+	if crv, _ := jwk["crv"].(string); crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported OKP curve %v", jwk["crv"])
+	}
+
+	encoded, ok := jwk["x"].(string)
+	if !ok || encoded == "" {
+		return nil, fmt.Errorf("JWK missing %q", "x")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64url in JWK %q: %w", "x", err)
+	}
+
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Ed25519 public key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+
+	return ed25519.PublicKey(raw), nil
+}
 
-	jwks := &JWKS{
-		Keys: make([]map[string]interface{}, 0, len(validKeys)),
+// helper - decode a base64url big-endian integer member
+func decodeJWKInt(jwk map[string]interface{}, member string) (*big.Int, error) {
+	encoded, ok := jwk[member].(string)
+	if !ok || encoded == "" {
+		return nil, fmt.Errorf("JWK missing %q", member)
 	}
 
-	for _, key := range validKeys {
-		jwks.Keys = append(jwks.Keys, key.ToJWK())
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64url in JWK %q: %w", member, err)
 	}
 
-	return jwks, nil
+	return new(big.Int).SetBytes(raw), nil
 }
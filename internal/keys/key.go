@@ -1,17 +1,114 @@
 package keys
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
+	"fmt"
 	"time"
 )
 
-// RSA key pair w/ metadata
+// supported signing algorithms
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmRS384 = "RS384"
+	AlgorithmRS512 = "RS512"
+	AlgorithmPS256 = "PS256"
+	AlgorithmPS384 = "PS384"
+	AlgorithmPS512 = "PS512"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// RSA modulus sizes accepted for new keys
+const DefaultRSAKeySize = 2048
+
+var rsaKeySizes = map[int]bool{2048: true, 3072: true, 4096: true}
+
+// rsaAlgorithms share RSA keys - the alg can't be derived from the key alone
+var rsaAlgorithms = map[string]bool{
+	AlgorithmRS256: true,
+	AlgorithmRS384: true,
+	AlgorithmRS512: true,
+	AlgorithmPS256: true,
+	AlgorithmPS384: true,
+	AlgorithmPS512: true,
+}
+
+// ValidateAlgorithm checks alg is one we can generate keys for and sign with
+func ValidateAlgorithm(alg string) error {
+	if rsaAlgorithms[alg] || alg == AlgorithmES256 || alg == AlgorithmEdDSA {
+		return nil
+	}
+	return fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+// ValidateRSAKeySize checks bits is an allowed RSA modulus size
+func ValidateRSAKeySize(bits int) error {
+	if !rsaKeySizes[bits] {
+		return fmt.Errorf("unsupported RSA key size %d: must be 2048, 3072 or 4096", bits)
+	}
+	return nil
+}
+
+// signing key pair w/ metadata - RSA (RS*/PS*), EC P-256 or Ed25519
 type Key struct {
 	ID         string
+	Algorithm  string
+	KeySize    int    // bits
+	Usage      string // JWK "use" - sig
+	Origin     string // rotation, import or test
+	Status     string // lifecycle state - pending, active, retired or purged
 	CreatedAt  time.Time
+	NotBefore  time.Time // when the key may start signing
 	ExpiresAt  time.Time
-	PrivateKey *rsa.PrivateKey
-	PublicKey  *rsa.PublicKey
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// NewKey wraps a stored private key. An empty alg is derived from the key type,
+// otherwise it must be compatible with the key.
+func NewKey(id, alg string, privateKey crypto.Signer, createdAt, expiresAt time.Time) (*Key, error) {
+	defaultAlg, err := AlgorithmForKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case alg == "":
+		alg = defaultAlg
+	case defaultAlg == AlgorithmRS256 && rsaAlgorithms[alg]:
+	case alg != defaultAlg:
+		return nil, fmt.Errorf("algorithm %s does not match %T", alg, privateKey.Public())
+	}
+
+	return &Key{
+		ID:         id,
+		Algorithm:  alg,
+		CreatedAt:  createdAt,
+		ExpiresAt:  expiresAt,
+		PrivateKey: privateKey,
+		PublicKey:  privateKey.Public(),
+	}, nil
+}
+
+// AlgorithmForKey returns the default JWS alg for a private key - RS256 for any RSA key
+func AlgorithmForKey(privateKey crypto.Signer) (string, error) {
+	switch pub := privateKey.Public().(type) {
+	case *rsa.PublicKey:
+		return AlgorithmRS256, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported EC curve %s", pub.Curve.Params().Name)
+		}
+		return AlgorithmES256, nil
+	case ed25519.PublicKey:
+		return AlgorithmEdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", pub)
+	}
 }
 
 // check if key expired
@@ -21,14 +118,46 @@ func (k *Key) IsExpired(now time.Time) bool {
 
 // convert to JWK format for JWKS
 func (k *Key) ToJWK() map[string]interface{} {
-	return map[string]interface{}{
-		"kty": "RSA",
-		"use": "sig",
+	usage := k.Usage
+	if usage == "" {
+		usage = "sig"
+	}
+
+	jwk := map[string]interface{}{
+		"use": usage,
 		"kid": k.ID,
-		"n":   encodeBase64URL(k.PublicKey.N.Bytes()),
-		"e":   encodeBase64URL(intToBytes(k.PublicKey.E)),
-		"alg": "RS256",
+		"alg": k.Algorithm,
 	}
+
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = encodeBase64URL(pub.N.Bytes())
+		jwk["e"] = encodeBase64URL(intToBytes(pub.E))
+		if k.Algorithm == "" {
+			jwk["alg"] = AlgorithmRS256
+		}
+	case *ecdsa.PublicKey:
+		// coordinates are fixed width per RFC 7518 6.2.1.2
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = encodeBase64URL(pub.X.FillBytes(make([]byte, size)))
+		jwk["y"] = encodeBase64URL(pub.Y.FillBytes(make([]byte, size)))
+		if k.Algorithm == "" {
+			jwk["alg"] = AlgorithmES256
+		}
+	case ed25519.PublicKey:
+		// RFC 8037 2 - octet key pair, x is the raw public key
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = encodeBase64URL(pub)
+		if k.Algorithm == "" {
+			jwk["alg"] = AlgorithmEdDSA
+		}
+	}
+
+	return jwk
 }
 
 // helper - convert int to bytes
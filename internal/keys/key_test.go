@@ -1,6 +1,11 @@
 package keys
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
 	"testing"
 	"time"
 )
@@ -36,8 +41,8 @@ func TestGenerateRSAKeyPair(t *testing.T) {
 	}
 
 	// test key size
-	if key.PrivateKey.N.BitLen() != 2048 {
-		t.Errorf("Expected 2048-bit key, got %d-bit", key.PrivateKey.N.BitLen())
+	if key.PrivateKey.(*rsa.PrivateKey).N.BitLen() != 2048 {
+		t.Errorf("Expected 2048-bit key, got %d-bit", key.PrivateKey.(*rsa.PrivateKey).N.BitLen())
 	}
 }
 
@@ -167,3 +172,264 @@ func TestEncodeBase64URL(t *testing.T) {
 		}
 	}
 }
+
+func TestJWKSPublicKey(t *testing.T) {
+	key, err := GenerateRSAKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateRSAKeyPair() error = %v", err)
+	}
+
+	jwks := &JWKS{Keys: []map[string]interface{}{key.ToJWK()}}
+
+	pub, err := jwks.PublicKey(key.ID)
+	if err != nil {
+		t.Fatalf("PublicKey() error = %v", err)
+	}
+
+	if !key.PublicKey.(*rsa.PublicKey).Equal(pub) {
+		t.Error("Parsed public key does not match original")
+	}
+
+	if _, err := jwks.PublicKey("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestParseRSAPublicJWKInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  map[string]interface{}
+	}{
+		{"wrong kty", map[string]interface{}{"kty": "oct", "n": "AQAB", "e": "AQAB"}},
+		{"missing n", map[string]interface{}{"kty": "RSA", "e": "AQAB"}},
+		{"bad base64", map[string]interface{}{"kty": "RSA", "n": "***", "e": "AQAB"}},
+		{"exponent too small", map[string]interface{}{"kty": "RSA", "n": "AQAB", "e": "AQ"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRSAPublicJWK(tt.jwk); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestGenerateECKeyPair(t *testing.T) {
+	key, err := GenerateKeyPair(AlgorithmES256)
+	if err != nil {
+		t.Fatalf("GenerateKeyPair(ES256) error = %v", err)
+	}
+
+	if key.Algorithm != AlgorithmES256 {
+		t.Errorf("Expected algorithm ES256, got %s", key.Algorithm)
+	}
+
+	ecKey, ok := key.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		t.Fatalf("Expected *ecdsa.PrivateKey, got %T", key.PrivateKey)
+	}
+
+	if ecKey.Curve != elliptic.P256() {
+		t.Errorf("Expected P-256 curve, got %s", ecKey.Curve.Params().Name)
+	}
+
+	if _, err := GenerateKeyPair("HS256"); err == nil {
+		t.Error("Expected error for unsupported algorithm")
+	}
+}
+
+func TestECKeyToJWK(t *testing.T) {
+	key, err := GenerateECKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateECKeyPair() error = %v", err)
+	}
+
+	jwk := key.ToJWK()
+
+	expected := map[string]string{"kty": "EC", "crv": "P-256", "alg": "ES256", "use": "sig"}
+	for field, value := range expected {
+		if jwk[field] != value {
+			t.Errorf("Expected %s=%s, got %v", field, value, jwk[field])
+		}
+	}
+
+	// coordinates are always 32 bytes -> 43 base64url chars
+	for _, coord := range []string{"x", "y"} {
+		if encoded, _ := jwk[coord].(string); len(encoded) != 43 {
+			t.Errorf("Expected 43 char %s coordinate, got %d", coord, len(encoded))
+		}
+	}
+
+	if _, exists := jwk["n"]; exists {
+		t.Error("EC JWK should not contain RSA modulus")
+	}
+
+	// round trip through the JWKS lookup
+	jwks := &JWKS{Keys: []map[string]interface{}{jwk}}
+	pub, err := jwks.PublicKey(key.ID)
+	if err != nil {
+		t.Fatalf("PublicKey() error = %v", err)
+	}
+
+	if !key.PublicKey.(*ecdsa.PublicKey).Equal(pub) {
+		t.Error("Parsed EC public key does not match original")
+	}
+}
+
+func TestParseECPublicJWKInvalid(t *testing.T) {
+	key, err := GenerateECKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateECKeyPair() error = %v", err)
+	}
+	valid := key.ToJWK()
+
+	offCurve := key.ToJWK()
+	offCurve["y"] = offCurve["x"]
+
+	tests := []struct {
+		name string
+		jwk  map[string]interface{}
+	}{
+		{"wrong curve", map[string]interface{}{"kty": "EC", "crv": "P-384", "x": valid["x"], "y": valid["y"]}},
+		{"missing y", map[string]interface{}{"kty": "EC", "crv": "P-256", "x": valid["x"]}},
+		{"point off curve", offCurve},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseECPublicJWK(tt.jwk); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestEd25519KeyToJWK(t *testing.T) {
+	key, err := GenerateKeyPair(AlgorithmEdDSA)
+	if err != nil {
+		t.Fatalf("GenerateKeyPair(EdDSA) error = %v", err)
+	}
+
+	if _, ok := key.PrivateKey.(ed25519.PrivateKey); !ok {
+		t.Fatalf("Expected ed25519.PrivateKey, got %T", key.PrivateKey)
+	}
+
+	jwk := key.ToJWK()
+
+	expected := map[string]string{"kty": "OKP", "crv": "Ed25519", "alg": "EdDSA", "use": "sig"}
+	for field, value := range expected {
+		if jwk[field] != value {
+			t.Errorf("Expected %s=%s, got %v", field, value, jwk[field])
+		}
+	}
+
+	// 32 byte public key -> 43 base64url chars
+	if encoded, _ := jwk["x"].(string); len(encoded) != 43 {
+		t.Errorf("Expected 43 char x, got %d", len(encoded))
+	}
+
+	jwks := &JWKS{Keys: []map[string]interface{}{jwk}}
+	pub, err := jwks.PublicKey(key.ID)
+	if err != nil {
+		t.Fatalf("PublicKey() error = %v", err)
+	}
+
+	if !key.PublicKey.(ed25519.PublicKey).Equal(pub) {
+		t.Error("Parsed Ed25519 public key does not match original")
+	}
+}
+
+func TestParseOKPPublicJWKInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  map[string]interface{}
+	}{
+		{"wrong curve", map[string]interface{}{"kty": "OKP", "crv": "X25519", "x": encodeBase64URL(make([]byte, 32))}},
+		{"short key", map[string]interface{}{"kty": "OKP", "crv": "Ed25519", "x": encodeBase64URL(make([]byte, 16))}},
+		{"missing x", map[string]interface{}{"kty": "OKP", "crv": "Ed25519"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseOKPPublicJWK(tt.jwk); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestGenerateKeyPairWithSize(t *testing.T) {
+	key, err := GenerateKeyPairWithSize(AlgorithmPS256, 3072)
+	if err != nil {
+		t.Fatalf("GenerateKeyPairWithSize(PS256, 3072) error = %v", err)
+	}
+
+	if bits := key.PrivateKey.(*rsa.PrivateKey).N.BitLen(); bits != 3072 {
+		t.Errorf("Expected 3072 bit key, got %d", bits)
+	}
+
+	jwk := key.ToJWK()
+	if jwk["alg"] != AlgorithmPS256 || jwk["kty"] != "RSA" {
+		t.Errorf("Expected RSA JWK with alg PS256, got kty=%v alg=%v", jwk["kty"], jwk["alg"])
+	}
+
+	invalid := []struct {
+		alg  string
+		bits int
+	}{
+		{AlgorithmRS256, 1024},
+		{AlgorithmPS512, 2047},
+		{"HS256", 2048},
+	}
+	for _, tt := range invalid {
+		if _, err := GenerateKeyPairWithSize(tt.alg, tt.bits); err == nil {
+			t.Errorf("Expected error for %s/%d", tt.alg, tt.bits)
+		}
+	}
+}
+
+func TestNewKeyAlgorithm(t *testing.T) {
+	rsaKey, err := GenerateRSAKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateRSAKeyPair() error = %v", err)
+	}
+
+	ecKey, err := GenerateECKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateECKeyPair() error = %v", err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		alg      string
+		key      *Key
+		expected string
+		wantErr  bool
+	}{
+		{"derived rsa", "", rsaKey, AlgorithmRS256, false},
+		{"stored pss", AlgorithmPS384, rsaKey, AlgorithmPS384, false},
+		{"derived ec", "", ecKey, AlgorithmES256, false},
+		{"rsa alg on ec key", AlgorithmRS512, ecKey, "", true},
+		{"ec alg on rsa key", AlgorithmES256, rsaKey, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := NewKey("1", tt.alg, tt.key.PrivateKey, now, now.Add(time.Hour))
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewKey() error = %v", err)
+			}
+			if key.Algorithm != tt.expected {
+				t.Errorf("Expected algorithm %s, got %s", tt.expected, key.Algorithm)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"csce-3550_jwks-srv/internal/db"
+)
+
+// upper bound on how long a keyring is served without reloading - picks up
+// changes made to the store outside this manager (migrate CLI, another process)
+const keyringMaxAge = time.Minute
+
+// keyring is a decrypted snapshot of the published keys plus the JWKS built from them.
+// It is immutable once built; changes swap in a new one.
+type keyring struct {
+	published     []*Key // pending, active and retired keys in kid order
+	active        *Key   // newest active key, nil if none
+	latestExpired *Key   // most recently expired key, nil if none
+	jwks          *JWKS
+	jwksJSON      []byte    // GET /jwks response body
+	validUntil    time.Time // next time a key changes state
+}
+
+// current keyring - rebuilt from the store when missing or past validUntil
+func (m *Manager) loadKeyring() *keyring {
+	if ring := m.ring.Load(); ring != nil && time.Now().Before(ring.validUntil) {
+		return ring
+	}
+
+	// one rebuild at a time - concurrent misses wait for it instead of all hitting the store
+	m.ringMu.Lock()
+	defer m.ringMu.Unlock()
+
+	now := time.Now()
+	if ring := m.ring.Load(); ring != nil && now.Before(ring.validUntil) {
+		return ring
+	}
+
+	generation := m.ringGeneration.Load()
+	ring, err := m.buildKeyring(now)
+	if err != nil {
+		fmt.Printf("Failed to load keyring: %v\n", err)
+		return emptyKeyring()
+	}
+
+	// a change landed mid-build - serve this snapshot once but don't cache it
+	if m.ringGeneration.Load() == generation {
+		m.ring.Store(ring)
+	}
+	m.saveJWKSCache(ring.jwksJSON)
+	return ring
+}
+
+// copy the JWKS to stores that serve it while sealed - only when it changed.
+// Called with ringMu held.
+func (m *Manager) saveJWKSCache(body []byte) {
+	cache, ok := m.store.(db.JWKSCache)
+	if !ok || bytes.Equal(body, m.savedJWKS) {
+		return
+	}
+	if err := cache.SaveJWKSCache(body); err != nil {
+		fmt.Printf("Failed to save JWKS cache: %v\n", err)
+		return
+	}
+	m.savedJWKS = body
+}
+
+// drop the cached keyring - called after every change the manager makes to the store
+func (m *Manager) invalidateKeyring() {
+	m.ringGeneration.Add(1)
+	m.ring.Store(nil)
+}
+
+// decrypt the published keys once and precompute everything the hot paths serve
+func (m *Manager) buildKeyring(now time.Time) (*keyring, error) {
+	m.advanceKeyStates()
+
+	stored, err := m.store.GetValidKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load published keys: %w", err)
+	}
+
+	// oldest first so the JWKS is stable between requests
+	kids := make([]int, 0, len(stored))
+	for kid := range stored {
+		kids = append(kids, kid)
+	}
+	sort.Ints(kids)
+
+	m.mu.RLock()
+	retirePeriod := m.retirePeriod
+	m.mu.RUnlock()
+
+	ring := &keyring{
+		published:  make([]*Key, 0, len(stored)),
+		jwks:       &JWKS{Keys: make([]map[string]interface{}, 0, len(stored))},
+		validUntil: now.Add(keyringMaxAge),
+	}
+
+	// the snapshot is stale as soon as any key expires, activates or is purged
+	changesAt := func(t time.Time) {
+		if t.After(now) && t.Before(ring.validUntil) {
+			ring.validUntil = t
+		}
+	}
+
+	for _, kid := range kids {
+		key, err := m.keyFromStored(stored[kid])
+		if err != nil {
+			continue
+		}
+
+		ring.published = append(ring.published, key)
+		ring.jwks.Keys = append(ring.jwks.Keys, key.ToJWK())
+
+		// same rule as db.GetActiveKey - newest active key signs
+		if key.Status == db.KeyStatusActive {
+			ring.active = key
+		}
+
+		changesAt(key.ExpiresAt)
+		switch key.Status {
+		case db.KeyStatusPending:
+			changesAt(key.NotBefore)
+		case db.KeyStatusRetired:
+			changesAt(stored[kid].RetiredAt.Add(retirePeriod))
+		}
+	}
+
+	expired, err := m.store.GetLatestExpiredKey()
+	switch {
+	case errors.Is(err, db.ErrKeyNotFound):
+	case err != nil:
+		return nil, fmt.Errorf("failed to load expired key: %w", err)
+	default:
+		if ring.latestExpired, err = m.keyFromStored(expired); err != nil {
+			return nil, fmt.Errorf("failed to load expired key %d: %w", expired.Kid, err)
+		}
+	}
+
+	// encoded the way json.Encoder writes it, trailing newline included
+	body, err := json.Marshal(ring.jwks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JWKS: %w", err)
+	}
+	ring.jwksJSON = append(body, '\n')
+
+	return ring, nil
+}
+
+// served when the store can't be read - retried on the next call
+func emptyKeyring() *keyring {
+	jwks := &JWKS{Keys: []map[string]interface{}{}}
+	body, _ := json.Marshal(jwks)
+	return &keyring{
+		published: []*Key{},
+		jwks:      jwks,
+		jwksJSON:  append(body, '\n'),
+	}
+}
@@ -0,0 +1,181 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"csce-3550_jwks-srv/internal/db"
+)
+
+// countingStore counts the loads that decrypt every published key
+type countingStore struct {
+	db.Store
+	loads atomic.Int32
+}
+
+func (s *countingStore) GetValidKeys() (map[int]*db.StoredKey, error) {
+	s.loads.Add(1)
+	return s.Store.GetValidKeys()
+}
+
+func TestKeyringCachesDecryptedKeys(t *testing.T) {
+	store := &countingStore{Store: db.NewMemoryStore()}
+	manager := NewManagerWithStore(store, time.Hour, time.Hour)
+
+	if err := manager.rotateKeyAt(time.Now()); err != nil {
+		t.Fatalf("rotateKeyAt() error = %v", err)
+	}
+	store.loads.Store(0)
+
+	for i := 0; i < 10; i++ {
+		manager.GetValidKeys()
+		manager.GetSigningKey(false)
+		manager.GetSigningKey(true)
+		if _, err := manager.JWKSJSON(); err != nil {
+			t.Fatalf("JWKSJSON() error = %v", err)
+		}
+	}
+	if loads := store.loads.Load(); loads != 1 {
+		t.Errorf("Expected 1 store load for repeated reads, got %d", loads)
+	}
+
+	// rotation invalidates the keyring
+	if err := manager.rotateKeyAt(time.Now()); err != nil {
+		t.Fatalf("rotateKeyAt() error = %v", err)
+	}
+	manager.mu.RLock()
+	rotated := manager.currentKey.ID
+	manager.mu.RUnlock()
+
+	if kid := manager.ActiveKeyID(); kid != rotated {
+		t.Errorf("Expected rotated key %s to sign after invalidation, got %s", rotated, kid)
+	}
+	if loads := store.loads.Load(); loads != 2 {
+		t.Errorf("Expected a reload after rotation, got %d loads", loads)
+	}
+}
+
+func TestKeyringExpiresWithEarliestKey(t *testing.T) {
+	store := db.NewMemoryStore()
+	manager := NewManagerWithStore(store, time.Hour, time.Hour)
+
+	key, err := GenerateECKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateECKeyPair() error = %v", err)
+	}
+
+	// a key expiring before the max age bounds the snapshot
+	expiry := time.Now().Add(30 * time.Second).Truncate(time.Second)
+	if _, err := store.StoreKey(key.PrivateKey, key.Algorithm, expiry); err != nil {
+		t.Fatalf("StoreKey() error = %v", err)
+	}
+
+	ring := manager.loadKeyring()
+	if !ring.validUntil.Equal(expiry) {
+		t.Errorf("Expected keyring valid until %v, got %v", expiry, ring.validUntil)
+	}
+
+	// a pending key bounds it by its activation time
+	activateAt := time.Now().Add(10 * time.Second).Truncate(time.Second)
+	if _, err := store.StorePendingKey(key.PrivateKey, key.Algorithm, activateAt, expiry); err != nil {
+		t.Fatalf("StorePendingKey() error = %v", err)
+	}
+	manager.invalidateKeyring()
+
+	ring = manager.loadKeyring()
+	if !ring.validUntil.Equal(activateAt) {
+		t.Errorf("Expected keyring valid until activation %v, got %v", activateAt, ring.validUntil)
+	}
+}
+
+func TestJWKSJSONMatchesGetJWKS(t *testing.T) {
+	forEachBackend(t, time.Hour, time.Hour, func(t *testing.T, manager *Manager) {
+		if err := manager.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+
+		jwks, err := manager.GetJWKS()
+		if err != nil {
+			t.Fatalf("GetJWKS() error = %v", err)
+		}
+		var expected bytes.Buffer
+		if err := json.NewEncoder(&expected).Encode(jwks); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+
+		body, err := manager.JWKSJSON()
+		if err != nil {
+			t.Fatalf("JWKSJSON() error = %v", err)
+		}
+		if !bytes.Equal(body, expected.Bytes()) {
+			t.Errorf("JWKSJSON() = %s, want %s", body, expected.Bytes())
+		}
+		if len(jwks.Keys) != len(manager.GetValidKeys()) {
+			t.Errorf("JWKS has %d keys, %d published", len(jwks.Keys), len(manager.GetValidKeys()))
+		}
+	})
+}
+
+// benchmarkManager starts a manager on an encrypted SQLite store - the production setup
+func benchmarkManager(b *testing.B) *Manager {
+	b.Helper()
+	store, err := db.NewManager(filepath.Join(b.TempDir(), "bench.db"), "test-encryption-key-123")
+	if err != nil {
+		b.Fatalf("db.NewManager() error = %v", err)
+	}
+	b.Cleanup(func() { store.Close() })
+
+	manager := NewManagerWithStore(store, time.Hour, time.Hour)
+	if err := manager.Start(); err != nil {
+		b.Fatalf("Start() error = %v", err)
+	}
+	b.Cleanup(manager.Stop)
+	return manager
+}
+
+// uncached drops the keyring before every call, as every request used to decrypt
+func BenchmarkManagerJWKS(b *testing.B) {
+	manager := benchmarkManager(b)
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := manager.JWKSJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			manager.invalidateKeyring()
+			if _, err := manager.JWKSJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkManagerSigningKey(b *testing.B) {
+	manager := benchmarkManager(b)
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if manager.GetSigningKey(false) == nil {
+				b.Fatal("no signing key")
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			manager.invalidateKeyring()
+			if manager.GetSigningKey(false) == nil {
+				b.Fatal("no signing key")
+			}
+		}
+	})
+}
@@ -1,44 +1,170 @@
 package keys
 
 import (
-	"crypto/rsa"
 	"fmt"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"csce-3550_jwks-srv/internal/db"
 )
 
-// key mgr - handles RSA key pairs w/ rotation
+// key mgr - handles signing key pairs w/ rotation
 type Manager struct {
 	keyLifetime     time.Duration
 	keyRetainPeriod time.Duration
+	algorithm       string
+	rsaKeySize      int
+	leadTime        time.Duration // how long a new key is published before it signs
+	retirePeriod    time.Duration // how long a replaced key stays published
+	cleanupInterval time.Duration // how often expired keys are purged
+	purgeDryRun     bool          // log what retention would purge without deleting
 	keys            map[string]*Key
 	currentKey      *Key
 	mu              sync.RWMutex
 	stopCh          chan struct{}
-	database        *db.Database
-	dbManager       *db.Manager
+	rotationReset   chan struct{} // wakes rotationLoop after the key lifetime changes
+	cleanupReset    chan struct{} // wakes cleanupLoop after the cleanup interval changes
+	store           db.Store
+
+	// decrypted key cache - see keyring.go
+	ring           atomic.Pointer[keyring]
+	ringMu         sync.Mutex
+	ringGeneration atomic.Uint64
+	savedJWKS      []byte // last JWKS written to a db.JWKSCache store, guarded by ringMu
 }
 
-// create new key mgr
+// create new key mgr on the default database
 func NewManager(keyLifetime, keyRetainPeriod time.Duration, encryptionKey string) (*Manager, error) {
+	return NewManagerAt("", keyLifetime, keyRetainPeriod, encryptionKey)
+}
+
+// create new key mgr on the database at dbPath - empty for the default, db.MemoryPath for an ephemeral one
+func NewManagerAt(dbPath string, keyLifetime, keyRetainPeriod time.Duration, encryptionKey string) (*Manager, error) {
 	// Use encryption key from config - this creates the database with schema
-	dbManager, err := db.NewManager("", encryptionKey)
+	dbManager, err := db.NewManager(dbPath, encryptionKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create database manager: %w", err)
 	}
 
-	// No need for separate database instance - use the encrypted manager's database
+	return NewManagerWithStore(dbManager, keyLifetime, keyRetainPeriod), nil
+}
+
+// create new key mgr on any storage backend
+func NewManagerWithStore(store db.Store, keyLifetime, keyRetainPeriod time.Duration) *Manager {
 	return &Manager{
 		keyLifetime:     keyLifetime,
 		keyRetainPeriod: keyRetainPeriod,
+		algorithm:       AlgorithmRS256,
+		rsaKeySize:      DefaultRSAKeySize,
+		retirePeriod:    keyLifetime,
+		cleanupInterval: time.Hour,
 		keys:            make(map[string]*Key),
 		stopCh:          make(chan struct{}),
-		database:        nil, // Remove dual database setup
-		dbManager:       dbManager,
-	}, nil
+		rotationReset:   make(chan struct{}, 1),
+		cleanupReset:    make(chan struct{}, 1),
+		store:           store,
+	}
+}
+
+// SetAlgorithm picks the algorithm for newly generated keys - existing keys keep theirs
+func (m *Manager) SetAlgorithm(alg string) error {
+	if err := ValidateAlgorithm(alg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.algorithm = alg
+	return nil
+}
+
+// SetRSAKeySize picks the modulus size for newly generated RSA keys
+func (m *Manager) SetRSAKeySize(bits int) error {
+	if err := ValidateRSAKeySize(bits); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rsaKeySize = bits
+	return nil
+}
+
+// SetLifetimes sets how often keys rotate (keyLifetime) and how long expired keys are
+// kept before they're purged - a running rotation loop picks up the new interval
+func (m *Manager) SetLifetimes(keyLifetime, retainPeriod time.Duration) error {
+	if keyLifetime <= 0 {
+		return fmt.Errorf("key lifetime must be positive")
+	}
+	if retainPeriod < 0 {
+		return fmt.Errorf("key retain period must not be negative")
+	}
+
+	m.mu.Lock()
+	changed := m.keyLifetime != keyLifetime
+	m.keyLifetime = keyLifetime
+	m.keyRetainPeriod = retainPeriod
+	m.mu.Unlock()
+
+	if changed {
+		wake(m.rotationReset)
+	}
+	return nil
+}
+
+// SetRotationTiming sets how long new keys are pre-published before signing (leadTime)
+// and how long replaced keys stay published (retirePeriod) - use the JWT lifetime so
+// every token a key signed can still be verified
+func (m *Manager) SetRotationTiming(leadTime, retirePeriod time.Duration) error {
+	if leadTime < 0 || retirePeriod < 0 {
+		return fmt.Errorf("rotation lead time and retire period must not be negative")
+	}
+
+	m.mu.Lock()
+	m.leadTime = leadTime
+	m.retirePeriod = retirePeriod
+	m.mu.Unlock()
+
+	// the retire period decides when retired keys drop out of the keyring
+	m.invalidateKeyring()
+	return nil
+}
+
+// SetCleanupPolicy sets how often expired keys are purged and whether purges are dry runs
+func (m *Manager) SetCleanupPolicy(interval time.Duration, dryRun bool) error {
+	if interval <= 0 {
+		return fmt.Errorf("cleanup interval must be positive")
+	}
+
+	m.mu.Lock()
+	changed := m.cleanupInterval != interval
+	m.cleanupInterval = interval
+	m.purgeDryRun = dryRun
+	m.mu.Unlock()
+
+	if changed {
+		wake(m.cleanupReset)
+	}
+	return nil
+}
+
+// non-blocking nudge - one pending wake-up is enough
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// gen a key per the configured algorithm policy
+func (m *Manager) generateKey() (*Key, error) {
+	m.mu.RLock()
+	alg, bits := m.algorithm, m.rsaKeySize
+	m.mu.RUnlock()
+
+	return GenerateKeyPairWithSize(alg, bits)
 }
 
 // start background rotation & cleanup
@@ -48,8 +174,8 @@ func (m *Manager) Start() error {
 		return fmt.Errorf("failed to generate encrypted test keys: %w", err)
 	}
 
-	// gen initial key
-	if err := m.rotateKey(); err != nil {
+	// gen initial key - signs right away since nothing else can
+	if err := m.rotateKeyAt(time.Now()); err != nil {
 		return fmt.Errorf("failed to generate initial key: %w", err)
 	}
 
@@ -71,8 +197,8 @@ func (m *Manager) generateEncryptedTestKeys() error {
 	}
 
 	for _, kp := range keyPairs {
-		// generate RSA key pair
-		privateKey, err := GenerateRSAKeyPair()
+		// generate key pair
+		privateKey, err := m.generateKey()
 		if err != nil {
 			return fmt.Errorf("failed to generate %s: %w", kp.name, err)
 		}
@@ -81,7 +207,14 @@ func (m *Manager) generateEncryptedTestKeys() error {
 		expTime := time.Now().Add(kp.duration)
 
 		// save to encrypted database
-		kid, err := m.dbManager.StoreKey(privateKey.PrivateKey, expTime)
+		kid, err := m.store.StoreKeyWithMetadata(privateKey.PrivateKey, db.KeyMetadata{
+			Algorithm:  privateKey.Algorithm,
+			Origin:     db.KeyOriginTest,
+			Status:     db.KeyStatusActive,
+			CreatedAt:  privateKey.CreatedAt,
+			ActivateAt: privateKey.CreatedAt,
+			ExpiresAt:  expTime,
+		})
 		if err != nil {
 			return fmt.Errorf("failed to save encrypted %s: %w", kp.name, err)
 		}
@@ -89,6 +222,7 @@ func (m *Manager) generateEncryptedTestKeys() error {
 		fmt.Printf("Generated encrypted %s with kid: %d, expires: %s\n", kp.name, kid, expTime.Format(time.RFC3339))
 	}
 
+	m.invalidateKeyring()
 	return nil
 }
 
@@ -101,77 +235,107 @@ func (m *Manager) Stop() {
 		close(m.stopCh)
 	}
 
-	// No need to close database - it's handled by dbManager
+	// the store is owned by whoever opened it
 }
 
-// get valid keys for JWKS endpoint
-func (m *Manager) GetValidKeys() []*Key {
-	// try encrypted keys first
-	encryptedKeys, err := m.dbManager.GetValidKeys()
-	if err == nil && len(encryptedKeys) > 0 {
-		keys := make([]*Key, 0, len(encryptedKeys))
-		for kidInt, privateKey := range encryptedKeys {
-			key := &Key{
-				ID:         strconv.Itoa(kidInt),
-				CreatedAt:  time.Now().Add(-m.keyLifetime), // approximate creation time
-				ExpiresAt:  time.Now().Add(m.keyLifetime),  // approximate expiry time
-				PrivateKey: privateKey,
-				PublicKey:  &privateKey.PublicKey,
-			}
-			keys = append(keys, key)
-		}
-		return keys
+// apply due key state transitions - failures leave the previous state in place
+func (m *Manager) advanceKeyStates() {
+	m.mu.RLock()
+	retirePeriod := m.retirePeriod
+	m.mu.RUnlock()
+
+	if err := m.store.AdvanceKeyStates(time.Now(), retirePeriod); err != nil {
+		fmt.Printf("Failed to advance key states: %v\n", err)
 	}
+}
 
-	// if no encrypted keys found, return empty slice
-	return []*Key{}
+// get published keys for JWKS endpoint - pending, active and retired, in kid order
+func (m *Manager) GetValidKeys() []*Key {
+	published := m.loadKeyring().published
+	return append([]*Key(nil), published...)
 }
 
-// get signing key for auth endpoint
+// get signing key for auth endpoint.
+// The active key is the newest unexpired one - older valid keys are verify-only.
+// expired picks the most recently expired key instead.
 func (m *Manager) GetSigningKey(expired bool) *Key {
-	// try encrypted keys first
-	var encryptedKeys map[int]*rsa.PrivateKey
-	var err error
-
+	ring := m.loadKeyring()
 	if expired {
-		encryptedKeys, err = m.dbManager.GetExpiredKeys()
-	} else {
-		encryptedKeys, err = m.dbManager.GetValidKeys()
-	}
-
-	if err == nil && len(encryptedKeys) > 0 {
-		// return first available encrypted key
-		for kidInt, privateKey := range encryptedKeys {
-			return &Key{
-				ID:         strconv.Itoa(kidInt),
-				CreatedAt:  time.Now().Add(-m.keyLifetime), // approximate creation time
-				ExpiresAt:  time.Now().Add(m.keyLifetime),  // approximate expiry time
-				PrivateKey: privateKey,
-				PublicKey:  &privateKey.PublicKey,
-			}
-		}
+		return ring.latestExpired
 	}
+	return ring.active
+}
 
-	// no encrypted keys found
-	return nil
+// ActiveKeyID returns the kid currently used to sign, empty if none
+func (m *Manager) ActiveKeyID() string {
+	if key := m.GetSigningKey(false); key != nil {
+		return key.ID
+	}
+	return ""
 }
 
-// rotate key - create new current key
+// helper - wrap a decrypted row as a Key
+func (m *Manager) keyFromStored(stored *db.StoredKey) (*Key, error) {
+	key, err := NewKey(
+		strconv.Itoa(stored.Kid),
+		stored.Algorithm,
+		stored.PrivateKey,
+		stored.CreatedAt,
+		stored.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.KeySize = stored.KeySize
+	key.Usage = stored.Usage
+	key.Origin = stored.Origin
+	key.Status = stored.Status
+	key.NotBefore = stored.ActivateAt
+	return key, nil
+}
+
+// rotate key - publish a new key that takes over signing after the lead time
 func (m *Manager) rotateKey() error {
-	newKey, err := GenerateRSAKeyPair()
+	m.mu.RLock()
+	leadTime := m.leadTime
+	m.mu.RUnlock()
+
+	return m.rotateKeyAt(time.Now().Add(leadTime))
+}
+
+// publish a new pending key that activates at activateAt.
+// It signs until its successor activates, then stays published for the retire period.
+func (m *Manager) rotateKeyAt(activateAt time.Time) error {
+	newKey, err := m.generateKey()
 	if err != nil {
 		return err
 	}
 
+	// the successor is only published a lead time after the next tick,
+	// then this key must outlive its retire period
+	m.mu.RLock()
+	expiry := activateAt.Add(m.keyLifetime + m.leadTime + m.retirePeriod)
+	m.mu.RUnlock()
+
 	// store the new key in encrypted database
-	expiry := time.Now().Add(m.keyLifetime)
-	kidInt, err := m.dbManager.StoreKey(newKey.PrivateKey, expiry)
+	kidInt, err := m.store.StorePendingKey(newKey.PrivateKey, newKey.Algorithm, activateAt, expiry)
 	if err != nil {
 		return fmt.Errorf("failed to store encrypted key: %w", err)
 	}
 
+	// promote it now if it's already due
+	m.advanceKeyStates()
+	m.invalidateKeyring()
+
 	// update the key ID to match database
 	newKey.ID = fmt.Sprintf("%d", kidInt)
+	newKey.Origin = db.KeyOriginRotation
+	newKey.NotBefore = activateAt
+	newKey.Status = db.KeyStatusPending
+	if !activateAt.After(time.Now()) {
+		newKey.Status = db.KeyStatusActive
+	}
 	newKey.ExpiresAt = expiry
 
 	m.mu.Lock()
@@ -180,18 +344,23 @@ func (m *Manager) rotateKey() error {
 	m.keys[newKey.ID] = newKey
 	m.currentKey = newKey
 
+	fmt.Printf("Published key kid: %s, alg: %s, signs from: %s, expires: %s\n",
+		newKey.ID, newKey.Algorithm, activateAt.Format(time.RFC3339), expiry.Format(time.RFC3339))
+
 	return nil
 }
 
-// background rotation loop
+// background rotation loop - restarts its interval when the key lifetime changes
 func (m *Manager) rotationLoop() {
-	ticker := time.NewTicker(m.keyLifetime)
+	ticker := time.NewTicker(m.interval(&m.keyLifetime))
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			m.rotateKey()
+		case <-m.rotationReset:
+			ticker.Reset(m.interval(&m.keyLifetime))
 		case <-m.stopCh:
 			return
 		}
@@ -200,27 +369,53 @@ func (m *Manager) rotationLoop() {
 
 // background cleanup loop - remove old expired keys
 func (m *Manager) cleanupLoop() {
-	ticker := time.NewTicker(time.Hour) // cleanup every hour
+	ticker := time.NewTicker(m.interval(&m.cleanupInterval))
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			m.cleanup()
+		case <-m.cleanupReset:
+			ticker.Reset(m.interval(&m.cleanupInterval))
 		case <-m.stopCh:
 			return
 		}
 	}
 }
 
-// cleanup expired keys beyond retain period
+// read a duration field under the lock
+func (m *Manager) interval(field *time.Duration) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return *field
+}
+
+// cleanup expired keys beyond retain period - from the database and the in-memory map
 func (m *Manager) cleanup() {
+	m.mu.RLock()
+	dryRun := m.purgeDryRun
+	retainUntil := time.Now().Add(-m.keyRetainPeriod)
+	m.mu.RUnlock()
+
+	purged, err := m.store.PurgeExpiredKeys(retainUntil, dryRun)
+	if err != nil {
+		fmt.Printf("Failed to purge expired keys: %v\n", err)
+	}
+	for _, key := range purged {
+		action := "Purged"
+		if dryRun {
+			action = "Dry run: would purge"
+		}
+		fmt.Printf("%s expired key kid: %d, alg: %s, expired: %s\n", action, key.Kid, key.Algorithm, key.ExpiresAt.Format(time.RFC3339))
+	}
+	if len(purged) > 0 && !dryRun {
+		m.invalidateKeyring()
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	now := time.Now()
-	retainUntil := now.Add(-m.keyRetainPeriod)
-
 	for id, key := range m.keys {
 		if key.ExpiresAt.Before(retainUntil) {
 			delete(m.keys, id)
@@ -230,10 +425,15 @@ func (m *Manager) cleanup() {
 
 // CreateUser creates a new user via the database manager
 func (m *Manager) CreateUser(username, email string) (string, error) {
-	return m.dbManager.CreateUser(username, email)
+	return m.store.CreateUser(username, email)
 }
 
 // LogAuthRequest logs an authentication request via the database manager
 func (m *Manager) LogAuthRequest(requestIP string, username string) error {
-	return m.dbManager.LogAuthRequest(requestIP, username)
+	return m.store.LogAuthRequest(requestIP, username)
+}
+
+// AuthenticateUser verifies user credentials via the database manager
+func (m *Manager) AuthenticateUser(username, password string) (*db.User, error) {
+	return m.store.AuthenticateUser(username, password)
 }
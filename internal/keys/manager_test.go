@@ -1,8 +1,13 @@
 package keys
 
 import (
+	"crypto/rsa"
+	"os"
+	"strconv"
 	"testing"
 	"time"
+
+	"csce-3550_jwks-srv/internal/db"
 )
 
 func TestNewManager(t *testing.T) {
@@ -37,201 +42,500 @@ func TestNewManager(t *testing.T) {
 }
 
 func TestManagerStart(t *testing.T) {
-	manager, err := NewManager(time.Minute, time.Hour, "test-encryption-key-123")
-	if err != nil {
-		t.Fatalf("NewManager error = %v", err)
-	}
-
-	// start should generate initial key
-	manager.Start()
-	defer manager.Stop()
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		// start should generate initial key
+		manager.Start()
+		defer manager.Stop()
 
-	// give it time to generate key
-	time.Sleep(100 * time.Millisecond)
+		// give it time to generate key
+		time.Sleep(100 * time.Millisecond)
 
-	if manager.currentKey == nil {
-		t.Error("Current key not set after start")
-	}
+		if manager.currentKey == nil {
+			t.Error("Current key not set after start")
+		}
 
-	if len(manager.keys) == 0 {
-		t.Error("No keys in manager after start")
-	}
+		if len(manager.keys) == 0 {
+			t.Error("No keys in manager after start")
+		}
+	})
 }
 
 func TestManagerGetValidKeys(t *testing.T) {
-	manager, err := NewManager(time.Minute, time.Hour, "test-encryption-key-123")
-	if err != nil {
-		t.Fatalf("NewManager error = %v", err)
-	}
-	manager.Start()
-	defer manager.Stop()
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		manager.Start()
+		defer manager.Stop()
 
-	// give it time to generate key
-	time.Sleep(100 * time.Millisecond)
+		// give it time to generate key
+		time.Sleep(100 * time.Millisecond)
 
-	validKeys := manager.GetValidKeys()
+		validKeys := manager.GetValidKeys()
 
-	if len(validKeys) == 0 {
-		t.Error("No valid keys returned")
-	}
+		if len(validKeys) == 0 {
+			t.Error("No valid keys returned")
+		}
 
-	// all returned keys should be valid
-	now := time.Now()
-	for _, key := range validKeys {
-		if key.IsExpired(now) {
-			t.Error("Expired key returned in valid keys")
+		// all returned keys should be valid
+		now := time.Now()
+		for _, key := range validKeys {
+			if key.IsExpired(now) {
+				t.Error("Expired key returned in valid keys")
+			}
 		}
-	}
+	})
 }
 
 func TestManagerGetSigningKey(t *testing.T) {
-	manager, err := NewManager(time.Minute, time.Hour, "test-encryption-key-123")
-	if err != nil {
-		t.Fatalf("NewManager error = %v", err)
-	}
-	manager.Start()
-	defer manager.Stop()
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		manager.Start()
+		defer manager.Stop()
+
+		// give it time to generate key
+		time.Sleep(100 * time.Millisecond)
+
+		// test getting valid signing key from database
+		signingKey := manager.GetSigningKey(false)
+		if signingKey == nil {
+			t.Error("No signing key returned")
+			return
+		}
 
-	// give it time to generate key
-	time.Sleep(100 * time.Millisecond)
+		// verify the key has a valid ID from database
+		if signingKey.ID == "" {
+			t.Error("Signing key should have a valid ID from database")
+		}
 
-	// test getting valid signing key from database
-	signingKey := manager.GetSigningKey(false)
-	if signingKey == nil {
-		t.Error("No signing key returned")
-		return
-	}
+		// test getting expired key (should return nil if no expired keys exist)
+		expiredKey := manager.GetSigningKey(true)
+		// Note: expiredKey might be nil if no expired keys exist yet, which is correct behavior
+		if expiredKey != nil {
+			// if we got an expired key, verify it has an ID
+			if expiredKey.ID == "" {
+				t.Error("Expired key should have a valid ID from database")
+			}
+		}
+	})
+}
 
-	// verify the key has a valid ID from database
-	if signingKey.ID == "" {
-		t.Error("Signing key should have a valid ID from database")
-	}
+func TestManagerGetJWKS(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		manager.Start()
+		defer manager.Stop()
+
+		// give it time to generate key
+		time.Sleep(100 * time.Millisecond)
 
-	// test getting expired key (should return nil if no expired keys exist)
-	expiredKey := manager.GetSigningKey(true)
-	// Note: expiredKey might be nil if no expired keys exist yet, which is correct behavior
-	if expiredKey != nil {
-		// if we got an expired key, verify it has an ID
-		if expiredKey.ID == "" {
-			t.Error("Expired key should have a valid ID from database")
+		jwks, err := manager.GetJWKS()
+		if err != nil {
+			t.Fatalf("GetJWKS() error = %v", err)
 		}
-	}
+
+		if jwks == nil {
+			t.Fatal("GetJWKS() returned nil")
+		}
+
+		if len(jwks.Keys) == 0 {
+			t.Error("No keys in JWKS")
+		}
+
+		// test JWKS structure
+		for _, key := range jwks.Keys {
+			if key["kty"] != "RSA" {
+				t.Error("Invalid key type in JWKS")
+			}
+
+			if key["kid"] == nil {
+				t.Error("Missing kid in JWKS key")
+			}
+		}
+	})
 }
 
-func TestManagerGetJWKS(t *testing.T) {
-	manager, err := NewManager(time.Minute, time.Hour, "test-encryption-key-123")
-	if err != nil {
-		t.Fatalf("NewManager error = %v", err)
-	}
-	manager.Start()
-	defer manager.Stop()
+func TestManagerRotateKey(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		// manually rotate key to test
+		err := manager.rotateKey()
+		if err != nil {
+			t.Fatalf("rotateKey() error = %v", err)
+		}
+
+		if manager.currentKey == nil {
+			t.Error("Current key not set after rotation")
+		}
 
-	// give it time to generate key
-	time.Sleep(100 * time.Millisecond)
+		oldKey := manager.currentKey
 
-	jwks, err := manager.GetJWKS()
-	if err != nil {
-		t.Fatalf("GetJWKS() error = %v", err)
-	}
+		// rotate again
+		err = manager.rotateKey()
+		if err != nil {
+			t.Fatalf("Second rotateKey() error = %v", err)
+		}
 
-	if jwks == nil {
-		t.Fatal("GetJWKS() returned nil")
-	}
+		if manager.currentKey == oldKey {
+			t.Error("Current key not updated after rotation")
+		}
 
-	if len(jwks.Keys) == 0 {
-		t.Error("No keys in JWKS")
-	}
+		// should have both keys
+		if len(manager.keys) != 2 {
+			t.Errorf("Expected 2 keys after rotation, got %d", len(manager.keys))
+		}
+	})
+}
 
-	// test JWKS structure
-	for _, key := range jwks.Keys {
-		if key["kty"] != "RSA" {
-			t.Error("Invalid key type in JWKS")
+func TestManagerCleanup(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Millisecond, func(t *testing.T, manager *Manager) { // very short retain period
+		// add an old expired key manually
+		oldKey := &Key{
+			ID:        "old-key",
+			CreatedAt: time.Now().Add(-2 * time.Hour),
+			ExpiresAt: time.Now().Add(-time.Hour),
 		}
 
-		if key["kid"] == nil {
-			t.Error("Missing kid in JWKS key")
+		manager.keys[oldKey.ID] = oldKey
+
+		// add current key
+		manager.rotateKey()
+
+		initialCount := len(manager.keys)
+
+		// run cleanup
+		time.Sleep(2 * time.Millisecond) // wait for retain period
+		manager.cleanup()
+
+		// old key should be removed
+		if len(manager.keys) >= initialCount {
+			t.Error("Cleanup did not remove old keys")
 		}
-	}
+
+		if _, exists := manager.keys[oldKey.ID]; exists {
+			t.Error("Old expired key still exists after cleanup")
+		}
+	})
 }
 
-func TestManagerRotateKey(t *testing.T) {
-	manager, err := NewManager(time.Minute, time.Hour, "test-encryption-key-123")
-	if err != nil {
-		t.Fatalf("NewManager error = %v", err)
-	}
+func TestManagerStop(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		manager.Start()
 
-	// manually rotate key to test
-	err = manager.rotateKey()
-	if err != nil {
-		t.Fatalf("rotateKey() error = %v", err)
-	}
+		// stop should not panic
+		manager.Stop()
 
-	if manager.currentKey == nil {
-		t.Error("Current key not set after rotation")
-	}
+		// stopping again should not panic
+		manager.Stop()
+	})
+}
 
-	oldKey := manager.currentKey
+func TestManagerES256Keys(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		if err := manager.SetAlgorithm("HS256"); err == nil {
+			t.Error("Expected error for unsupported algorithm")
+		}
 
-	// rotate again
-	err = manager.rotateKey()
-	if err != nil {
-		t.Fatalf("Second rotateKey() error = %v", err)
-	}
+		if err := manager.SetAlgorithm(AlgorithmES256); err != nil {
+			t.Fatalf("SetAlgorithm() error = %v", err)
+		}
 
-	if manager.currentKey == oldKey {
-		t.Error("Current key not updated after rotation")
-	}
+		if err := manager.rotateKey(); err != nil {
+			t.Fatalf("rotateKey() error = %v", err)
+		}
 
-	// should have both keys
-	if len(manager.keys) != 2 {
-		t.Errorf("Expected 2 keys after rotation, got %d", len(manager.keys))
-	}
+		// stored EC key survives the encrypted database round trip
+		signingKey := manager.GetSigningKey(false)
+		if signingKey == nil {
+			t.Fatal("No signing key returned")
+		}
+
+		if signingKey.Algorithm != AlgorithmES256 {
+			t.Errorf("Expected ES256 signing key, got %s", signingKey.Algorithm)
+		}
+
+		jwks, err := manager.GetJWKS()
+		if err != nil {
+			t.Fatalf("GetJWKS() error = %v", err)
+		}
+
+		if len(jwks.Keys) != 1 || jwks.Keys[0]["kty"] != "EC" {
+			t.Errorf("Expected a single EC key in JWKS, got %v", jwks.Keys)
+		}
+	})
 }
 
-func TestManagerCleanup(t *testing.T) {
-	manager, err := NewManager(time.Minute, time.Millisecond, "test-encryption-key-123") // very short retain period
-	if err != nil {
-		t.Fatalf("NewManager error = %v", err)
-	}
+func TestManagerRSAPolicy(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		if err := manager.SetRSAKeySize(1024); err == nil {
+			t.Error("Expected error for 1024 bit RSA keys")
+		}
 
-	// add an old expired key manually
-	oldKey := &Key{
-		ID:        "old-key",
-		CreatedAt: time.Now().Add(-2 * time.Hour),
-		ExpiresAt: time.Now().Add(-time.Hour),
-	}
+		if err := manager.SetAlgorithm(AlgorithmPS256); err != nil {
+			t.Fatalf("SetAlgorithm() error = %v", err)
+		}
+		if err := manager.SetRSAKeySize(3072); err != nil {
+			t.Fatalf("SetRSAKeySize() error = %v", err)
+		}
 
-	manager.keys[oldKey.ID] = oldKey
+		if err := manager.rotateKey(); err != nil {
+			t.Fatalf("rotateKey() error = %v", err)
+		}
 
-	// add current key
-	manager.rotateKey()
+		// the alg survives the database round trip - RSA keys alone would read back as RS256
+		signingKey := manager.GetSigningKey(false)
+		if signingKey == nil {
+			t.Fatal("No signing key returned")
+		}
 
-	initialCount := len(manager.keys)
+		if signingKey.Algorithm != AlgorithmPS256 {
+			t.Errorf("Expected PS256 signing key, got %s", signingKey.Algorithm)
+		}
 
-	// run cleanup
-	time.Sleep(2 * time.Millisecond) // wait for retain period
-	manager.cleanup()
+		if bits := signingKey.PrivateKey.(*rsa.PrivateKey).N.BitLen(); bits != 3072 {
+			t.Errorf("Expected 3072 bit key, got %d", bits)
+		}
 
-	// old key should be removed
-	if len(manager.keys) >= initialCount {
-		t.Error("Cleanup did not remove old keys")
-	}
+		jwks, err := manager.GetJWKS()
+		if err != nil {
+			t.Fatalf("GetJWKS() error = %v", err)
+		}
 
-	if _, exists := manager.keys[oldKey.ID]; exists {
-		t.Error("Old expired key still exists after cleanup")
-	}
+		if len(jwks.Keys) != 1 || jwks.Keys[0]["alg"] != AlgorithmPS256 {
+			t.Errorf("Expected a single PS256 key in JWKS, got %v", jwks.Keys)
+		}
+	})
 }
 
-func TestManagerStop(t *testing.T) {
-	manager, err := NewManager(time.Minute, time.Hour, "test-encryption-key-123")
+func TestManagerSigningKeyIsNewest(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		// test keys include a 1 hour key that outlives the rotated one
+		if err := manager.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		defer manager.Stop()
+
+		manager.mu.RLock()
+		rotated := manager.currentKey.ID
+		manager.mu.RUnlock()
+
+		for i := 0; i < 10; i++ {
+			if kid := manager.GetSigningKey(false).ID; kid != rotated {
+				t.Fatalf("Expected signing kid %s, got %s", rotated, kid)
+			}
+		}
+
+		if manager.ActiveKeyID() != rotated {
+			t.Errorf("ActiveKeyID() = %s, want %s", manager.ActiveKeyID(), rotated)
+		}
+
+		// older valid keys stay published for verification, in kid order
+		validKeys := manager.GetValidKeys()
+		if len(validKeys) < 2 {
+			t.Fatalf("Expected older keys to remain published, got %d", len(validKeys))
+		}
+		for i := 1; i < len(validKeys); i++ {
+			prev, _ := strconv.Atoi(validKeys[i-1].ID)
+			cur, _ := strconv.Atoi(validKeys[i].ID)
+			if prev >= cur {
+				t.Errorf("Valid keys not in kid order: %s before %s", validKeys[i-1].ID, validKeys[i].ID)
+			}
+		}
+	})
+}
+
+func TestManagerStagedRotation(t *testing.T) {
+	forEachBackend(t, time.Hour, time.Hour, func(t *testing.T, manager *Manager) {
+		if err := manager.SetRotationTiming(-time.Second, time.Minute); err == nil {
+			t.Error("Expected error for negative lead time")
+		}
+
+		if err := manager.rotateKeyAt(time.Now()); err != nil {
+			t.Fatalf("rotateKeyAt() error = %v", err)
+		}
+		active := manager.ActiveKeyID()
+
+		// with a lead time the new key is published but doesn't sign yet
+		if err := manager.SetRotationTiming(time.Hour, time.Minute); err != nil {
+			t.Fatalf("SetRotationTiming() error = %v", err)
+		}
+		if err := manager.rotateKey(); err != nil {
+			t.Fatalf("rotateKey() error = %v", err)
+		}
+
+		manager.mu.RLock()
+		pending := manager.currentKey
+		manager.mu.RUnlock()
+
+		if pending.Status != db.KeyStatusPending {
+			t.Errorf("Expected pending status, got %s", pending.Status)
+		}
+
+		if kid := manager.ActiveKeyID(); kid != active {
+			t.Errorf("Pending key took over signing: active kid %s, want %s", kid, active)
+		}
+
+		published := map[string]string{}
+		for _, key := range manager.GetValidKeys() {
+			published[key.ID] = key.Status
+		}
+		if published[pending.ID] != db.KeyStatusPending || published[active] != db.KeyStatusActive {
+			t.Errorf("Expected pending and active keys published, got %v", published)
+		}
+
+		// once due it signs and the old key is kept for verification
+		if err := manager.rotateKeyAt(time.Now()); err != nil {
+			t.Fatalf("rotateKeyAt() error = %v", err)
+		}
+
+		newActive := manager.ActiveKeyID()
+		if newActive == active {
+			t.Fatal("Active key did not change")
+		}
+
+		published = map[string]string{}
+		for _, key := range manager.GetValidKeys() {
+			published[key.ID] = key.Status
+		}
+		if published[active] != db.KeyStatusRetired {
+			t.Errorf("Expected replaced key retired and published, got %v", published)
+		}
+
+		// the scheduled key keeps its slot
+		if published[pending.ID] != db.KeyStatusPending {
+			t.Errorf("Expected scheduled key to stay pending, got %v", published)
+		}
+	})
+}
+
+func TestManagerSetLifetimes(t *testing.T) {
+	forEachBackend(t, time.Hour, time.Hour, func(t *testing.T, manager *Manager) {
+		if err := manager.SetLifetimes(0, time.Hour); err == nil {
+			t.Error("Expected error for zero key lifetime")
+		}
+		if err := manager.SetLifetimes(time.Hour, -time.Second); err == nil {
+			t.Error("Expected error for negative retain period")
+		}
+
+		if err := manager.SetAlgorithm(AlgorithmES256); err != nil {
+			t.Fatalf("SetAlgorithm() error = %v", err)
+		}
+		if err := manager.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		defer manager.Stop()
+		first := manager.ActiveKeyID()
+
+		// the running loop drops its hourly tick for the new interval
+		if err := manager.SetLifetimes(20*time.Millisecond, 2*time.Hour); err != nil {
+			t.Fatalf("SetLifetimes() error = %v", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for manager.ActiveKeyID() == first {
+			if time.Now().After(deadline) {
+				t.Fatal("Key did not rotate after the lifetime was shortened")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if retain := manager.interval(&manager.keyRetainPeriod); retain != 2*time.Hour {
+			t.Errorf("Expected retain period 2h, got %v", retain)
+		}
+	})
+}
+
+func TestManagerCleanupPurgesDatabase(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		if err := manager.SetCleanupPolicy(0, false); err == nil {
+			t.Error("Expected error for zero cleanup interval")
+		}
+
+		key, err := GenerateECKeyPair()
+		if err != nil {
+			t.Fatalf("GenerateECKeyPair() error = %v", err)
+		}
+		kid, err := manager.store.StoreKey(key.PrivateKey, key.Algorithm, time.Now().Add(-2*time.Hour))
+		if err != nil {
+			t.Fatalf("StoreKey() error = %v", err)
+		}
+
+		stillStored := func() bool {
+			expired, err := manager.store.GetExpiredKeys()
+			if err != nil {
+				t.Fatalf("GetExpiredKeys() error = %v", err)
+			}
+			_, ok := expired[kid]
+			return ok
+		}
+
+		// dry run leaves the row in place
+		if err := manager.SetCleanupPolicy(time.Minute, true); err != nil {
+			t.Fatalf("SetCleanupPolicy() error = %v", err)
+		}
+		manager.cleanup()
+		if !stillStored() {
+			t.Fatal("Dry run purged the key")
+		}
+
+		if err := manager.SetCleanupPolicy(time.Minute, false); err != nil {
+			t.Fatalf("SetCleanupPolicy() error = %v", err)
+		}
+		manager.cleanup()
+		if stillStored() {
+			t.Error("Key past KEY_RETAIN still stored after cleanup")
+		}
+	})
+}
+
+func TestManagerReportsStoredMetadata(t *testing.T) {
+	forEachBackend(t, time.Minute, time.Hour, func(t *testing.T, manager *Manager) {
+		started := time.Now().Truncate(time.Second)
+		if err := manager.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		defer manager.Stop()
+
+		origins := map[string]int{}
+		for _, key := range manager.GetValidKeys() {
+			origins[key.Origin]++
+
+			if key.CreatedAt.Before(started) || key.CreatedAt.After(time.Now()) {
+				t.Errorf("Key %s has fabricated creation time %v", key.ID, key.CreatedAt)
+			}
+			if key.KeySize != DefaultRSAKeySize {
+				t.Errorf("Key %s size = %d, want %d", key.ID, key.KeySize, DefaultRSAKeySize)
+			}
+			if key.Usage != "sig" || key.NotBefore.IsZero() {
+				t.Errorf("Key %s missing usage or not-before: %+v", key.ID, key)
+			}
+		}
+
+		if origins[db.KeyOriginTest] != 3 || origins[db.KeyOriginRotation] != 1 {
+			t.Errorf("Expected 3 test keys and 1 rotated key, got %v", origins)
+		}
+	})
+}
+
+func TestManagerMemoryDatabase(t *testing.T) {
+	// nothing touches the working directory
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	manager, err := NewManagerAt(db.MemoryPath, time.Minute, time.Hour, "test-encryption-key-123")
 	if err != nil {
-		t.Fatalf("NewManager error = %v", err)
+		t.Fatalf("NewManagerAt(:memory:) error = %v", err)
+	}
+	if err := manager.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
 	}
-	manager.Start()
+	defer manager.Stop()
 
-	// stop should not panic
-	manager.Stop()
+	if manager.GetSigningKey(false) == nil {
+		t.Fatal("Expected a signing key from the memory database")
+	}
+	if len(manager.GetValidKeys()) == 0 {
+		t.Error("Expected published keys from the memory database")
+	}
 
-	// stopping again should not panic
-	manager.Stop()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no files written for :memory:, got %d entries", len(entries))
+	}
 }
@@ -0,0 +1,42 @@
+package keys
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"csce-3550_jwks-srv/internal/db"
+)
+
+// forEachBackend runs test as a subtest against a manager on every storage backend.
+// SQLite lives in a temp dir and the memory store writes nothing, so neither touches the cwd.
+func forEachBackend(t *testing.T, keyLifetime, keyRetainPeriod time.Duration, test func(t *testing.T, manager *Manager)) {
+	t.Helper()
+
+	backends := []struct {
+		name string
+		open func(t *testing.T) db.Store
+	}{
+		{db.BackendSQLite, func(t *testing.T) db.Store {
+			store, err := db.NewManager(filepath.Join(t.TempDir(), "keys.db"), "test-encryption-key-123")
+			if err != nil {
+				t.Fatalf("db.NewManager() error = %v", err)
+			}
+			return store
+		}},
+		{db.BackendMemory, func(t *testing.T) db.Store {
+			return db.NewMemoryStore()
+		}},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			defer store.Close()
+
+			manager := NewManagerWithStore(store, keyLifetime, keyRetainPeriod)
+			defer manager.Stop()
+			test(t, manager)
+		})
+	}
+}